@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"memorial_app_server/log"
 	"memorial_app_server/service/database"
+	"memorial_app_server/service/state"
 	"net/http"
 )
 
@@ -16,7 +17,7 @@ func alertNewVersion(c *gin.Context) {
 }
 
 func onlineUserCount(c *gin.Context) {
-	c.JSON(http.StatusOK, len(SocketBundles))
+	c.JSON(http.StatusOK, onlineUsers())
 }
 
 func userCount(c *gin.Context) {
@@ -29,6 +30,22 @@ func userCount(c *gin.Context) {
 	c.JSON(http.StatusOK, count)
 }
 
+func verifyChain(c *gin.Context) {
+	uid := c.Query("user_id")
+	if uid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	report, err := state.VerifyChain(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
 func AdminMiddleware(c *gin.Context) {
 	// get uid from context
 	uid, ok := c.Get("uid")
@@ -59,4 +76,5 @@ func UseAdminRouter(g *gin.RouterGroup) {
 	sg.POST("/alert-new-version", alertNewVersion)
 	sg.GET("/online-user-count", onlineUserCount)
 	sg.GET("/user-count", userCount)
+	sg.GET("/verify-chain", verifyChain)
 }
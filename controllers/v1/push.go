@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"fmt"
+	"memorial_app_server/log"
+	"memorial_app_server/service/push"
+	"memorial_app_server/service/reminder"
+	"memorial_app_server/service/state"
+	"strconv"
+)
+
+// notifyOfflineDevices pushes notification to registered devices of user without live socket
+func notifyOfflineDevices(uid string, notification *push.Notification) {
+	if push.Pusher == nil {
+		return
+	}
+
+	// devices identified by their token on connection get it over websocket
+	connected := make(map[string]bool)
+	for _, sock := range userSockets(uid) {
+		if sock.DeviceToken != "" {
+			connected[sock.DeviceToken] = true
+		}
+	}
+
+	go func() {
+		sent, err := push.Pusher.NotifyUser(uid, notification, connected)
+		if err != nil {
+			log.Errorf("Failed to push notification to user %s: %v", uid, err)
+			return
+		}
+		if sent > 0 {
+			log.Debugf("notification pushed to %d devices of user %s", sent, uid)
+		}
+	}()
+}
+
+func reminderNotification(r *reminder.Reminder) *push.Notification {
+	// title of secret task is not shown on lock screen, encrypted one can't be
+	title := r.Title
+	if r.Protected && secretsProtected(r.UserId) || state.IsEnvelope(title) {
+		title = "Reminder"
+	}
+	return &push.Notification{
+		Title: title,
+		Body:  reminderBody(r.Offset),
+		Data: map[string]string{
+			"type":    "reminder",
+			"taskId":  r.TaskId,
+			"dueDate": strconv.FormatInt(r.DueDate, 10),
+			"fireAt":  strconv.FormatInt(r.FireAt, 10),
+		},
+	}
+}
+
+// reminderBody describes how long is left until due date
+func reminderBody(offset int64) string {
+	switch {
+	case offset == 0:
+		return "Due now"
+	case offset%(24*60) == 0:
+		return plural(offset/(24*60), "day")
+	case offset%60 == 0:
+		return plural(offset/60, "hour")
+	}
+	return plural(offset, "minute")
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("Due in 1 %s", unit)
+	}
+	return fmt.Sprintf("Due in %d %ss", n, unit)
+}
+
+// syncNotification wakes up devices to sync the new block made on another device
+func syncNotification(lastBlockNumber int64) *push.Notification {
+	return &push.Notification{
+		Silent: true,
+		Data: map[string]string{
+			"type":            "sync",
+			"lastBlockNumber": strconv.FormatInt(lastBlockNumber, 10),
+		},
+	}
+}
@@ -12,6 +12,37 @@ type SignupRequestDto struct {
 	EncryptedPassword string `json:"encrypted_password" binding:"required"`
 }
 
+type UpdateTimezoneRequestDto struct {
+	// IANA timezone name (e.g. "Asia/Seoul")
+	Timezone string `json:"timezone" binding:"required"`
+}
+
+type RegisterDeviceRequestDto struct {
+	// "fcm" or "apns"
+	Platform string `json:"platform" binding:"required"`
+	Token    string `json:"token" binding:"required"`
+}
+
+type UnregisterDeviceRequestDto struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type UpdateDigestRequestDto struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+	// local hour (0-23) to deliver digest, unchanged if omitted
+	Hour *int `json:"hour"`
+}
+
+type UpdatePinRequestDto struct {
+	// required if user already has PIN
+	CurrentPin string `json:"currentPin"`
+	Pin        string `json:"pin" binding:"required"`
+}
+
+type DeletePinRequestDto struct {
+	CurrentPin string `json:"currentPin" binding:"required"`
+}
+
 type SignupWithGoogleAuthRequestDto struct {
 	SignupRequestDto
 	GoogleAuthId          string `json:"google_auth_id" binding:"required"`
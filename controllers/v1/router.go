@@ -23,6 +23,7 @@ func UseRouterV1(r *gin.Engine) {
 	UseGoogleAuthRouter(g)
 	UseAdminRouter(g)
 	UseTokenRouter(g)
+	UseUserRouter(g)
 	UseTestRouter(g) // comment this on production
 	UseSocketRouter(g)
 }
@@ -0,0 +1,91 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"memorial_app_server/log"
+	"memorial_app_server/service/pin"
+	"memorial_app_server/service/reminder"
+	"memorial_app_server/service/state"
+	"memorial_app_server/util"
+)
+
+// secretsVisible reports whether tasks of secret and locked categories are sent as they are to the session.
+// categories of user without PIN are not protected.
+func secretsVisible(socket *UserSocket, uid string) bool {
+	return socket.Unlocked() || !secretsProtected(uid)
+}
+
+// secretsProtected reports whether user has PIN protecting secret and locked categories
+func secretsProtected(uid string) bool {
+	if pin.Pins == nil {
+		return false
+	}
+	hasPin, err := pin.Pins.HasPin(uid)
+	if err != nil {
+		log.Errorf("Failed to check pin of user %s: %v", uid, err)
+		return true
+	}
+	return hasPin
+}
+
+// lastProtectedTaskIds returns ids of tasks protected in the last state of chain.
+// tasks moved into secret categories later are redacted from historic blocks too.
+func lastProtectedTaskIds(userChain *state.Chain) map[string]bool {
+	lastState := userChain.GetLastState()
+	if lastState == nil {
+		return nil
+	}
+	return lastState.ProtectedTaskIds()
+}
+
+// redactBlock redacts tasks protected before or after the block or in lastProtected,
+// prevState is read from the chain if nil
+func redactBlock(userChain *state.Chain, prevState *state.State, block *state.Block, lastProtected map[string]bool) *state.Block {
+	if prevState == nil && block.Number > 0 {
+		if prevBlock, err := userChain.GetBlockByNumber(block.Number - 1); err == nil {
+			prevState = prevBlock.State
+		} else {
+			log.Warnf("Failed to get block #%d to redact block #%d: %v", block.Number-1, block.Number, err)
+		}
+	}
+	return state.RedactBlock(prevState, block, lastProtected)
+}
+
+// unlockSecrets unlocks secret and locked categories on the session with PIN of user
+func unlockSecrets(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
+	var request UnlockSecretsSocketRequest
+	if err := util.InterfaceToStruct(data, &request); err != nil {
+		log.Errorf("Failed to unmarshal data: %v", data)
+		return nil, fmt.Errorf("invalid request: check format")
+	}
+	if pin.Pins == nil {
+		return nil, errors.New("pin is not enabled")
+	}
+
+	if err := pin.Pins.Verify(uid, request.Pin); err != nil {
+		if errors.Is(err, pin.ErrWrongPin) || errors.Is(err, pin.ErrNoPin) || errors.Is(err, pin.ErrTooManyAttempts) {
+			log.Warnf("Failed to unlock secrets of user %s [%s]: %v", uid, socket.ConnectionId, err)
+			return nil, err
+		}
+		log.Errorf("Failed to verify pin of user %s: %v", uid, err)
+		return nil, fmt.Errorf("failed to verify pin")
+	}
+	socket.SetUnlocked(true)
+	return true, nil
+}
+
+func lockSecrets(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
+	socket.SetUnlocked(false)
+	return true, nil
+}
+
+// reminderFor returns reminder without title if its task is protected from the session
+func reminderFor(socket *UserSocket, r *reminder.Reminder) *reminder.Reminder {
+	if !r.Protected || secretsVisible(socket, r.UserId) {
+		return r
+	}
+	redacted := *r
+	redacted.Title = ""
+	return &redacted
+}
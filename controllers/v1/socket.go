@@ -7,8 +7,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"memorial_app_server/log"
+	"memorial_app_server/service/reminder"
 	"memorial_app_server/service/state"
 	"memorial_app_server/util"
+	"sync"
 )
 
 type socketHandler func(socket *UserSocket, uid string, data interface{}) (interface{}, error)
@@ -28,10 +30,40 @@ var (
 		"blockByBlockNumber":     blockByBlockNumber,
 		"stateByBlockNumber":     stateByBlockNumber,
 		"clearStatePermanently":  clearStatePermanently,
+		"capabilities":           capabilities,
+		"taskProof":              taskProof,
+		"taskOccurrenceStats":    taskOccurrenceStats,
+		"unlockSecrets":          unlockSecrets,
+		"lockSecrets":            lockSecrets,
 	}
 	SocketBundles = map[string]*UserSocketBundle{}
+	// socketBundlesLock guards SocketBundles and sockets of bundles, they are read by the reminder scheduler too
+	socketBundlesLock = &sync.Mutex{}
 )
 
+// userSockets returns snapshot of live sockets of user
+func userSockets(uid string) []*UserSocket {
+	socketBundlesLock.Lock()
+	defer socketBundlesLock.Unlock()
+
+	bundle, ok := SocketBundles[uid]
+	if !ok {
+		return nil
+	}
+	sockets := make([]*UserSocket, 0, bundle.GetSize())
+	for _, sock := range bundle.sockets {
+		sockets = append(sockets, sock)
+	}
+	return sockets
+}
+
+// onlineUsers returns number of users with live sockets
+func onlineUsers() int {
+	socketBundlesLock.Lock()
+	defer socketBundlesLock.Unlock()
+	return len(SocketBundles)
+}
+
 func test(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
 	// data to string
 	str, ok := data.(string)
@@ -47,87 +79,146 @@ func handleTransaction(socket *UserSocket, uid string, data interface{}) (interf
 		log.Errorf("Failed to unmarshal data: %v", data)
 		return nil, fmt.Errorf("invalid request: check format")
 	}
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 
 	log.Debug(request)
 
-	// check version
-	if request.Version != state.SchemeVersion {
-		log.Errorf("Invalid version: waiting for %d, but %d given", state.SchemeVersion, request.Version)
-		return nil, fmt.Errorf("invalid version: waiting for %d", state.SchemeVersion)
-	}
-
-	// check if targetBlockNumber is valid
-	waitingBlockNumber := userChain.GetWaitingBlockNumber()
-	initializing := request.Type == state.TxInitialize
-	if !initializing && request.BlockNumber != waitingBlockNumber {
-		// different block number
-		log.Errorf("Invalid target block number: waiting for block #%d, but #%d given", waitingBlockNumber, request.BlockNumber)
-		return nil, fmt.Errorf("invalid block number: waiting for block #%d, but #%d given", waitingBlockNumber, request.BlockNumber)
+	// check version (older versions in the window are upcasted on execution)
+	if err := state.CheckSchemeVersion(request.Version); err != nil {
+		log.Errorf("Invalid version: %v", err)
+		return err, err
 	}
 
 	// check if transaction is valid
 	tx := state.NewTransaction(request.Version, uid, request.Type, request.Timestamp, request.Content, request.Hash)
 	if err := tx.Validate(); err != nil {
 		log.Error("Invalid transaction")
+		var unsupported *state.UnsupportedTxTypeError
+		if errors.As(err, &unsupported) {
+			return unsupported, unsupported
+		}
 		return nil, fmt.Errorf("invalid request: %s", err.Error())
 	}
 
-	// check transaction hash (doesn't check - v0.2.2)
-	//if request.Hash != tx.Hash {
-	//	log.Errorf("Invalid transaction hash: expected for %s, but %s given", tx.Hash, request.Hash)
-	//	return nil, fmt.Errorf("invalid transaction hash: waiting for %s", tx.Hash)
-	//}
-
-	// check block hash (expect)
-	//if !initializing {
-	//	prevBlock, err := userChain.GetBlockByNumber(request.BlockNumber - 1)
-	//	if err != nil {
-	//		log.Errorf("Failed to get previous block: %v", err)
-	//		return nil, fmt.Errorf("failed to get previous block: %s", err.Error())
-	//	}
-	//	prevBlockHash := prevBlock.Hash
-	//	expectedBlockHash := state.ExpectedBlockHash(request.BlockNumber, request.Hash, prevBlockHash).Hex()
-	//	if request.BlockHash != expectedBlockHash {
-	//		log.Errorf("Invalid block hash: expected for %s, but %s given", expectedBlockHash, request.BlockHash)
-	//		return nil, fmt.Errorf("invalid block hash: waiting for %s, given: %s", expectedBlockHash, request.BlockHash)
-	//	}
-	//}
-
-	// apply transaction
-	newBlock, err := userChain.ApplyTransaction(tx, request.BlockNumber)
+	// check transaction hash & block hash (expect) by policy,
+	// block number is checked on submitting (block hash of stale transaction is checked on rebasing)
+	initializing := request.Type == state.TxInitialize
+	if mismatch, err := checkHashes(userChain, tx, request, initializing || request.BlockNumber != userChain.GetWaitingBlockNumber()); err != nil {
+		return nil, err
+	} else if mismatch != nil {
+		return mismatch, mismatch
+	}
+
+	// apply transaction, stale transaction (built on older block) is rebased on the last block
+	newBlock, rebased, err := userChain.SubmitTransaction(tx, request.BlockNumber, request.BlockHash)
 	if err != nil {
+		var rebaseConflict *state.RebaseConflictError
+		if errors.As(err, &rebaseConflict) {
+			log.Warnf("Transaction rejected as conflict: %v", rebaseConflict)
+			return rebaseConflict, rebaseConflict
+		}
+		var revertConflict *state.RevertConflictError
+		if errors.As(err, &revertConflict) {
+			log.Warnf("Revert rejected as conflict: %v", revertConflict)
+			return revertConflict, revertConflict
+		}
 		log.Errorf("Error during applying transaction: %v", err)
 		return nil, fmt.Errorf("failed to apply transaction: %s", err.Error())
 	}
+	if rebased {
+		log.Infof("Transaction for block #%d rebased to block #%d", request.BlockNumber, newBlock.Number)
+	}
 
+	userChain.Retain()
 	go func() {
+		defer userChain.Release()
 		// broadcast transaction to same user connections
-		bundle, ok := SocketBundles[uid]
-		if !ok {
+		sockets := userSockets(uid)
+		if len(sockets) == 0 {
 			log.Warnf("Couldn't find socket bundle for user %s", uid)
 		}
 
 		updatedLastBlockNumber := userChain.GetLastBlockNumber()
 
-		for _, sock := range bundle.sockets {
+		// other devices without live connection sync on push
+		notifyOfflineDevices(uid, syncNotification(updatedLastBlockNumber))
+
+		// sessions not unlocked get the block without text of secret tasks
+		var redactedBlock *state.Block
+		for _, sock := range sockets {
 			// send updated waiting block number
 			if err := sock.Emit("last_block_number", updatedLastBlockNumber); err != nil {
 				log.Warnf("Failed to broadcast waiting block number to user %s [%s]", uid, sock.ConnectionId)
 			}
 
 			// send transaction
-			if err := sock.Emit("broadcast_transaction", newBlock); err != nil {
+			block := newBlock
+			if !secretsVisible(sock, uid) {
+				if redactedBlock == nil {
+					redactedBlock = redactBlock(userChain, nil, newBlock, lastProtectedTaskIds(userChain))
+				}
+				block = redactedBlock
+			}
+			if err := sock.Emit("broadcast_transaction", block); err != nil {
 				log.Warnf("Failed to broadcast transaction to user %s [%s]", uid, sock.ConnectionId)
 			}
 		}
 	}()
 
+	return &TxSocketResponse{
+		BlockNumber: newBlock.Number,
+		Rebased:     rebased,
+	}, nil
+}
+
+// checkHashes returns hash mismatch only if it should be rejected by state.TxHashPolicy,
+// block hash is not checked if skipBlockHash (initializing or rebasing)
+func checkHashes(userChain *state.Chain, tx *state.Transaction, request TxSocketRequest, skipBlockHash bool) (*state.HashMismatchError, error) {
+	if state.TxHashPolicy == state.HashPolicyOff {
+		return nil, nil
+	}
+
+	mismatches := make([]*state.HashMismatchError, 0)
+	if mismatch := state.CheckTransactionHash(tx); mismatch != nil {
+		mismatches = append(mismatches, mismatch)
+	}
+	if !skipBlockHash {
+		mismatch, err := state.CheckBlockHash(userChain, tx, request.BlockNumber, request.BlockHash)
+		if err != nil {
+			log.Errorf("Failed to get previous block: %v", err)
+			return nil, fmt.Errorf("failed to get previous block: %s", err.Error())
+		}
+		if mismatch != nil {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+
+	for _, mismatch := range mismatches {
+		log.Warnf("Hash mismatch on block #%d: %v", request.BlockNumber, mismatch)
+	}
+	if len(mismatches) > 0 && state.TxHashPolicy == state.HashPolicyReject {
+		return mismatches[0], nil
+	}
 	return nil, nil
 }
 
+// capabilities lists transaction types & operations supported by server
+func capabilities(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
+	return state.GetCapabilities(), nil
+}
+
 func lastRemoteBlock(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	lastBlockNumber := userChain.GetLastBlockNumber()
 	lastBlock, err := userChain.GetBlockByNumber(lastBlockNumber)
 	if err != nil {
@@ -144,7 +235,12 @@ func txHashByBlockNumber(socket *UserSocket, uid string, data interface{}) (inte
 		return nil, fmt.Errorf("invalid request: check format")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	block, err := userChain.GetBlockByNumber(request.BlockNumber)
 	if err != nil {
 		log.Errorf("Failed to get block: %v", err)
@@ -166,7 +262,12 @@ func blockHashByBlockNumber(socket *UserSocket, uid string, data interface{}) (i
 		return nil, fmt.Errorf("invalid request: check format")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	block, err := userChain.GetBlockByNumber(request.BlockNumber)
 	if err != nil {
 		log.Errorf("Failed to get block: %v", err)
@@ -177,12 +278,22 @@ func blockHashByBlockNumber(socket *UserSocket, uid string, data interface{}) (i
 }
 
 func waitingBlockNumber(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	return userChain.GetWaitingBlockNumber(), nil
 }
 
 func lastBlockNumber(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	return userChain.GetLastBlockNumber(), nil
 }
 
@@ -193,7 +304,12 @@ func syncBlocks(socket *UserSocket, uid string, data interface{}) (interface{},
 		return nil, fmt.Errorf("invalid request: check format")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 
 	startBlockNumber := request.StartBlockNumber
 	endBlockNumber := request.EndBlockNumber
@@ -211,6 +327,15 @@ func syncBlocks(socket *UserSocket, uid string, data interface{}) (interface{},
 		return nil, fmt.Errorf("failed to fetch blocks: %s", err.Error())
 	}
 
+	if !secretsVisible(socket, uid) {
+		var prevState *state.State
+		lastProtected := lastProtectedTaskIds(userChain)
+		for i, block := range blocks {
+			blocks[i] = redactBlock(userChain, prevState, block, lastProtected)
+			prevState = block.State
+		}
+	}
+
 	return blocks, nil
 }
 
@@ -222,10 +347,10 @@ func commitTransactions(socket *UserSocket, uid string, data interface{}) (inter
 	}
 
 	for _, txReq := range request {
-		_, err := handleTransaction(socket, uid, txReq)
+		resp, err := handleTransaction(socket, uid, txReq)
 		if err != nil {
 			log.Error("Error during handling transaction: ", err)
-			return nil, err
+			return resp, err
 		}
 	}
 
@@ -245,21 +370,26 @@ func deleteMismatchBlocks(socket *UserSocket, uid string, data interface{}) (int
 		return nil, fmt.Errorf("invalid block number range: start block number is greater than end block number")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	if err := userChain.DeleteBlockByInterval(request.StartBlockNumber, request.EndBlockNumber); err != nil {
 		log.Error("Error during deleting blocks: ", err)
 		return nil, fmt.Errorf("failed to delete blocks: %s", err.Error())
 	}
 
 	// broadcast transaction to same user connections
-	bundle, ok := SocketBundles[uid]
-	if !ok {
+	sockets := userSockets(uid)
+	if len(sockets) == 0 {
 		log.Warnf("Couldn't find socket bundle for user %s", uid)
 	}
 
 	updatedLastBlockNumber := userChain.GetLastBlockNumber()
 
-	for _, sock := range bundle.sockets {
+	for _, sock := range sockets {
 		// send transaction
 
 		if sock.ConnectionId != socket.ConnectionId {
@@ -285,13 +415,21 @@ func blockByBlockNumber(socket *UserSocket, uid string, data interface{}) (inter
 		return nil, fmt.Errorf("invalid request: check format")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	block, err := userChain.GetBlockByNumber(request.BlockNumber)
 	if err != nil {
 		log.Errorf("Failed to get block: %v", err)
 		return nil, fmt.Errorf("failed to get block: %s", err.Error())
 	}
 
+	if !secretsVisible(socket, uid) {
+		return redactBlock(userChain, nil, block, lastProtectedTaskIds(userChain)), nil
+	}
 	return block, nil
 }
 
@@ -302,7 +440,12 @@ func stateByBlockNumber(socket *UserSocket, uid string, data interface{}) (inter
 		return nil, fmt.Errorf("invalid request: check format")
 	}
 
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	block, err := userChain.GetBlockByNumber(request.BlockNumber)
 	if err != nil {
 		log.Errorf("Failed to get block: %v", err)
@@ -314,11 +457,95 @@ func stateByBlockNumber(socket *UserSocket, uid string, data interface{}) (inter
 		return nil, fmt.Errorf("failed to get state from block: %v", block)
 	}
 
+	if !secretsVisible(socket, uid) {
+		return blockState.RedactWith(lastProtectedTaskIds(userChain)), nil
+	}
 	return blockState, nil
 }
 
+// taskProof returns inclusion proof of a task in the state root of block (the last block if block number is 0).
+// state root is not bound by block hash, the proof is only as trustworthy as the server.
+func taskProof(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
+	var request TaskProofSocketRequest
+	if err := util.InterfaceToStruct(data, &request); err != nil {
+		log.Errorf("Failed to unmarshal data: %v", data)
+		return nil, fmt.Errorf("invalid request: check format")
+	}
+
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
+	blockNumber := request.BlockNumber
+	if blockNumber == 0 {
+		blockNumber = userChain.GetLastBlockNumber()
+	}
+	block, err := userChain.GetBlockByNumber(blockNumber)
+	if err != nil {
+		log.Errorf("Failed to get block: %v", err)
+		return nil, fmt.Errorf("failed to get block: %s", err.Error())
+	}
+
+	proof, err := block.State.TaskProof(request.TaskId)
+	if err != nil {
+		log.Errorf("Failed to build proof of task %s: %v", request.TaskId, err)
+		return nil, fmt.Errorf("failed to build proof: %s", err.Error())
+	}
+
+	task := block.State.Tasks[request.TaskId]
+	if !secretsVisible(socket, uid) {
+		if lastProtected := lastProtectedTaskIds(userChain); lastProtected[task.Id] || block.State.ProtectedTaskIds()[task.Id] {
+			task = block.State.RedactWith(lastProtected).Tasks[task.Id]
+		}
+	}
+
+	return &TaskProofSocketResponse{
+		BlockNumber: block.Number,
+		BlockHash:   block.Hash,
+		StateRoot:   block.StateRoot,
+		Task:        task,
+		Proof:       proof,
+	}, nil
+}
+
+// taskOccurrenceStats returns streaks and completion rate of repeating task in the last state
+func taskOccurrenceStats(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
+	var request TaskOccurrenceStatsSocketRequest
+	if err := util.InterfaceToStruct(data, &request); err != nil {
+		log.Errorf("Failed to unmarshal data: %v", data)
+		return nil, fmt.Errorf("invalid request: check format")
+	}
+
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
+	lastState := userChain.GetLastState()
+	if lastState == nil {
+		return nil, fmt.Errorf("failed to get last state")
+	}
+	task, ok := lastState.Tasks[request.TaskId]
+	if !ok {
+		return nil, state.ErrTaskNotFound
+	}
+
+	return &TaskOccurrenceStatsSocketResponse{
+		OccurrenceStats: task.OccurrenceStats(),
+		Occurrences:     task.Occurrences,
+	}, nil
+}
+
 func clearStatePermanently(socket *UserSocket, uid string, data interface{}) (interface{}, error) {
-	userChain := state.Chains.GetChain(uid)
+	userChain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		log.Errorf("Failed to load chain: %v", err)
+		return nil, fmt.Errorf("failed to load chain: %s", err.Error())
+	}
+	defer userChain.Release()
 	if err := userChain.Clear(); err != nil {
 		log.Errorf("Failed to clear chain: %v", err)
 		return nil, fmt.Errorf("failed to clear chain: %s", err.Error())
@@ -326,6 +553,38 @@ func clearStatePermanently(socket *UserSocket, uid string, data interface{}) (in
 	return nil, nil
 }
 
+// DeliverReminder emits reminder to live connections of user, returns false if user has none.
+// it's pushed to devices without live connection.
+func DeliverReminder(r *reminder.Reminder) bool {
+	notifyOfflineDevices(r.UserId, reminderNotification(r))
+
+	delivered := false
+	for _, sock := range userSockets(r.UserId) {
+		if err := sock.Emit("reminder", reminderFor(sock, r)); err != nil {
+			log.Warnf("Failed to emit reminder to user %s [%s]", r.UserId, sock.ConnectionId)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+func emitPendingReminders(socket *UserSocket, uid string) {
+	if reminder.Scheduler == nil {
+		return
+	}
+	pending, err := reminder.Scheduler.Pending(uid)
+	if err != nil {
+		log.Errorf("Failed to get pending reminders of user %s: %v", uid, err)
+		return
+	}
+	for _, r := range pending {
+		if err := socket.Emit("reminder", reminderFor(socket, r)); err != nil {
+			log.Warnf("Failed to emit pending reminder to user %s [%s]", uid, socket.ConnectionId)
+		}
+	}
+}
+
 func SocketV1(c *gin.Context) {
 	upgrader := websocket.Upgrader{}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -348,9 +607,9 @@ func SocketV1(c *gin.Context) {
 
 	printStat(connectionId, uid, "connected")
 
-	var socketBundle *UserSocketBundle
-	var bundleExists bool
-	if socketBundle, bundleExists = SocketBundles[uid]; !bundleExists {
+	socketBundlesLock.Lock()
+	socketBundle, bundleExists := SocketBundles[uid]
+	if !bundleExists {
 		// socket bundle has to be created
 		socketBundle = NewUserSocketBundle(uid)
 		SocketBundles[uid] = socketBundle
@@ -381,12 +640,19 @@ func SocketV1(c *gin.Context) {
 
 		return nil
 	})
+	socket.DeviceToken = c.Query("deviceToken")
+	socketBundlesLock.Unlock()
+
+	// reminders fired while user was offline
+	go emitPendingReminders(socket, uid)
 
 	defer func() {
+		socketBundlesLock.Lock()
 		socketBundle.RemoveSocket(connectionId)
-		if socketBundle.GetSize() == 0 {
+		if socketBundle.GetSize() == 0 && SocketBundles[uid] == socketBundle {
 			delete(SocketBundles, uid)
 		}
+		socketBundlesLock.Unlock()
 		conn.Close()
 	}()
 
@@ -3,7 +3,9 @@ package v1
 import (
 	"encoding/json"
 	"github.com/gorilla/websocket"
+	"memorial_app_server/service/state"
 	"sync"
+	"sync/atomic"
 )
 
 type SocketPacket struct {
@@ -39,7 +41,9 @@ type UserSocket struct {
 	ConnectionId string
 	Conn         *websocket.Conn
 	Emitter      UserSocketEmitter
+	DeviceToken  string // push token of device if given on connection, it's not pushed while connected
 	connMutex    sync.Mutex
+	unlocked     atomic.Bool // secret categories are unlocked by PIN on this session
 }
 
 func NewUserSocket(connectionId string, conn *websocket.Conn, emitter UserSocketEmitter) *UserSocket {
@@ -50,6 +54,14 @@ func NewUserSocket(connectionId string, conn *websocket.Conn, emitter UserSocket
 	}
 }
 
+func (s *UserSocket) Unlocked() bool {
+	return s.unlocked.Load()
+}
+
+func (s *UserSocket) SetUnlocked(unlocked bool) {
+	s.unlocked.Store(unlocked)
+}
+
 func (s *UserSocket) Emit(topic string, data interface{}) error {
 	//log.Debug("emit -> ", topic, data)
 	//s.connMutex.Lock()
@@ -99,6 +111,11 @@ type TxSocketRequest struct {
 	BlockHash   string      `json:"blockHash"`
 }
 
+type TxSocketResponse struct {
+	BlockNumber int64 `json:"blockNumber"`
+	Rebased     bool  `json:"rebased"` // whether applied on the later block than requested
+}
+
 type TxHashByBlockNumberSocketRequest struct {
 	BlockNumber int64 `json:"blockNumber"`
 }
@@ -126,3 +143,29 @@ type StateByBlockNumberSocketRequest struct {
 type BlockByBlockNumberSocketRequest struct {
 	BlockNumber int64 `json:"blockNumber"`
 }
+
+type TaskProofSocketRequest struct {
+	TaskId      string `json:"taskId"`
+	BlockNumber int64  `json:"blockNumber"` // 0 for the last block
+}
+
+type TaskProofSocketResponse struct {
+	BlockNumber int64              `json:"blockNumber"`
+	BlockHash   string             `json:"blockHash"`
+	StateRoot   string             `json:"stateRoot"`
+	Task        state.Task         `json:"task"`
+	Proof       *state.MerkleProof `json:"proof"`
+}
+
+type TaskOccurrenceStatsSocketRequest struct {
+	TaskId string `json:"taskId"`
+}
+
+type TaskOccurrenceStatsSocketResponse struct {
+	state.OccurrenceStats
+	Occurrences []state.Occurrence `json:"occurrences"`
+}
+
+type UnlockSecretsSocketRequest struct {
+	Pin string `json:"pin"`
+}
@@ -14,14 +14,12 @@ func StateHash(c *gin.Context) {
 	resp := make(map[string]string)
 	if blockNumber == "" {
 		// get all chains
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			resp[uid] = chain.GetLastState().Hash().Hex()
 		}
 	} else {
 		bn, _ := strconv.Atoi(blockNumber)
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			block, _ := chain.GetBlockByNumber(int64(bn))
 			resp[uid] = block.State.Hash().Hex()
 		}
@@ -36,14 +34,12 @@ func State(c *gin.Context) {
 	resp := make(map[string]state.State)
 	if blockNumber == "" {
 		// get all chains
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			resp[uid] = *chain.GetLastState()
 		}
 	} else {
 		bn, _ := strconv.Atoi(blockNumber)
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			block, _ := chain.GetBlockByNumber(int64(bn))
 			blockState := block.State
 			resp[uid] = *blockState
@@ -59,8 +55,7 @@ func Transaction(c *gin.Context) {
 	resp := make(map[string]state.Transaction)
 	if blockNumber == "" {
 		// get all chains
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			lastBn := chain.GetLastBlockNumber()
 			block, _ := chain.GetBlockByNumber(lastBn)
 			tx := block.Updates.SrcTx
@@ -68,8 +63,7 @@ func Transaction(c *gin.Context) {
 		}
 	} else {
 		bn, _ := strconv.Atoi(blockNumber)
-		cluster := state.Chains
-		for uid, chain := range *cluster {
+		for uid, chain := range state.Chains.LoadedChains() {
 			block, _ := chain.GetBlockByNumber(int64(bn))
 			tx := block.Updates.SrcTx
 			resp[uid] = *tx
@@ -89,7 +83,7 @@ func Task(c *gin.Context) {
 		Task        state.DirectionalTask `json:"task"`
 	}
 	resp := make(map[string]info)
-	for uid, chain := range *state.Chains {
+	for uid, chain := range state.Chains.LoadedChains() {
 		lastState := chain.GetLastState()
 		lastBlockNumber := chain.GetLastBlockNumber()
 		tasks, err := lastState.SortTasks()
@@ -112,11 +106,12 @@ func Diagram(c *gin.Context) {
 		c.JSON(400, "user_id is required")
 		return
 	}
-	chain, ok := (*state.Chains)[uid]
-	if !ok {
-		c.JSON(404, "user_id not found")
+	chain, err := state.Chains.GetChain(uid)
+	if err != nil {
+		c.JSON(500, err)
 		return
 	}
+	defer chain.Release()
 	lastState := chain.GetLastState()
 	diagram, err := lastState.Diagram()
 	if err != nil {
@@ -0,0 +1,251 @@
+package v1
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"memorial_app_server/log"
+	"memorial_app_server/service/database"
+	"memorial_app_server/service/digest"
+	"memorial_app_server/service/pin"
+	"memorial_app_server/service/push"
+	"memorial_app_server/service/reminder"
+	"memorial_app_server/service/state"
+	"net/http"
+)
+
+func getTimezone(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	timezone, err := state.GetUserTimezone(uid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"timezone": timezone})
+}
+
+// updateTimezone sets timezone used for recurrence of user's tasks
+func updateTimezone(c *gin.Context) {
+	var body UpdateTimezoneRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid := c.GetString("uid")
+
+	if err := state.SetUserTimezone(uid, body.Timezone); err != nil {
+		if errors.Is(err, state.ErrInvalidTimezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// reminders of all-day tasks follow the timezone
+	if reminder.Scheduler != nil {
+		if err := reminder.Scheduler.RefreshUser(uid); err != nil {
+			log.Errorf("Failed to refresh reminders of user %s: %v", uid, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"timezone": body.Timezone})
+}
+
+// registerDevice registers push token of device, to be notified while it has no live socket
+func registerDevice(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	var body RegisterDeviceRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := push.ValidatePlatform(body.Platform); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if push.Pusher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "push notification is not enabled"})
+		return
+	}
+
+	device := push.Device{UserId: uid, Platform: body.Platform, Token: body.Token}
+	if err := push.Pusher.Tokens().Register(device); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+func unregisterDevice(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	var body UnregisterDeviceRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if push.Pusher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "push notification is not enabled"})
+		return
+	}
+
+	if err := push.Pusher.Tokens().Unregister(uid, body.Token); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func getDigestSettings(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	settings, err := digest.Digests.Settings().Get(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// updateDigestSettings opts user in or out of daily digest email and sets its delivery hour
+func updateDigestSettings(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	var body UpdateDigestRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := digest.Digests.Settings().Get(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	settings.Enabled = *body.Enabled
+	if body.Hour != nil {
+		if err := digest.ValidateHour(*body.Hour); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		settings.Hour = *body.Hour
+	}
+	// digest is sent only to the email of the account, not to any address given by client
+	if settings.Enabled {
+		var email sql.NullString
+		err := database.DB.Get(&email, "SELECT google_email FROM user_master WHERE uid = ?", uid)
+		if err != nil && err != sql.ErrNoRows {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !email.Valid || email.String == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account has no email"})
+			return
+		}
+		settings.Email = email.String
+	}
+
+	if err := digest.Digests.Settings().Save(*settings); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// pinErrorStatus returns response status of PIN error, 0 if it's not caused by request
+func pinErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, pin.ErrInvalidPin):
+		return http.StatusBadRequest
+	case errors.Is(err, pin.ErrWrongPin):
+		return http.StatusForbidden
+	case errors.Is(err, pin.ErrNoPin):
+		return http.StatusNotFound
+	case errors.Is(err, pin.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	}
+	return 0
+}
+
+func getPin(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	hasPin, err := pin.Pins.HasPin(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hasPin": hasPin})
+}
+
+// updatePin sets PIN unlocking secret and locked categories of user
+func updatePin(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	var body UpdatePinRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := pin.Pins.SetPin(uid, body.CurrentPin, body.Pin); err != nil {
+		if status := pinErrorStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hasPin": true})
+}
+
+func deletePin(c *gin.Context) {
+	uid := c.GetString("uid")
+
+	var body DeletePinRequestDto
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := pin.Pins.RemovePin(uid, body.CurrentPin); err != nil {
+		if status := pinErrorStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hasPin": false})
+}
+
+func UseUserRouter(g *gin.RouterGroup) {
+	sg := g.Group("/user")
+	sg.Use(AuthMiddleware)
+	sg.GET("/timezone", getTimezone)
+	sg.PUT("/timezone", updateTimezone)
+	sg.POST("/devices", registerDevice)
+	sg.DELETE("/devices", unregisterDevice)
+	sg.GET("/digest", getDigestSettings)
+	sg.PUT("/digest", updateDigestSettings)
+	sg.GET("/pin", getPin)
+	sg.PUT("/pin", updatePin)
+	sg.DELETE("/pin", deletePin)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"memorial_app_server/log"
+	"memorial_app_server/service/database"
+	"memorial_app_server/service/state"
+	"os"
+)
+
+type command func(args []string) int
+
+var commands = map[string]command{
+	"verify":      verifyCommand,
+	"migrate":     migrateCommand,
+	"fold-clones": foldClonesCommand,
+}
+
+// runCommand runs command-line subcommand instead of the server, returns exit code
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		log.Errorf("Unknown command: %s", name)
+		return -1
+	}
+	return cmd(args)
+}
+
+// verifyCommand verifies stored chains of given users (or every user if not given)
+// usage: verify [uid...]
+func verifyCommand(args []string) int {
+	if err := initSchemeVersion(); err != nil {
+		log.Error(err)
+		return -1
+	}
+	if _, err := database.Initialize(); err != nil {
+		log.Error(err)
+		return -2
+	}
+
+	userIds := args
+	if len(userIds) == 0 {
+		var err error
+		userIds, err = state.VerifiableUserIds()
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+	}
+
+	exitCode := 0
+	for _, userId := range userIds {
+		report, err := state.VerifyChain(userId)
+		if err != nil {
+			log.Errorf("Failed to verify chain of user %s: %v", userId, err)
+			return -2
+		}
+		if !report.Valid {
+			exitCode = 1
+		}
+
+		marshaled, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+		fmt.Fprintln(os.Stdout, string(marshaled))
+	}
+	return exitCode
+}
+
+// migrateCommand rewrites stored history of given users (or every user if not given) to the current scheme version.
+// server should be stopped while migrating.
+// usage: migrate [uid...]
+func migrateCommand(args []string) int {
+	if err := initSchemeVersion(); err != nil {
+		log.Error(err)
+		return -1
+	}
+	if _, err := database.Initialize(); err != nil {
+		log.Error(err)
+		return -2
+	}
+
+	userIds := args
+	if len(userIds) == 0 {
+		var err error
+		userIds, err = state.VerifiableUserIds()
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+	}
+
+	for _, userId := range userIds {
+		report, err := state.MigrateHistory(userId)
+		if err != nil {
+			log.Errorf("Failed to migrate history of user %s: %v", userId, err)
+			return -2
+		}
+
+		marshaled, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+		fmt.Fprintln(os.Stdout, string(marshaled))
+	}
+	return 0
+}
+
+// foldClonesCommand folds done clones of repeating tasks into their occurrence logs for given users (or every user if not given).
+// server should be stopped while folding.
+// usage: fold-clones [uid...]
+func foldClonesCommand(args []string) int {
+	if err := initSchemeVersion(); err != nil {
+		log.Error(err)
+		return -1
+	}
+	if _, err := database.Initialize(); err != nil {
+		log.Error(err)
+		return -2
+	}
+	if err := state.InitializeService(database.DB); err != nil {
+		log.Error(err)
+		return -2
+	}
+
+	userIds := args
+	if len(userIds) == 0 {
+		var err error
+		userIds, err = state.VerifiableUserIds()
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+	}
+
+	for _, userId := range userIds {
+		report, err := state.FoldUserTaskClones(userId)
+		if err != nil {
+			log.Errorf("Failed to fold task clones of user %s: %v", userId, err)
+			return -2
+		}
+
+		marshaled, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Error(err)
+			return -2
+		}
+		fmt.Fprintln(os.Stdout, string(marshaled))
+	}
+	return 0
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/joho/godotenv"
 	"memorial_app_server/controllers"
+	"memorial_app_server/controllers/v1"
 	"memorial_app_server/libs/crypto"
 	"memorial_app_server/log"
 	"memorial_app_server/service/database"
+	"memorial_app_server/service/digest"
+	"memorial_app_server/service/pin"
+	"memorial_app_server/service/push"
+	"memorial_app_server/service/reminder"
 	"memorial_app_server/service/state"
 	"os"
 	"strconv"
@@ -29,6 +36,11 @@ func main() {
 		os.Exit(-1)
 	}
 
+	// Run command-line subcommand instead of server if given
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// Check environment variables
 	var envCheckKeys = []string{
 		"GOOGLE_OAUTH2_CLIENT_ID",
@@ -68,15 +80,47 @@ func main() {
 	}
 
 	// scheme version
-	rawSchemeVersion := os.Getenv("STATE_SCHEME_VERSION")
-	parsedSchemeVersion, err := strconv.Atoi(rawSchemeVersion)
-	if err != nil {
-		log.Error("Invalid scheme version: ", rawSchemeVersion)
+	if err := initSchemeVersion(); err != nil {
+		log.Error(err)
 		os.Exit(-1)
 	}
-	state.SchemeVersion = parsedSchemeVersion
-	if state.SchemeVersion == 0 {
-		panic("txType cannot be 0, maybe env is not set correctly")
+
+	// state checkpoint interval (optional)
+	if rawCheckpointInterval := os.Getenv("STATE_CHECKPOINT_INTERVAL"); rawCheckpointInterval != "" {
+		parsedCheckpointInterval, err := strconv.ParseInt(rawCheckpointInterval, 10, 64)
+		if err != nil || parsedCheckpointInterval <= 0 {
+			log.Error("Invalid checkpoint interval: ", rawCheckpointInterval)
+			os.Exit(-1)
+		}
+		state.CheckpointInterval = parsedCheckpointInterval
+	}
+
+	// state cache sizes (optional)
+	if rawChainCacheSize := os.Getenv("STATE_CHAIN_CACHE_SIZE"); rawChainCacheSize != "" {
+		parsedChainCacheSize, err := strconv.Atoi(rawChainCacheSize)
+		if err != nil || parsedChainCacheSize <= 0 {
+			log.Error("Invalid chain cache size: ", rawChainCacheSize)
+			os.Exit(-1)
+		}
+		state.ChainCacheSize = parsedChainCacheSize
+	}
+	if rawBlockCacheSize := os.Getenv("STATE_BLOCK_CACHE_SIZE"); rawBlockCacheSize != "" {
+		parsedBlockCacheSize, err := strconv.Atoi(rawBlockCacheSize)
+		if err != nil || parsedBlockCacheSize <= 0 {
+			log.Error("Invalid block cache size: ", rawBlockCacheSize)
+			os.Exit(-1)
+		}
+		state.BlockCacheSize = parsedBlockCacheSize
+	}
+
+	// transaction hash policy (optional)
+	if rawHashPolicy := os.Getenv("TX_HASH_POLICY"); rawHashPolicy != "" {
+		parsedHashPolicy, err := state.ParseHashPolicy(rawHashPolicy)
+		if err != nil {
+			log.Error(err)
+			os.Exit(-1)
+		}
+		state.TxHashPolicy = parsedHashPolicy
 	}
 
 	// Initialize Jwt
@@ -96,7 +140,48 @@ func main() {
 	// TODO :: check redis connection
 
 	// Initialize state service
-	if err = state.InitializeService(database.DB); err != nil {
+	if err := state.InitializeService(database.DB); err != nil {
+		log.Error(err)
+		os.Exit(-3)
+	}
+
+	// Initialize PIN of secret categories
+	if err := pin.InitializeService(database.DB); err != nil {
+		log.Error(err)
+		os.Exit(-3)
+	}
+
+	// Initialize push notification (optional)
+	notifiers, err := initPushNotifiers()
+	if err != nil {
+		log.Error(err)
+		os.Exit(-1)
+	}
+	if len(notifiers) > 0 {
+		if err := push.InitializeService(database.DB, notifiers); err != nil {
+			log.Error(err)
+			os.Exit(-3)
+		}
+	} else {
+		log.Info("push notification disabled")
+	}
+
+	// Initialize reminder scheduler
+	if err := reminder.InitializeService(database.DB, v1.DeliverReminder); err != nil {
+		log.Error(err)
+		os.Exit(-3)
+	}
+
+	// Initialize daily digest (job runs only if mail sender is configured)
+	sender, err := initMailSender()
+	if err != nil {
+		log.Error(err)
+		os.Exit(-1)
+	}
+	if sender == nil {
+		log.Info("digest email disabled")
+	}
+	if err := digest.InitializeService(database.DB, sender); err != nil {
 		log.Error(err)
 		os.Exit(-3)
 	}
@@ -104,3 +189,99 @@ func main() {
 	// Run web server with gin
 	controllers.RunGin(DebugMode)
 }
+
+// initSchemeVersion sets scheme version of state (and the window of older versions accepted) from env
+func initSchemeVersion() error {
+	rawSchemeVersion := os.Getenv("STATE_SCHEME_VERSION")
+	parsedSchemeVersion, err := strconv.Atoi(rawSchemeVersion)
+	if err != nil {
+		return fmt.Errorf("invalid scheme version: %s", rawSchemeVersion)
+	}
+	if parsedSchemeVersion == 0 {
+		return errors.New("scheme version cannot be 0, maybe env is not set correctly")
+	}
+	state.SchemeVersion = parsedSchemeVersion
+
+	if rawWindow := os.Getenv("STATE_SCHEME_VERSION_WINDOW"); rawWindow != "" {
+		parsedWindow, err := strconv.Atoi(rawWindow)
+		if err != nil || parsedWindow < 0 || parsedWindow > parsedSchemeVersion {
+			return fmt.Errorf("invalid scheme version window: %s", rawWindow)
+		}
+		state.SchemeVersionWindow = parsedWindow
+	}
+	return nil
+}
+
+// initPushNotifiers creates notifiers of push providers configured in env.
+// stand-in (PUSH_STANDIN_FILE or PUSH_STANDIN_URL) replaces all providers on local environment.
+func initPushNotifiers() (map[string]push.Notifier, error) {
+	notifiers := make(map[string]push.Notifier)
+
+	var standIn push.Notifier
+	if path := os.Getenv("PUSH_STANDIN_FILE"); path != "" {
+		standIn = push.NewFileNotifier(path)
+	} else if url := os.Getenv("PUSH_STANDIN_URL"); url != "" {
+		standIn = push.NewHTTPNotifier(url)
+	}
+	if standIn != nil {
+		log.Info("push notification stand-in activated")
+		notifiers[push.PlatformFCM] = standIn
+		notifiers[push.PlatformAPNs] = standIn
+		return notifiers, nil
+	}
+
+	if path := os.Getenv("FCM_SERVICE_ACCOUNT_FILE"); path != "" {
+		notifier, err := push.NewFCMNotifierFromServiceAccount(path)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[push.PlatformFCM] = notifier
+	}
+	if path := os.Getenv("APNS_KEY_FILE"); path != "" {
+		notifier, err := push.NewAPNsNotifier(
+			path,
+			os.Getenv("APNS_KEY_ID"),
+			os.Getenv("APNS_TEAM_ID"),
+			os.Getenv("APNS_TOPIC"),
+			os.Getenv("APNS_SANDBOX") == "true",
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[push.PlatformAPNs] = notifier
+	}
+	return notifiers, nil
+}
+
+// initMailSender creates mail sender of digest configured in env.
+// stand-in (MAIL_STANDIN_DIR) writes mails into a directory on local environment.
+func initMailSender() (digest.Sender, error) {
+	from := os.Getenv("MAIL_FROM")
+	if dir := os.Getenv("MAIL_STANDIN_DIR"); dir != "" {
+		log.Info("mail stand-in activated")
+		return &digest.FileSender{Dir: dir, From: from}, nil
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+	if from == "" {
+		return nil, errors.New("MAIL_FROM is required to send mail")
+	}
+	port := 587
+	if rawPort := os.Getenv("SMTP_PORT"); rawPort != "" {
+		parsedPort, err := strconv.Atoi(rawPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp port: %s", rawPort)
+		}
+		port = parsedPort
+	}
+	return &digest.SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
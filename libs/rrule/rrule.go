@@ -0,0 +1,382 @@
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a subset of RFC 5545 recurrence rule:
+// FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, BYDAY (with ordinal for MONTHLY), BYMONTHDAY, COUNT, UNTIL and WKST.
+// like RFC 5545, DTSTART is the first occurrence and invalid dates (e.g. Feb 30) are skipped, not rolled over.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	Count      int       // 0 if not limited
+	Until      time.Time // zero if not limited
+	UntilDate  bool      // whether UNTIL is a date (the whole day in the location of DTSTART is included)
+	WeekStart  time.Weekday
+}
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a weekday with optional ordinal in month (e.g. 2MO, -1FR), ordinal 0 means every weekday
+type WeekdayNum struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// searchYears bounds the search for the next occurrence, weekdays of dates repeat every 400 years
+const searchYears = 400
+
+var (
+	ErrEmptyRule = errors.New("empty recurrence rule")
+
+	weekdays = map[string]time.Weekday{
+		"SU": time.Sunday,
+		"MO": time.Monday,
+		"TU": time.Tuesday,
+		"WE": time.Wednesday,
+		"TH": time.Thursday,
+		"FR": time.Friday,
+		"SA": time.Saturday,
+	}
+)
+
+// Parse parses RRULE value (e.g. "FREQ=MONTHLY;BYDAY=-1FR;COUNT=5"), "RRULE:" prefix is allowed
+func Parse(str string) (*Rule, error) {
+	str = strings.TrimPrefix(strings.TrimSpace(str), "RRULE:")
+	if str == "" {
+		return nil, ErrEmptyRule
+	}
+
+	rule := &Rule{
+		Interval:  1,
+		WeekStart: time.Monday,
+	}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(str, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part: %q", part)
+		}
+		name = strings.ToUpper(name)
+		if seen[name] {
+			return nil, fmt.Errorf("duplicated rule part: %s", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Freq = Frequency(strings.ToUpper(value))
+		case "INTERVAL":
+			rule.Interval, err = parsePositive(value)
+		case "COUNT":
+			rule.Count, err = parsePositive(value)
+		case "UNTIL":
+			rule.Until, rule.UntilDate, err = parseUntil(value)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseByMonthDay(value)
+		case "WKST":
+			weekday, ok := weekdays[strings.ToUpper(value)]
+			if !ok {
+				err = fmt.Errorf("invalid weekday: %s", value)
+			}
+			rule.WeekStart = weekday
+		default:
+			err = fmt.Errorf("unsupported rule part: %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// Validate checks combinations of rule parts
+func (r *Rule) Validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly, Yearly:
+	case "":
+		return errors.New("FREQ is required")
+	default:
+		return fmt.Errorf("unsupported FREQ: %s", r.Freq)
+	}
+	if r.Interval < 1 {
+		return errors.New("INTERVAL should be positive")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("COUNT and UNTIL can't be used together")
+	}
+	for _, day := range r.ByDay {
+		if day.Ordinal != 0 && r.Freq != Monthly {
+			return fmt.Errorf("BYDAY with ordinal is supported only for MONTHLY, not %s", r.Freq)
+		}
+	}
+	if r.Freq == Yearly && (len(r.ByDay) > 0 || len(r.ByMonthDay) > 0) {
+		return errors.New("BYDAY and BYMONTHDAY are not supported for YEARLY")
+	}
+	return nil
+}
+
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("%d is not positive", n)
+	}
+	return n, nil
+}
+
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s is not a date or UTC date-time", value)
+	}
+	return t, true, nil
+}
+
+// until returns the last moment occurrences can be at
+func (r *Rule) until(dtstart time.Time) time.Time {
+	if !r.UntilDate {
+		return r.Until
+	}
+	year, month, day := r.Until.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, dtstart.Location()).Add(-time.Nanosecond)
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	days := make([]WeekdayNum, 0)
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.ToUpper(strings.TrimSpace(raw))
+		if len(raw) < 2 {
+			return nil, fmt.Errorf("invalid weekday: %q", raw)
+		}
+		weekday, ok := weekdays[raw[len(raw)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday: %q", raw)
+		}
+		ordinal := 0
+		if prefix := raw[:len(raw)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("invalid weekday ordinal: %q", raw)
+			}
+			ordinal = n
+		}
+		days = append(days, WeekdayNum{Ordinal: ordinal, Weekday: weekday})
+	}
+	return days, nil
+}
+
+func parseByMonthDay(value string) ([]int, error) {
+	days := make([]int, 0)
+	for _, raw := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || n == 0 || n < -31 || n > 31 {
+			return nil, fmt.Errorf("invalid month day: %q", raw)
+		}
+		days = append(days, n)
+	}
+	return days, nil
+}
+
+// Next returns the first occurrence strictly after `after`, for the recurrence starting at dtstart.
+// time of day and location of occurrences are of dtstart. false is returned if there is no more occurrence.
+func (r *Rule) Next(dtstart, after time.Time) (time.Time, bool) {
+	until := r.until(dtstart)
+	horizon := after.AddDate(searchYears, 0, 0)
+	if dtstart.After(after) {
+		horizon = dtstart.AddDate(searchYears, 0, 0)
+	}
+
+	count := 0
+	for period := 0; ; period++ {
+		offset := period * r.Interval
+		if r.periodStart(dtstart, offset).After(horizon) {
+			return time.Time{}, false
+		}
+		for _, occurrence := range r.periodOccurrences(dtstart, offset) {
+			if occurrence.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && occurrence.After(until) {
+				return time.Time{}, false
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return time.Time{}, false
+			}
+			if occurrence.After(after) {
+				return occurrence, true
+			}
+		}
+	}
+}
+
+// periodStart returns the first day of the period offset from dtstart's one
+func (r *Rule) periodStart(dtstart time.Time, offset int) time.Time {
+	year, month, day := dtstart.Date()
+	switch r.Freq {
+	case Weekly:
+		weekOffset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		return r.at(dtstart, year, month, day-weekOffset+offset*7)
+	case Monthly:
+		monthYear, monthOfYear := addMonths(year, month, offset)
+		return r.at(dtstart, monthYear, monthOfYear, 1)
+	case Yearly:
+		return r.at(dtstart, year+offset, time.January, 1)
+	default:
+		return r.at(dtstart, year, month, day+offset)
+	}
+}
+
+// periodOccurrences returns sorted occurrences in the period (day, week, month or year) offset from dtstart's one
+func (r *Rule) periodOccurrences(dtstart time.Time, offset int) []time.Time {
+	year, month, day := dtstart.Date()
+	candidates := make([]time.Time, 0)
+
+	switch r.Freq {
+	case Daily:
+		candidates = append(candidates, r.at(dtstart, year, month, day+offset))
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			candidates = append(candidates, r.at(dtstart, year, month, day+offset*7))
+		}
+		weekStart := r.periodStart(dtstart, offset)
+		for i := 0; i < 7 && len(r.ByDay) > 0; i++ {
+			candidates = append(candidates, weekStart.AddDate(0, 0, i))
+		}
+	case Monthly:
+		monthYear, monthOfYear := addMonths(year, month, offset)
+		candidates = r.monthOccurrences(dtstart, monthYear, monthOfYear)
+	case Yearly:
+		if occurrence, ok := r.exactly(dtstart, year+offset, month, day); ok {
+			candidates = append(candidates, occurrence)
+		}
+	}
+
+	// BYDAY & BYMONTHDAY limit occurrences of DAILY and WEEKLY
+	occurrences := make([]time.Time, 0, len(candidates))
+	for _, candidate := range candidates {
+		if r.Freq == Daily || r.Freq == Weekly {
+			if len(r.ByDay) > 0 && !r.matchesWeekday(candidate) {
+				continue
+			}
+			if len(r.ByMonthDay) > 0 && !matchesMonthDay(candidate, r.ByMonthDay) {
+				continue
+			}
+		}
+		occurrences = append(occurrences, candidate)
+	}
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Before(occurrences[j]) })
+	return occurrences
+}
+
+// monthOccurrences returns occurrences in the month by BYMONTHDAY and BYDAY (or the day of dtstart)
+func (r *Rule) monthOccurrences(dtstart time.Time, year int, month time.Month) []time.Time {
+	lastDay := daysIn(year, month)
+	occurrences := make([]time.Time, 0)
+
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if occurrence, ok := r.exactly(dtstart, year, month, dtstart.Day()); ok {
+			occurrences = append(occurrences, occurrence)
+		}
+		return occurrences
+	}
+
+	for day := 1; day <= lastDay; day++ {
+		occurrence := r.at(dtstart, year, month, day)
+		if len(r.ByMonthDay) > 0 && !matchesMonthDay(occurrence, r.ByMonthDay) {
+			continue
+		}
+		if len(r.ByDay) > 0 && !r.matchesWeekdayInMonth(day, occurrence.Weekday(), lastDay) {
+			continue
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences
+}
+
+func (r *Rule) matchesWeekday(t time.Time) bool {
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekdayInMonth checks weekday with ordinal (e.g. 2MO is the second monday, -1FR is the last friday)
+func (r *Rule) matchesWeekdayInMonth(day int, weekday time.Weekday, lastDay int) bool {
+	for _, byDay := range r.ByDay {
+		if byDay.Weekday != weekday {
+			continue
+		}
+		switch {
+		case byDay.Ordinal == 0:
+			return true
+		case byDay.Ordinal > 0 && (day-1)/7+1 == byDay.Ordinal:
+			return true
+		case byDay.Ordinal < 0 && (lastDay-day)/7+1 == -byDay.Ordinal:
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMonthDay(t time.Time, monthDays []int) bool {
+	lastDay := daysIn(t.Year(), t.Month())
+	for _, monthDay := range monthDays {
+		if monthDay == t.Day() || (monthDay < 0 && lastDay+monthDay+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the date (normalized) at the time of day of dtstart
+func (r *Rule) at(dtstart time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), dtstart.Nanosecond(), dtstart.Location())
+}
+
+// exactly returns the date only if it exists (e.g. not Feb 30)
+func (r *Rule) exactly(dtstart time.Time, year int, month time.Month, day int) (time.Time, bool) {
+	if day > daysIn(year, month) {
+		return time.Time{}, false
+	}
+	return r.at(dtstart, year, month, day), true
+}
+
+func addMonths(year int, month time.Month, months int) (int, time.Month) {
+	total := year*12 + int(month) - 1 + months
+	return total / 12, time.Month(total%12 + 1)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
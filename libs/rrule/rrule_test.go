@@ -0,0 +1,142 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+}
+
+// occurrences returns first n occurrences from dtstart
+func occurrences(t *testing.T, str string, dtstart time.Time, n int) []time.Time {
+	rule, err := Parse(str)
+	if err != nil {
+		t.Fatalf("%s: %v", str, err)
+	}
+	result := []time.Time{dtstart}
+	for len(result) < n {
+		next, ok := rule.Next(dtstart, result[len(result)-1])
+		if !ok {
+			break
+		}
+		result = append(result, next)
+	}
+	return result
+}
+
+func assertDates(t *testing.T, str string, got []time.Time, expected ...time.Time) {
+	if len(got) != len(expected) {
+		t.Fatalf("%s: expected %d occurrences, got %v", str, len(expected), got)
+	}
+	for i := range expected {
+		if !got[i].Equal(expected[i]) {
+			t.Errorf("%s: occurrence #%d expected %s, got %s", str, i, expected[i], got[i])
+		}
+	}
+}
+
+func TestNext_MonthEnd(t *testing.T) {
+	// months without 31st are skipped, not rolled over to the next month
+	str := "FREQ=MONTHLY"
+	assertDates(t, str, occurrences(t, str, date(2023, 1, 31), 4),
+		date(2023, 1, 31), date(2023, 3, 31), date(2023, 5, 31), date(2023, 7, 31))
+
+	// the last day of every month
+	str = "FREQ=MONTHLY;BYMONTHDAY=-1"
+	assertDates(t, str, occurrences(t, str, date(2023, 1, 31), 4),
+		date(2023, 1, 31), date(2023, 2, 28), date(2023, 3, 31), date(2023, 4, 30))
+}
+
+func TestNext_LeapYear(t *testing.T) {
+	str := "FREQ=YEARLY"
+	assertDates(t, str, occurrences(t, str, date(2020, 2, 29), 3),
+		date(2020, 2, 29), date(2024, 2, 29), date(2028, 2, 29))
+
+	// 2100 is not a leap year
+	assertDates(t, str, occurrences(t, str, date(2096, 2, 29), 2),
+		date(2096, 2, 29), date(2104, 2, 29))
+
+	str = "FREQ=MONTHLY;BYMONTHDAY=29"
+	assertDates(t, str, occurrences(t, str, date(2023, 1, 29), 3),
+		date(2023, 1, 29), date(2023, 3, 29), date(2023, 4, 29))
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 29), 3),
+		date(2024, 1, 29), date(2024, 2, 29), date(2024, 3, 29))
+}
+
+func TestNext_NthWeekday(t *testing.T) {
+	// the second monday
+	str := "FREQ=MONTHLY;BYDAY=2MO"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 8), 3),
+		date(2024, 1, 8), date(2024, 2, 12), date(2024, 3, 11))
+
+	// the last friday
+	str = "FREQ=MONTHLY;BYDAY=-1FR"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 26), 3),
+		date(2024, 1, 26), date(2024, 2, 23), date(2024, 3, 29))
+
+	// the fifth thursday exists only in some months
+	str = "FREQ=MONTHLY;BYDAY=5TH"
+	assertDates(t, str, occurrences(t, str, date(2024, 2, 29), 3),
+		date(2024, 2, 29), date(2024, 5, 30), date(2024, 8, 29))
+}
+
+func TestNext_IntervalAndByDay(t *testing.T) {
+	str := "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 1), 5),
+		date(2024, 1, 1), date(2024, 1, 3), date(2024, 1, 15), date(2024, 1, 17), date(2024, 1, 29))
+
+	str = "FREQ=DAILY;INTERVAL=3"
+	assertDates(t, str, occurrences(t, str, date(2024, 2, 27), 3),
+		date(2024, 2, 27), date(2024, 3, 1), date(2024, 3, 4))
+
+	str = "FREQ=MONTHLY;BYMONTHDAY=1,15"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 15), 3),
+		date(2024, 1, 15), date(2024, 2, 1), date(2024, 2, 15))
+}
+
+func TestNext_CountAndUntil(t *testing.T) {
+	str := "FREQ=DAILY;COUNT=3"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 1), 10),
+		date(2024, 1, 1), date(2024, 1, 2), date(2024, 1, 3))
+
+	str = "FREQ=WEEKLY;UNTIL=20240115"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 1), 10),
+		date(2024, 1, 1), date(2024, 1, 8), date(2024, 1, 15))
+
+	str = "FREQ=WEEKLY;UNTIL=20240115T080000Z"
+	assertDates(t, str, occurrences(t, str, date(2024, 1, 1), 10),
+		date(2024, 1, 1), date(2024, 1, 8))
+}
+
+func TestNext_NeverMatches(t *testing.T) {
+	// the second monday is never the 31st, search should stop
+	rule, err := Parse("FREQ=MONTHLY;BYMONTHDAY=31;BYDAY=2MO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := rule.Next(date(2024, 1, 1), date(2024, 1, 1)); ok {
+		t.Errorf("expected no occurrence, got %s", next)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, str := range []string{
+		"",
+		"fortnight",
+		"FREQ=HOURLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101",
+		"FREQ=WEEKLY;BYDAY=2MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYSETPOS=1",
+		"FREQ=DAILY;FREQ=WEEKLY",
+	} {
+		if _, err := Parse(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+}
@@ -13,6 +13,8 @@ type UserEntity struct {
 	GoogleAuthId          *string `db:"google_auth_id" json:"googleAuthId"`
 	GoogleEmail           *string `db:"google_email" json:"googleEmail"`
 	GoogleProfileImageUrl *string `db:"google_profile_image_url" json:"googleProfileImageUrl"`
+	Timezone              *string `db:"timezone" json:"timezone"`
+	PinHash               *string `db:"pin_hash" json:"-"`
 }
 
 type BlockEntity struct {
@@ -23,6 +25,8 @@ type BlockEntity struct {
 	TxHash        *string `db:"tx_hash" json:"txHash"`
 	BlockHash     *string `db:"block_hash" json:"blockHash"`
 	PrevBlockHash *string `db:"prev_block_hash" json:"prevBlockHash"`
+	StateRoot     *string `db:"state_root" json:"stateRoot"`
+	ExecutedAt    *int64  `db:"executed_at" json:"executedAt"`
 }
 
 type TransactionEntity struct {
@@ -34,3 +38,32 @@ type TransactionEntity struct {
 	Content   []byte  `db:"content" json:"content"`
 	Hash      *string `db:"hash" json:"hash"`
 }
+
+type ReminderQueueEntity struct {
+	Id      *int64  `db:"id" json:"id"`
+	UserId  *string `db:"uid" json:"userId"`
+	Payload []byte  `db:"payload" json:"payload"`
+	FireAt  *int64  `db:"fire_at" json:"fireAt"`
+}
+
+type UpcomingReminderEntity struct {
+	Id      *int64  `db:"id" json:"id"`
+	UserId  *string `db:"uid" json:"userId"`
+	Payload []byte  `db:"payload" json:"payload"`
+	FireAt  *int64  `db:"fire_at" json:"fireAt"`
+}
+
+type DeviceTokenEntity struct {
+	Token     *string `db:"token" json:"token"`
+	UserId    *string `db:"uid" json:"userId"`
+	Platform  *string `db:"platform" json:"platform"`
+	UpdatedAt *int64  `db:"updated_at" json:"updatedAt"`
+}
+
+type DigestSettingsEntity struct {
+	UserId       *string `db:"uid" json:"userId"`
+	Enabled      *bool   `db:"enabled" json:"enabled"`
+	Hour         *int    `db:"hour" json:"hour"`
+	Email        *string `db:"email" json:"email"`
+	LastSentDate *string `db:"last_sent_date" json:"lastSentDate"`
+}
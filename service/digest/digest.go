@@ -0,0 +1,181 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"memorial_app_server/service/state"
+	"sort"
+	textTemplate "text/template"
+	"time"
+)
+
+// UpcomingDays is the number of days after today listed as upcoming
+var UpcomingDays = 7
+
+// Item is a task or subtask listed in digest
+type Item struct {
+	TaskId      string    `json:"taskId"`
+	SubtaskId   string    `json:"subtaskId,omitempty"`
+	Title       string    `json:"title"`
+	ParentTitle string    `json:"parentTitle,omitempty"` // title of task if item is a subtask
+	DueDate     time.Time `json:"dueDate"`
+	AllDay      bool      `json:"allDay"`
+	Protected   bool      `json:"protected,omitempty"` // task is in a secret or locked category
+}
+
+// Digest is a summary of undone tasks of user on a date of user's timezone
+type Digest struct {
+	UserId   string    `json:"userId"`
+	Date     time.Time `json:"date"` // midnight of the date in user's timezone
+	Overdue  []Item    `json:"overdue"`
+	DueToday []Item    `json:"dueToday"`
+	Upcoming []Item    `json:"upcoming"`
+}
+
+func (d *Digest) IsEmpty() bool {
+	return len(d.Overdue) == 0 && len(d.DueToday) == 0 && len(d.Upcoming) == 0
+}
+
+// Collect collects undone tasks and subtasks of state by due date, relative to now in location.
+// all-day due dates are compared by calendar date.
+func Collect(userId string, s *state.State, now time.Time, location *time.Location) *Digest {
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	tomorrow := today.AddDate(0, 0, 1)
+	upcomingEnd := today.AddDate(0, 0, 1+UpcomingDays)
+
+	digest := &Digest{UserId: userId, Date: today, Overdue: []Item{}, DueToday: []Item{}, Upcoming: []Item{}}
+	add := func(item Item) {
+		switch {
+		case item.AllDay && item.DueDate.Before(today), !item.AllDay && item.DueDate.Before(now):
+			digest.Overdue = append(digest.Overdue, item)
+		case item.DueDate.Before(tomorrow):
+			digest.DueToday = append(digest.DueToday, item)
+		case item.DueDate.Before(upcomingEnd):
+			digest.Upcoming = append(digest.Upcoming, item)
+		}
+	}
+
+	protected := s.ProtectedTaskIds()
+	for _, task := range s.Tasks {
+		if task.Done {
+			continue
+		}
+		if task.DueDate != 0 {
+			add(Item{
+				TaskId:  task.Id,
+				Title:   task.Title,
+				DueDate: dueDateIn(task.DueDate, task.AllDay, location),
+				AllDay:  task.AllDay,
+
+				Protected: protected[task.Id],
+			})
+		}
+		for _, subtask := range task.Subtasks {
+			if subtask.Done || subtask.DueDate == 0 {
+				continue
+			}
+			add(Item{
+				TaskId:      task.Id,
+				SubtaskId:   subtask.Id,
+				Title:       subtask.Title,
+				ParentTitle: task.Title,
+				DueDate:     time.UnixMilli(subtask.DueDate).In(location),
+				Protected:   protected[task.Id],
+			})
+		}
+	}
+
+	for _, items := range [][]Item{digest.Overdue, digest.DueToday, digest.Upcoming} {
+		sortItems(items)
+	}
+	return digest
+}
+
+// dueDateIn returns due date in location, all-day due date is the midnight of the date
+func dueDateIn(dueDate int64, allDay bool, location *time.Location) time.Time {
+	t := time.UnixMilli(dueDate)
+	if allDay {
+		year, month, day := t.UTC().Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, location)
+	}
+	return t.In(location)
+}
+
+func sortItems(items []Item) {
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].DueDate.Equal(items[j].DueDate) {
+			return items[i].DueDate.Before(items[j].DueDate)
+		}
+		if items[i].Title != items[j].Title {
+			return items[i].Title < items[j].Title
+		}
+		return items[i].TaskId+items[i].SubtaskId < items[j].TaskId+items[j].SubtaskId
+	})
+}
+
+var templateFuncs = map[string]interface{}{
+	// title of protected task redacted from state, or encrypted by client
+	"title": func(title string, protected bool) string {
+		if title == "" && protected {
+			return "Secret task"
+		}
+		if state.IsEnvelope(title) {
+			return "Encrypted task"
+		}
+		return title
+	},
+	"due": func(item Item) string {
+		if item.AllDay {
+			return item.DueDate.Format("Mon, Jan 2")
+		}
+		return item.DueDate.Format("Mon, Jan 2 15:04")
+	},
+}
+
+var textDigestTemplate = textTemplate.Must(textTemplate.New("text").Funcs(templateFuncs).Parse(
+	`Your tasks for {{.Date.Format "Monday, January 2"}}
+{{define "items"}}{{range .}}
+- {{title .Title .Protected}}{{if .ParentTitle}} ({{title .ParentTitle .Protected}}){{end}} / {{due .}}{{end}}{{end -}}
+{{if .Overdue}}
+Overdue{{template "items" .Overdue}}
+{{end}}{{if .DueToday}}
+Due today{{template "items" .DueToday}}
+{{end}}{{if .Upcoming}}
+Upcoming{{template "items" .Upcoming}}
+{{end}}`))
+
+var htmlDigestTemplate = template.Must(template.New("html").Funcs(templateFuncs).Parse(
+	`<!DOCTYPE html>
+<html>
+<body>
+<h2>Your tasks for {{.Date.Format "Monday, January 2"}}</h2>
+{{define "items"}}<ul>{{range .}}
+<li>{{title .Title .Protected}}{{if .ParentTitle}} <small>({{title .ParentTitle .Protected}})</small>{{end}} &middot; {{due .}}</li>{{end}}
+</ul>{{end}}
+{{if .Overdue}}<h3>Overdue</h3>
+{{template "items" .Overdue}}
+{{end}}{{if .DueToday}}<h3>Due today</h3>
+{{template "items" .DueToday}}
+{{end}}{{if .Upcoming}}<h3>Upcoming</h3>
+{{template "items" .Upcoming}}
+{{end}}</body>
+</html>
+`))
+
+func (d *Digest) Subject() string {
+	return fmt.Sprintf("Tasks for %s: %d overdue, %d due today", d.Date.Format("Mon, Jan 2"), len(d.Overdue), len(d.DueToday))
+}
+
+// Render renders digest as plain text and HTML
+func (d *Digest) Render() (string, string, error) {
+	var text, html bytes.Buffer
+	if err := textDigestTemplate.Execute(&text, d); err != nil {
+		return "", "", err
+	}
+	if err := htmlDigestTemplate.Execute(&html, d); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
@@ -0,0 +1,118 @@
+package digest
+
+import (
+	"memorial_app_server/service/state"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2024, 3, 9, 9, 0, 0, 0, seoul)
+	at := func(day, hour int) int64 {
+		return time.Date(2024, 3, day, hour, 0, 0, 0, seoul).UnixMilli()
+	}
+	allDay := func(day int) int64 {
+		return time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+
+	s := &state.State{Tasks: map[string]state.Task{
+		"overdue":  {Id: "overdue", Title: "overdue", DueDate: at(9, 8)},
+		"today":    {Id: "today", Title: "today", DueDate: at(9, 23)},
+		"allDay":   {Id: "allDay", Title: "all-day today", DueDate: allDay(9), AllDay: true},
+		"upcoming": {Id: "upcoming", Title: "<upcoming>", DueDate: at(16, 23)},
+		"later":    {Id: "later", Title: "later", DueDate: at(17, 0)},
+		"done":     {Id: "done", Title: "done", DueDate: at(8, 0), Done: true},
+		"noDue": {Id: "noDue", Title: "parent", Subtasks: map[string]state.Subtask{
+			"s1": {Id: "s1", Title: "subtask", DueDate: at(10, 9)},
+			"s2": {Id: "s2", Title: "done subtask", DueDate: at(8, 9), Done: true},
+		}},
+	}}
+
+	digest := Collect("uid", s, now, seoul)
+	titles := func(items []Item) []string {
+		result := make([]string, 0, len(items))
+		for _, item := range items {
+			result = append(result, item.Title)
+		}
+		return result
+	}
+	expected := map[string][]string{
+		"overdue":  {"overdue"},
+		"dueToday": {"all-day today", "today"},
+		"upcoming": {"subtask", "<upcoming>"},
+	}
+	for name, items := range map[string][]Item{"overdue": digest.Overdue, "dueToday": digest.DueToday, "upcoming": digest.Upcoming} {
+		if got := strings.Join(titles(items), ","); got != strings.Join(expected[name], ",") {
+			t.Errorf("%s: expected %v, got %v", name, expected[name], got)
+		}
+	}
+	if digest.Upcoming[0].ParentTitle != "parent" {
+		t.Errorf("expected parent title of subtask, got %q", digest.Upcoming[0].ParentTitle)
+	}
+
+	text, html, err := digest.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "- <upcoming> / Sat, Mar 16 23:00") || !strings.Contains(text, "- all-day today / Sat, Mar 9\n") {
+		t.Errorf("unexpected text digest:\n%s", text)
+	}
+	if !strings.Contains(html, "&lt;upcoming&gt;") || strings.Contains(html, "<upcoming>") {
+		t.Errorf("expected escaped title in html digest:\n%s", html)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := t.TempDir()
+	sender := &FileSender{Dir: dir, From: "digest@example.com"}
+	if err := sender.Send(&Message{To: "user@example.com", Subject: "subject", Text: "text", HTML: "<p>html</p>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 mail, got %v (%v)", entries, err)
+	}
+	content, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"To: user@example.com", "Subject: subject", "text/plain", "text/html", "<p>html</p>"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("expected %q in mail:\n%s", expected, content)
+		}
+	}
+}
+
+func TestSendDue_BackoffFailedUser(t *testing.T) {
+	store := NewMemoryStore()
+	// no email address, sending fails every time
+	if err := store.Save(Settings{UserId: "uid", Enabled: true, Hour: 0}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 9, 9, 0, 0, 0, time.Local)
+	service := NewService(store, nil)
+	service.now = func() time.Time { return now }
+
+	// retried after 1, 2, then 4 check intervals
+	start := now
+	for i, count := range []int{1, 2, 2, 3, 3, 3, 3, 4} {
+		service.SendDue()
+		if got := service.failures["uid"].count; got != count {
+			t.Fatalf("check %d: expected %d failures, got %d", i, count, got)
+		}
+		now = now.Add(CheckInterval)
+	}
+	if retryAt := service.failures["uid"].retryAt; !retryAt.Equal(start.Add(15 * CheckInterval)) {
+		t.Errorf("unexpected retry time %s", retryAt)
+	}
+	if settings, _ := store.Get("uid"); settings.LastSentDate != "" {
+		t.Errorf("expected failed digest not to be marked sent, got %s", settings.LastSentDate)
+	}
+}
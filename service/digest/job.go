@@ -0,0 +1,160 @@
+package digest
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/log"
+	"memorial_app_server/service/pin"
+	"memorial_app_server/service/state"
+	"time"
+)
+
+var Digests *Service = nil
+
+// CheckInterval is how often the job looks for users to send digest
+var CheckInterval = 5 * time.Minute
+
+// MaxRetryInterval bounds the backoff of retrying user whose digest failed to be sent
+var MaxRetryInterval = 6 * time.Hour
+
+// InitializeService enables digest settings of users in database.
+// the daily job is started only if sender is given.
+func InitializeService(db *sqlx.DB, sender Sender) error {
+	Digests = NewService(NewDatabaseStore(db), sender)
+	if sender != nil {
+		go Digests.Run()
+	}
+	return nil
+}
+
+// Service sends daily digest to users opted in, at their chosen local hour
+type Service struct {
+	store  Store
+	sender Sender
+	now    func() time.Time
+	stop   chan struct{}
+
+	failures map[string]failure // users whose digest failed to be sent, only touched by SendDue
+}
+
+// failure is consecutive failures of sending digest to user, not retried until retryAt
+type failure struct {
+	count   int
+	retryAt time.Time
+}
+
+func NewService(store Store, sender Sender) *Service {
+	return &Service{store: store, sender: sender, now: time.Now, stop: make(chan struct{}), failures: make(map[string]failure)}
+}
+
+// retryInterval doubles from CheckInterval on each consecutive failure, up to MaxRetryInterval
+func retryInterval(count int) time.Duration {
+	interval := CheckInterval
+	for i := 1; i < count && interval < MaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > MaxRetryInterval {
+		interval = MaxRetryInterval
+	}
+	return interval
+}
+
+func (s *Service) Settings() Store {
+	return s.store
+}
+
+func (s *Service) Run() {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		s.SendDue()
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Service) Stop() {
+	close(s.stop)
+}
+
+// SendDue sends digest to users whose delivery hour has come today and have not got one yet.
+// users missed while the server was down get it later on the same day.
+// user whose digest failed to be sent is retried with backoff, not on every check.
+func (s *Service) SendDue() {
+	settings, err := s.store.Enabled()
+	if err != nil {
+		log.Errorf("Failed to load digest settings: %v", err)
+		return
+	}
+
+	now := s.now()
+	for _, setting := range settings {
+		location := state.UserLocation(setting.UserId)
+		local := now.In(location)
+		date := local.Format("2006-01-02")
+		if local.Hour() < setting.Hour || setting.LastSentDate == date {
+			continue
+		}
+
+		failed, ok := s.failures[setting.UserId]
+		if ok && now.Before(failed.retryAt) {
+			continue
+		}
+
+		if err := s.SendUser(setting, now); err != nil {
+			failed.count++
+			failed.retryAt = now.Add(retryInterval(failed.count))
+			s.failures[setting.UserId] = failed
+			log.Errorf("Failed to send digest to user %s (%d times, retry at %s): %v", setting.UserId, failed.count, failed.retryAt.Format(time.RFC3339), err)
+			continue
+		}
+		delete(s.failures, setting.UserId)
+		if err := s.store.MarkSent(setting.UserId, date); err != nil {
+			log.Errorf("Failed to mark digest of user %s sent: %v", setting.UserId, err)
+		}
+	}
+}
+
+// SendUser sends digest of the last state of user, nothing is sent if user has no task due
+func (s *Service) SendUser(settings Settings, now time.Time) error {
+	if settings.Email == "" {
+		return fmt.Errorf("no email address")
+	}
+	chain, err := state.Chains.GetChain(settings.UserId)
+	if err != nil {
+		return err
+	}
+	defer chain.Release()
+	lastState := chain.GetLastState()
+	if lastState == nil {
+		return nil
+	}
+	// mail is not a session unlocked by PIN
+	if pin.Pins != nil {
+		hasPin, err := pin.Pins.HasPin(settings.UserId)
+		if err != nil {
+			return err
+		}
+		if hasPin {
+			lastState = lastState.Redact()
+		}
+	}
+
+	digest := Collect(settings.UserId, lastState, now, state.UserLocation(settings.UserId))
+	if digest.IsEmpty() {
+		return nil
+	}
+	text, html, err := digest.Render()
+	if err != nil {
+		return err
+	}
+	return s.sender.Send(&Message{
+		To:      settings.Email,
+		Subject: digest.Subject(),
+		Text:    text,
+		HTML:    html,
+	})
+}
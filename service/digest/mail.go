@@ -0,0 +1,103 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a mail with plain text and HTML alternatives
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender sends mail messages
+type Sender interface {
+	Send(message *Message) error
+}
+
+// SMTPSender sends messages through SMTP server with PLAIN auth
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(message *Message) error {
+	body, err := buildMIME(s.From, message)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", s.Host, s.Port), auth, s.From, []string{message.To}, body)
+}
+
+// FileSender writes messages as .eml files into a directory, for local environment and tests
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(message *Message) error {
+	body, err := buildMIME(s.From, message)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(message.To))
+	return os.WriteFile(filepath.Join(s.Dir, name), body, 0644)
+}
+
+// buildMIME builds multipart/alternative message of text and HTML
+func buildMIME(from string, message *Message) ([]byte, error) {
+	boundaryBytes := make([]byte, 12)
+	if _, err := rand.Read(boundaryBytes); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", from)
+	fmt.Fprintf(&buffer, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buffer, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", message.Text},
+		{"text/html", message.HTML},
+	} {
+		fmt.Fprintf(&buffer, "--%s\r\n", boundary)
+		fmt.Fprintf(&buffer, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writer := quotedprintable.NewWriter(&buffer)
+		if _, err := writer.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		buffer.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buffer, "--%s--\r\n", boundary)
+	return buffer.Bytes(), nil
+}
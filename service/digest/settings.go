@@ -0,0 +1,146 @@
+package digest
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/service/database"
+	"sync"
+)
+
+// DefaultHour is the delivery hour of users who have not chosen one
+const DefaultHour = 8
+
+// Settings is digest subscription of user
+type Settings struct {
+	UserId  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	Hour    int    `json:"hour"`  // local hour of user's timezone to deliver digest
+	Email   string `json:"email"` // email of the account when enabled
+	// LastSentDate is the local date (yyyy-mm-dd) digest was sent last, to send once a day
+	LastSentDate string `json:"lastSentDate,omitempty"`
+}
+
+func ValidateHour(hour int) error {
+	if hour < 0 || hour > 23 {
+		return fmt.Errorf("invalid delivery hour: %d", hour)
+	}
+	return nil
+}
+
+// Store keeps digest settings of users
+type Store interface {
+	// Get returns settings of user, disabled default settings if user has none
+	Get(userId string) (*Settings, error)
+	// Save saves settings of user except LastSentDate
+	Save(settings Settings) error
+	Enabled() ([]Settings, error)
+	MarkSent(userId string, date string) error
+}
+
+// DatabaseStore stores settings in digest_settings table
+type DatabaseStore struct {
+	db *sqlx.DB
+}
+
+func NewDatabaseStore(db *sqlx.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (s *DatabaseStore) Get(userId string) (*Settings, error) {
+	var entity database.DigestSettingsEntity
+	err := s.db.Get(&entity, "SELECT * FROM digest_settings WHERE uid = ?", userId)
+	if err == sql.ErrNoRows {
+		return &Settings{UserId: userId, Hour: DefaultHour}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settingsFromEntity(entity), nil
+}
+
+func (s *DatabaseStore) Save(settings Settings) error {
+	_, err := s.db.Exec(
+		"INSERT INTO digest_settings (uid, enabled, hour, email) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE enabled = VALUES(enabled), hour = VALUES(hour), email = VALUES(email)",
+		settings.UserId, settings.Enabled, settings.Hour, settings.Email,
+	)
+	return err
+}
+
+func (s *DatabaseStore) Enabled() ([]Settings, error) {
+	var entities []database.DigestSettingsEntity
+	if err := s.db.Select(&entities, "SELECT * FROM digest_settings WHERE enabled = TRUE"); err != nil {
+		return nil, err
+	}
+	settings := make([]Settings, 0, len(entities))
+	for _, entity := range entities {
+		settings = append(settings, *settingsFromEntity(entity))
+	}
+	return settings, nil
+}
+
+func (s *DatabaseStore) MarkSent(userId string, date string) error {
+	_, err := s.db.Exec("UPDATE digest_settings SET last_sent_date = ? WHERE uid = ?", date, userId)
+	return err
+}
+
+func settingsFromEntity(entity database.DigestSettingsEntity) *Settings {
+	settings := &Settings{UserId: *entity.UserId, Enabled: *entity.Enabled, Hour: *entity.Hour}
+	if entity.Email != nil {
+		settings.Email = *entity.Email
+	}
+	if entity.LastSentDate != nil {
+		settings.LastSentDate = *entity.LastSentDate
+	}
+	return settings
+}
+
+// MemoryStore keeps settings in memory, for running without database
+type MemoryStore struct {
+	lock     sync.Mutex
+	settings map[string]Settings
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{settings: make(map[string]Settings)}
+}
+
+func (s *MemoryStore) Get(userId string) (*Settings, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if settings, ok := s.settings[userId]; ok {
+		return &settings, nil
+	}
+	return &Settings{UserId: userId, Hour: DefaultHour}, nil
+}
+
+func (s *MemoryStore) Save(settings Settings) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	settings.LastSentDate = s.settings[settings.UserId].LastSentDate
+	s.settings[settings.UserId] = settings
+	return nil
+}
+
+func (s *MemoryStore) Enabled() ([]Settings, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	enabled := make([]Settings, 0)
+	for _, settings := range s.settings {
+		if settings.Enabled {
+			enabled = append(enabled, settings)
+		}
+	}
+	return enabled, nil
+}
+
+func (s *MemoryStore) MarkSent(userId string, date string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if settings, ok := s.settings[userId]; ok {
+		settings.LastSentDate = date
+		s.settings[userId] = settings
+	}
+	return nil
+}
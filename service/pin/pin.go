@@ -0,0 +1,183 @@
+package pin
+
+import (
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
+)
+
+var Pins *Service = nil
+
+var (
+	ErrInvalidPin      = errors.New("pin should be 4 to 12 digits")
+	ErrWrongPin        = errors.New("wrong pin")
+	ErrNoPin           = errors.New("pin is not set")
+	ErrTooManyAttempts = errors.New("too many wrong pin attempts, try again later")
+)
+
+// HashCost is the bcrypt cost of stored PIN hashes
+var HashCost = 12
+
+const (
+	// MaxAttempts is the number of wrong PINs allowed before the user is locked out
+	MaxAttempts     = 5
+	LockoutDuration = 15 * time.Minute
+)
+
+// InitializeService enables PINs of users stored in database
+func InitializeService(db *sqlx.DB) error {
+	Pins = NewService(NewDatabaseStore(db))
+	return nil
+}
+
+func ValidatePin(pin string) error {
+	if len(pin) < 4 || len(pin) > 12 {
+		return ErrInvalidPin
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return ErrInvalidPin
+		}
+	}
+	return nil
+}
+
+type failures struct {
+	count       int
+	inflight    int // attempts being compared, reserved so that concurrent ones can't exceed MaxAttempts
+	lockedUntil time.Time
+}
+
+// Service verifies PINs unlocking secret and locked categories of users
+type Service struct {
+	store Store
+	now   func() time.Time
+
+	lock     sync.Mutex
+	hashes   map[string]string // cached hash by user, empty if user has no PIN
+	failures map[string]*failures
+}
+
+func NewService(store Store) *Service {
+	return &Service{
+		store:    store,
+		now:      time.Now,
+		hashes:   make(map[string]string),
+		failures: make(map[string]*failures),
+	}
+}
+
+func (s *Service) hash(userId string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if hash, ok := s.hashes[userId]; ok {
+		return hash, nil
+	}
+	hash, err := s.store.Get(userId)
+	if err != nil {
+		return "", err
+	}
+	s.hashes[userId] = hash
+	return hash, nil
+}
+
+// HasPin reports whether user has set PIN, categories of user without PIN are not protected
+func (s *Service) HasPin(userId string) (bool, error) {
+	hash, err := s.hash(userId)
+	return hash != "", err
+}
+
+// Verify checks PIN of user, user is locked out for a while after MaxAttempts wrong PINs
+func (s *Service) Verify(userId string, pin string) error {
+	hash, err := s.hash(userId)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		return ErrNoPin
+	}
+
+	s.lock.Lock()
+	f, ok := s.failures[userId]
+	if !ok {
+		f = &failures{}
+		s.failures[userId] = f
+	}
+	if s.now().Before(f.lockedUntil) || f.count+f.inflight >= MaxAttempts {
+		s.lock.Unlock()
+		return ErrTooManyAttempts
+	}
+	f.inflight++
+	s.lock.Unlock()
+
+	// compared without lock, it's slow by design
+	compareErr := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f.inflight--
+	if compareErr != nil {
+		f.count++
+		if f.count >= MaxAttempts {
+			f.count = 0
+			f.lockedUntil = s.now().Add(LockoutDuration)
+		}
+		return ErrWrongPin
+	}
+	f.count = 0
+	if f.inflight == 0 && !s.now().Before(f.lockedUntil) {
+		delete(s.failures, userId)
+	}
+	return nil
+}
+
+// SetPin sets new PIN of user, current PIN is verified if user has one
+func (s *Service) SetPin(userId string, currentPin string, newPin string) error {
+	if err := ValidatePin(newPin); err != nil {
+		return err
+	}
+	if err := s.verifyCurrent(userId, currentPin); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPin), HashCost)
+	if err != nil {
+		return err
+	}
+	return s.save(userId, string(hash))
+}
+
+// RemovePin removes PIN of user with current PIN, categories of user are not protected anymore
+func (s *Service) RemovePin(userId string, currentPin string) error {
+	hasPin, err := s.HasPin(userId)
+	if err != nil {
+		return err
+	}
+	if !hasPin {
+		return ErrNoPin
+	}
+	if err := s.Verify(userId, currentPin); err != nil {
+		return err
+	}
+	return s.save(userId, "")
+}
+
+func (s *Service) verifyCurrent(userId string, currentPin string) error {
+	hasPin, err := s.HasPin(userId)
+	if err != nil || !hasPin {
+		return err
+	}
+	return s.Verify(userId, currentPin)
+}
+
+func (s *Service) save(userId string, hash string) error {
+	if err := s.store.Set(userId, hash); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hashes[userId] = hash
+	return nil
+}
@@ -0,0 +1,81 @@
+package pin
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestService(t *testing.T) {
+	HashCost = bcrypt.MinCost
+	service := NewService(NewMemoryStore())
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	if err := service.SetPin("uid", "", "12a4"); !errors.Is(err, ErrInvalidPin) {
+		t.Errorf("expected invalid pin, got %v", err)
+	}
+	if err := service.SetPin("uid", "", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if hasPin, _ := service.HasPin("uid"); !hasPin {
+		t.Errorf("expected user to have pin")
+	}
+	// changing pin needs current one
+	if err := service.SetPin("uid", "0000", "5678"); !errors.Is(err, ErrWrongPin) {
+		t.Errorf("expected wrong pin, got %v", err)
+	}
+	if err := service.Verify("uid", "1234"); err != nil {
+		t.Errorf("expected pin verified, got %v", err)
+	}
+
+	for i := 0; i < MaxAttempts; i++ {
+		if err := service.Verify("uid", "0000"); !errors.Is(err, ErrWrongPin) {
+			t.Errorf("attempt %d: expected wrong pin, got %v", i, err)
+		}
+	}
+	if err := service.Verify("uid", "1234"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected locked out, got %v", err)
+	}
+	now = now.Add(LockoutDuration)
+	if err := service.RemovePin("uid", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if hasPin, _ := service.HasPin("uid"); hasPin {
+		t.Errorf("expected pin removed")
+	}
+}
+
+func TestService_ConcurrentAttempts(t *testing.T) {
+	// slow enough for attempts to be compared at the same time
+	HashCost = bcrypt.MinCost + 4
+	defer func() { HashCost = bcrypt.MinCost }()
+	service := NewService(NewMemoryStore())
+	if err := service.SetPin("uid", "", "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	// wrong pins compared at once are not more than MaxAttempts
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	results := map[error]int{}
+	for i := 0; i < 10*MaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := service.Verify("uid", "0000")
+			lock.Lock()
+			results[err]++
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	if results[ErrWrongPin] > MaxAttempts || results[ErrWrongPin]+results[ErrTooManyAttempts] != 10*MaxAttempts {
+		t.Errorf("expected at most %d pins compared, got %v", MaxAttempts, results)
+	}
+	if err := service.Verify("uid", "1234"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected locked out, got %v", err)
+	}
+}
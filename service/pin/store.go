@@ -0,0 +1,66 @@
+package pin
+
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"sync"
+)
+
+// Store keeps PIN hashes of users
+type Store interface {
+	// Get returns PIN hash of user, empty if user has no PIN
+	Get(userId string) (string, error)
+	// Set sets PIN hash of user, empty hash removes PIN
+	Set(userId string, hash string) error
+}
+
+// DatabaseStore stores PIN hashes in pin_hash column of user_master
+type DatabaseStore struct {
+	db *sqlx.DB
+}
+
+func NewDatabaseStore(db *sqlx.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (s *DatabaseStore) Get(userId string) (string, error) {
+	var hash sql.NullString
+	err := s.db.Get(&hash, "SELECT pin_hash FROM user_master WHERE uid = ?", userId)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return hash.String, nil
+}
+
+func (s *DatabaseStore) Set(userId string, hash string) error {
+	value := sql.NullString{String: hash, Valid: hash != ""}
+	_, err := s.db.Exec("UPDATE user_master SET pin_hash = ? WHERE uid = ?", value, userId)
+	return err
+}
+
+// MemoryStore keeps PIN hashes in memory, for running without database
+type MemoryStore struct {
+	lock   sync.Mutex
+	hashes map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{hashes: make(map[string]string)}
+}
+
+func (s *MemoryStore) Get(userId string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.hashes[userId], nil
+}
+
+func (s *MemoryStore) Set(userId string, hash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if hash == "" {
+		delete(s.hashes, userId)
+	} else {
+		s.hashes[userId] = hash
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionEndpoint = "https://api.push.apple.com"
+	apnsSandboxEndpoint    = "https://api.sandbox.push.apple.com"
+
+	// provider token should be refreshed within an hour
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsNotifier sends notifications through APNs HTTP/2 API with token-based authentication
+type APNsNotifier struct {
+	Endpoint string
+	Topic    string // bundle id of app
+	KeyId    string
+	TeamId   string
+	Key      *ecdsa.PrivateKey
+	Client   *http.Client
+
+	tokenLock   sync.Mutex
+	token       string
+	tokenIssued time.Time
+}
+
+// NewAPNsNotifier creates APNs notifier signing provider tokens with .p8 key file
+func NewAPNsNotifier(keyPath, keyId, teamId, topic string, sandbox bool) (*APNsNotifier, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apns key: %w", err)
+	}
+
+	endpoint := apnsProductionEndpoint
+	if sandbox {
+		endpoint = apnsSandboxEndpoint
+	}
+	return &APNsNotifier{
+		Endpoint: endpoint,
+		Topic:    topic,
+		KeyId:    keyId,
+		TeamId:   teamId,
+		Key:      key,
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+// providerToken returns cached provider token, re-signed before it expires
+func (n *APNsNotifier) providerToken() (string, error) {
+	n.tokenLock.Lock()
+	defer n.tokenLock.Unlock()
+
+	if n.token != "" && time.Since(n.tokenIssued) < apnsTokenLifetime {
+		return n.token, nil
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": n.TeamId,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = n.KeyId
+	signed, err := token.SignedString(n.Key)
+	if err != nil {
+		return "", err
+	}
+	n.token = signed
+	n.tokenIssued = now
+	return signed, nil
+}
+
+func (n *APNsNotifier) Send(ctx context.Context, token string, notification *Notification) error {
+	aps := map[string]interface{}{}
+	pushType, priority := "alert", "10"
+	if notification.Silent {
+		aps["content-available"] = 1
+		pushType, priority = "background", "5"
+	} else {
+		aps["alert"] = map[string]string{"title": notification.Title, "body": notification.Body}
+		aps["sound"] = "default"
+	}
+	payload := map[string]interface{}{"aps": aps}
+	for key, value := range notification.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	providerToken, err := n.providerToken()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/3/device/%s", n.Endpoint, token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", n.Topic)
+	req.Header.Set("apns-push-type", pushType)
+	req.Header.Set("apns-priority", priority)
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.Unmarshal(respBody, &errResp)
+
+	switch errResp.Reason {
+	case "BadDeviceToken", "Unregistered", "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: apns %s", ErrInvalidToken, errResp.Reason)
+	case "ExpiredProviderToken":
+		n.tokenLock.Lock()
+		n.token = ""
+		n.tokenLock.Unlock()
+		return &RetryableError{Err: fmt.Errorf("apns %s", errResp.Reason)}
+	}
+	return statusError("apns", resp, errResp.Reason)
+}
@@ -0,0 +1,148 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	fcmEndpoint = "https://fcm.googleapis.com"
+	fcmScope    = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// FCMNotifier sends notifications through FCM HTTP v1 API
+type FCMNotifier struct {
+	ProjectId   string
+	Endpoint    string
+	TokenSource oauth2.TokenSource
+	Client      *http.Client
+}
+
+// NewFCMNotifierFromServiceAccount creates FCM notifier authorized by service account key file of the project
+func NewFCMNotifierFromServiceAccount(path string) (*FCMNotifier, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ProjectId    string `json:"project_id"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyId string `json:"private_key_id"`
+		TokenUri     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account file: %w", err)
+	}
+
+	config := &jwt.Config{
+		Email:        account.ClientEmail,
+		PrivateKey:   []byte(account.PrivateKey),
+		PrivateKeyID: account.PrivateKeyId,
+		Scopes:       []string{fcmScope},
+		TokenURL:     account.TokenUri,
+	}
+	return &FCMNotifier{
+		ProjectId:   account.ProjectId,
+		Endpoint:    fcmEndpoint,
+		TokenSource: config.TokenSource(context.Background()),
+		Client:      http.DefaultClient,
+	}, nil
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification *fcmNotification  `json:"notification,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (n *FCMNotifier) Send(ctx context.Context, token string, notification *Notification) error {
+	message := fcmMessage{Token: token, Data: notification.Data}
+	if !notification.Silent {
+		message.Notification = &fcmNotification{Title: notification.Title, Body: notification.Body}
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := n.TokenSource.Token()
+	if err != nil {
+		return &RetryableError{Err: fmt.Errorf("failed to get access token: %w", err)}
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", n.Endpoint, n.ProjectId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	accessToken.SetAuthHeader(req)
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp fcmErrorResponse
+	_ = json.Unmarshal(respBody, &errResp)
+	errorCode := errResp.Error.Status
+	for _, detail := range errResp.Error.Details {
+		if detail.ErrorCode != "" {
+			errorCode = detail.ErrorCode
+		}
+	}
+
+	switch errorCode {
+	case "UNREGISTERED", "NOT_FOUND", "SENDER_ID_MISMATCH":
+		return fmt.Errorf("%w: fcm %s", ErrInvalidToken, errorCode)
+	}
+	return statusError("fcm", resp, errorCode)
+}
+
+// statusError returns RetryableError for rate limit & server errors of provider
+func statusError(provider string, resp *http.Response, reason string) error {
+	err := fmt.Errorf("%s responded %d: %s", provider, resp.StatusCode, reason)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &RetryableError{Err: err, RetryAfter: retryAfter(resp)}
+	}
+	return err
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,144 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/log"
+	"time"
+)
+
+var Pusher *Service = nil
+
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+)
+
+var (
+	// ErrInvalidToken is returned by notifier if the device token is not valid anymore, the token is removed
+	ErrInvalidToken = errors.New("invalid device token")
+	ErrNoNotifier   = errors.New("no notifier for platform")
+)
+
+// RetryableError is returned by notifier for temporary failures (rate limit, server unavailable)
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration // 0 if not given by provider
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retryable: %v", e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Notification is pushed to devices of user without live socket
+type Notification struct {
+	Title string            `json:"title,omitempty"`
+	Body  string            `json:"body,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+	// Silent notification only wakes app up to sync, nothing is shown to user
+	Silent bool `json:"silent,omitempty"`
+}
+
+// Notifier sends notification to a device through push provider
+type Notifier interface {
+	Send(ctx context.Context, token string, notification *Notification) error
+}
+
+// InitializeService enables push notifications through given notifiers by platform, with tokens registered in database
+func InitializeService(db *sqlx.DB, notifiers map[string]Notifier) error {
+	Pusher = NewService(NewDatabaseTokenStore(db), notifiers)
+	return nil
+}
+
+// Service sends notifications to registered devices of users, with retry and token invalidation
+type Service struct {
+	notifiers map[string]Notifier // by platform
+	tokens    TokenStore
+
+	MaxAttempts int
+	Backoff     time.Duration // doubled on each retry
+	Timeout     time.Duration // of each attempt
+	sleep       func(time.Duration)
+}
+
+func NewService(tokens TokenStore, notifiers map[string]Notifier) *Service {
+	return &Service{
+		notifiers:   notifiers,
+		tokens:      tokens,
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		Timeout:     10 * time.Second,
+		sleep:       time.Sleep,
+	}
+}
+
+// Tokens returns the device token registry
+func (s *Service) Tokens() TokenStore {
+	return s.tokens
+}
+
+// NotifyUser sends notification to devices of user except connected ones (they get it over websocket).
+// it returns the number of devices notified.
+func (s *Service) NotifyUser(userId string, notification *Notification, connected map[string]bool) (int, error) {
+	devices, err := s.tokens.Devices(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, device := range devices {
+		if connected[device.Token] {
+			continue
+		}
+		if err := s.send(device, notification); err != nil {
+			log.Warnf("Failed to push notification to device of user %s (%s): %v", userId, device.Platform, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// send sends notification to device, retrying temporary failures
+func (s *Service) send(device Device, notification *Notification) error {
+	notifier, ok := s.notifiers[device.Platform]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoNotifier, device.Platform)
+	}
+
+	backoff := s.Backoff
+	var err error
+	for attempt := 1; attempt <= s.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		err = notifier.Send(ctx, device.Token, notification)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if errors.Is(err, ErrInvalidToken) {
+			if removeErr := s.tokens.Unregister(device.UserId, device.Token); removeErr != nil {
+				log.Errorf("Failed to remove invalid device token of user %s: %v", device.UserId, removeErr)
+			}
+			return err
+		}
+		var retryable *RetryableError
+		if !errors.As(err, &retryable) || attempt == s.MaxAttempts {
+			return err
+		}
+
+		wait := backoff
+		if retryable.RetryAfter > wait {
+			wait = retryable.RetryAfter
+		}
+		s.sleep(wait)
+		backoff *= 2
+	}
+	return err
+}
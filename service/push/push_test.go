@@ -0,0 +1,121 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestService_RetryAndInvalidation(t *testing.T) {
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message standInMessage
+		_ = json.NewDecoder(r.Body).Decode(&message)
+		lock.Lock()
+		attempts[message.Token]++
+		count := attempts[message.Token]
+		lock.Unlock()
+
+		switch message.Token {
+		case "gone":
+			w.WriteHeader(http.StatusGone)
+		case "flaky":
+			if count == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "down":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tokens := NewMemoryTokenStore()
+	for _, token := range []string{"ok", "gone", "flaky", "down", "connected"} {
+		_ = tokens.Register(Device{UserId: "uid", Platform: PlatformFCM, Token: token})
+	}
+	service := NewService(tokens, map[string]Notifier{PlatformFCM: NewHTTPNotifier(server.URL)})
+	service.sleep = func(time.Duration) {}
+
+	sent, err := service.NotifyUser("uid", &Notification{Title: "title"}, map[string]bool{"connected": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 2 {
+		t.Errorf("expected 2 devices (ok, flaky) notified, got %d", sent)
+	}
+	if attempts["flaky"] != 2 || attempts["down"] != service.MaxAttempts || attempts["gone"] != 1 || attempts["connected"] != 0 {
+		t.Errorf("unexpected attempts: %v", attempts)
+	}
+
+	devices, _ := tokens.Devices("uid")
+	for _, device := range devices {
+		if device.Token == "gone" {
+			t.Errorf("expected invalid token to be removed")
+		}
+	}
+	if len(devices) != 4 {
+		t.Errorf("expected 4 devices left, got %v", devices)
+	}
+}
+
+func TestFCMNotifier_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/project/messages:send" || r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+	}))
+	defer server.Close()
+
+	notifier := &FCMNotifier{
+		ProjectId:   "project",
+		Endpoint:    server.URL,
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}),
+		Client:      server.Client(),
+	}
+	err := notifier.Send(context.Background(), "token", &Notification{Title: "title"})
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.jsonl")
+	notifier := NewFileNotifier(path)
+	for _, token := range []string{"a", "b"} {
+		if err := notifier.Send(context.Background(), token, &Notification{Silent: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var message standInMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil || !message.Notification.Silent {
+			t.Errorf("unexpected line: %s", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("expected 2 lines, got %d", lines)
+	}
+}
@@ -0,0 +1,84 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// stand-in notifiers replace push providers on local environment and tests
+
+type standInMessage struct {
+	Token        string        `json:"token"`
+	Notification *Notification `json:"notification"`
+	SentAt       int64         `json:"sentAt"`
+}
+
+// FileNotifier appends notifications to a file as JSON lines
+type FileNotifier struct {
+	Path string
+	lock sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{Path: path}
+}
+
+func (n *FileNotifier) Send(ctx context.Context, token string, notification *Notification) error {
+	line, err := json.Marshal(standInMessage{Token: token, Notification: notification, SentAt: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// HTTPNotifier posts notifications as JSON to a local endpoint.
+// the endpoint responds 410 for invalid tokens, 429 or 5xx for temporary failures like providers.
+type HTTPNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPNotifier(url string) *HTTPNotifier {
+	return &HTTPNotifier{URL: url, Client: http.DefaultClient}
+}
+
+func (n *HTTPNotifier) Send(ctx context.Context, token string, notification *Notification) error {
+	body, err := json.Marshal(standInMessage{Token: token, Notification: notification, SentAt: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return fmt.Errorf("%w: stand-in", ErrInvalidToken)
+	}
+	return statusError("stand-in", resp, resp.Status)
+}
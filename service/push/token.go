@@ -0,0 +1,105 @@
+package push
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/service/database"
+	"sync"
+	"time"
+)
+
+// Device is a device of user registered with push token
+type Device struct {
+	UserId   string `json:"-"`
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+func ValidatePlatform(platform string) error {
+	switch platform {
+	case PlatformFCM, PlatformAPNs:
+		return nil
+	}
+	return fmt.Errorf("invalid platform: %q", platform)
+}
+
+// TokenStore is the registry of device push tokens
+type TokenStore interface {
+	// Register registers token of device, token registered by another user moves to the user
+	Register(device Device) error
+	Unregister(userId string, token string) error
+	Devices(userId string) ([]Device, error)
+}
+
+// DatabaseTokenStore stores device tokens in device_tokens table
+type DatabaseTokenStore struct {
+	db *sqlx.DB
+}
+
+func NewDatabaseTokenStore(db *sqlx.DB) *DatabaseTokenStore {
+	return &DatabaseTokenStore{db: db}
+}
+
+func (s *DatabaseTokenStore) Register(device Device) error {
+	_, err := s.db.Exec(
+		"INSERT INTO device_tokens (token, uid, platform, updated_at) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE uid = VALUES(uid), platform = VALUES(platform), updated_at = VALUES(updated_at)",
+		device.Token, device.UserId, device.Platform, time.Now().UnixMilli(),
+	)
+	return err
+}
+
+func (s *DatabaseTokenStore) Unregister(userId string, token string) error {
+	_, err := s.db.Exec("DELETE FROM device_tokens WHERE uid = ? AND token = ?", userId, token)
+	return err
+}
+
+func (s *DatabaseTokenStore) Devices(userId string) ([]Device, error) {
+	var entities []database.DeviceTokenEntity
+	if err := s.db.Select(&entities, "SELECT * FROM device_tokens WHERE uid = ?", userId); err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(entities))
+	for _, entity := range entities {
+		devices = append(devices, Device{UserId: *entity.UserId, Platform: *entity.Platform, Token: *entity.Token})
+	}
+	return devices, nil
+}
+
+// MemoryTokenStore keeps device tokens in memory, for running without database
+type MemoryTokenStore struct {
+	lock    sync.Mutex
+	devices map[string]Device // by token
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{devices: make(map[string]Device)}
+}
+
+func (s *MemoryTokenStore) Register(device Device) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.devices[device.Token] = device
+	return nil
+}
+
+func (s *MemoryTokenStore) Unregister(userId string, token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if device, ok := s.devices[token]; ok && device.UserId == userId {
+		delete(s.devices, token)
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) Devices(userId string) ([]Device, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	devices := make([]Device, 0)
+	for _, device := range s.devices {
+		if device.UserId == userId {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
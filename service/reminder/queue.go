@@ -0,0 +1,94 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/service/database"
+	"sort"
+	"sync"
+)
+
+// Queue keeps reminders fired while user has no live connection
+type Queue interface {
+	Push(reminder *Reminder) error
+	// PopAll removes and returns queued reminders of user, the oldest first
+	PopAll(userId string) ([]*Reminder, error)
+}
+
+// DatabaseQueue stores queued reminders in reminder_queue table, so they survive restarts
+type DatabaseQueue struct {
+	db *sqlx.DB
+}
+
+func NewDatabaseQueue(db *sqlx.DB) *DatabaseQueue {
+	return &DatabaseQueue{db: db}
+}
+
+func (q *DatabaseQueue) Push(reminder *Reminder) error {
+	payload, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.Exec("INSERT INTO reminder_queue (uid, payload, fire_at) VALUES (?, ?, ?)", reminder.UserId, payload, reminder.FireAt)
+	return err
+}
+
+func (q *DatabaseQueue) PopAll(userId string) ([]*Reminder, error) {
+	ctx, err := q.db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = ctx.Rollback() }()
+
+	var entities []database.ReminderQueueEntity
+	if err := ctx.Select(&entities, "SELECT * FROM reminder_queue WHERE uid = ? ORDER BY fire_at FOR UPDATE", userId); err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return []*Reminder{}, nil
+	}
+	if _, err := ctx.Exec("DELETE FROM reminder_queue WHERE uid = ?", userId); err != nil {
+		return nil, err
+	}
+
+	reminders := make([]*Reminder, 0, len(entities))
+	for _, entity := range entities {
+		reminder := &Reminder{}
+		if err := json.Unmarshal(entity.Payload, reminder); err != nil {
+			return nil, err
+		}
+		reminder.UserId = userId
+		reminders = append(reminders, reminder)
+	}
+	if err := ctx.Commit(); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+// MemoryQueue keeps queued reminders in memory, for running without database
+type MemoryQueue struct {
+	lock      sync.Mutex
+	reminders map[string][]*Reminder
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{reminders: make(map[string][]*Reminder)}
+}
+
+func (q *MemoryQueue) Push(reminder *Reminder) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.reminders[reminder.UserId] = append(q.reminders[reminder.UserId], reminder)
+	return nil
+}
+
+func (q *MemoryQueue) PopAll(userId string) ([]*Reminder, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	reminders := q.reminders[userId]
+	delete(q.reminders, userId)
+	sort.SliceStable(reminders, func(i, j int) bool { return reminders[i].FireAt < reminders[j].FireAt })
+	return reminders, nil
+}
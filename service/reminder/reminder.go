@@ -0,0 +1,368 @@
+package reminder
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/log"
+	"memorial_app_server/service/state"
+	"sort"
+	"sync"
+	"time"
+)
+
+var Scheduler *ReminderScheduler = nil
+
+// PendingReminderTTL is how long reminders queued for offline user are kept
+var PendingReminderTTL = 24 * time.Hour
+
+// Reminder is emitted as `reminder` event to user before due date of task
+type Reminder struct {
+	UserId  string `json:"-"`
+	TaskId  string `json:"taskId"`
+	Title   string `json:"title"`
+	DueDate int64  `json:"dueDate"`
+	AllDay  bool   `json:"allDay"`
+	Offset  int64  `json:"offset"` // minutes before due date
+	FireAt  int64  `json:"fireAt"`
+	// task is in a secret or locked category, title is hidden from sessions not unlocked
+	Protected bool `json:"protected,omitempty"`
+}
+
+// Deliverer delivers reminder to live connections of user, returns false if user has none
+type Deliverer func(reminder *Reminder) bool
+
+// InitializeService starts the scheduler and rebuilds stored upcoming reminders in background
+func InitializeService(db *sqlx.DB, deliver Deliverer) error {
+	Scheduler = NewReminderScheduler(deliver, NewDatabaseQueue(db), NewDatabaseStore(db))
+	state.AddStateListener(Scheduler.Update)
+	go Scheduler.Run()
+	go func() {
+		if err := Scheduler.Rebuild(); err != nil {
+			log.Errorf("Failed to rebuild reminders: %v", err)
+		}
+	}()
+	return nil
+}
+
+type scheduledReminder struct {
+	reminder   *Reminder
+	generation int64
+}
+
+// reminderHeap is a min-heap of reminders by fire time
+type reminderHeap []scheduledReminder
+
+func (h reminderHeap) Len() int           { return len(h) }
+func (h reminderHeap) Less(i, j int) bool { return h[i].reminder.FireAt < h[j].reminder.FireAt }
+func (h reminderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *reminderHeap) Push(x interface{}) {
+	*h = append(*h, x.(scheduledReminder))
+}
+
+func (h *reminderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// ReminderScheduler tracks upcoming reminders of all users.
+// reminders of user are replaced as a whole whenever the state of user changes,
+// replaced ones are left in the heap and skipped by their generation.
+type ReminderScheduler struct {
+	deliver Deliverer
+	queue   Queue
+	store   Store
+	now     func() time.Time
+
+	storeLock sync.Mutex
+	stored    map[string]string // digest of reminders of user in store
+
+	lock        sync.Mutex
+	reminders   reminderHeap
+	generations map[string]int64 // current generation of reminders of user
+	live        map[string]int   // number of reminders of current generation of user
+	wake        chan struct{}
+	stop        chan struct{}
+}
+
+func NewReminderScheduler(deliver Deliverer, queue Queue, store Store) *ReminderScheduler {
+	return &ReminderScheduler{
+		deliver:     deliver,
+		queue:       queue,
+		store:       store,
+		now:         time.Now,
+		stored:      make(map[string]string),
+		reminders:   reminderHeap{},
+		generations: make(map[string]int64),
+		live:        make(map[string]int),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Update replaces reminders of user with the upcoming ones of the last state
+func (s *ReminderScheduler) Update(userId string, lastState *state.State) {
+	location := state.UserLocation(userId)
+	now := s.now().UnixMilli()
+
+	upcoming := make([]*Reminder, 0)
+	protected := lastState.ProtectedTaskIds()
+	for _, task := range lastState.Tasks {
+		for _, reminderTime := range task.ReminderTimes(location) {
+			if reminderTime.FireAt <= now {
+				continue
+			}
+			upcoming = append(upcoming, &Reminder{
+				UserId:  userId,
+				TaskId:  task.Id,
+				Title:   task.Title,
+				DueDate: task.DueDate,
+				AllDay:  task.AllDay,
+				Offset:  reminderTime.Offset,
+				FireAt:  reminderTime.FireAt,
+
+				Protected: protected[task.Id],
+			})
+		}
+	}
+	sortReminders(upcoming)
+
+	s.schedule(userId, upcoming, false)
+	s.persist(userId, upcoming)
+}
+
+// schedule replaces reminders of user in the heap,
+// if rebuilding, reminders of user already scheduled (updated since start) are kept and false is returned.
+func (s *ReminderScheduler) schedule(userId string, upcoming []*Reminder, rebuilding bool) bool {
+	s.lock.Lock()
+	if rebuilding && s.generations[userId] > 0 {
+		s.lock.Unlock()
+		return false
+	}
+	s.generations[userId]++
+	generation := s.generations[userId]
+	for _, reminder := range upcoming {
+		heap.Push(&s.reminders, scheduledReminder{reminder: reminder, generation: generation})
+	}
+	if len(upcoming) > 0 {
+		s.live[userId] = len(upcoming)
+	} else {
+		delete(s.live, userId)
+	}
+	s.compact()
+	s.lock.Unlock()
+
+	// next fire time may be changed
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// persist stores upcoming reminders of user, only if they are changed since stored
+func (s *ReminderScheduler) persist(userId string, upcoming []*Reminder) {
+	digest, err := remindersDigest(upcoming)
+	if err != nil {
+		log.Errorf("Failed to encode reminders of user %s: %v", userId, err)
+		return
+	}
+
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
+	if stored, ok := s.stored[userId]; ok && stored == digest {
+		return
+	}
+	if err := s.store.Replace(userId, upcoming); err != nil {
+		log.Errorf("Failed to store reminders of user %s: %v", userId, err)
+		return
+	}
+	s.stored[userId] = digest
+}
+
+// RefreshUser reloads reminders of user from the chain (e.g. after timezone is changed)
+func (s *ReminderScheduler) RefreshUser(userId string) error {
+	chain, err := state.Chains.GetChain(userId)
+	if err != nil {
+		return err
+	}
+	defer chain.Release()
+	if lastState := chain.GetLastState(); lastState != nil {
+		s.Update(userId, lastState)
+	}
+	return nil
+}
+
+// Rebuild schedules upcoming reminders stored before, on start of the server.
+// reminders of users updated since start are not replaced.
+func (s *ReminderScheduler) Rebuild() error {
+	upcoming, err := s.store.Upcoming(s.now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	if len(upcoming) == 0 {
+		// nothing stored yet (e.g. first start since reminders are stored)
+		return s.rebuildFromChains()
+	}
+
+	reminders := make(map[string][]*Reminder)
+	for _, reminder := range upcoming {
+		reminders[reminder.UserId] = append(reminders[reminder.UserId], reminder)
+	}
+	for userId, userReminders := range reminders {
+		sortReminders(userReminders)
+		if !s.schedule(userId, userReminders, true) {
+			continue
+		}
+		if digest, err := remindersDigest(userReminders); err == nil {
+			s.storeLock.Lock()
+			if _, ok := s.stored[userId]; !ok {
+				s.stored[userId] = digest
+			}
+			s.storeLock.Unlock()
+		}
+	}
+	log.Infof("reminders of %d users rebuilt", len(reminders))
+	return nil
+}
+
+// rebuildFromChains loads reminders of every user from their last states, which stores them as well
+func (s *ReminderScheduler) rebuildFromChains() error {
+	userIds, err := state.VerifiableUserIds()
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err := s.RefreshUser(userId); err != nil {
+			log.Errorf("Failed to load reminders of user %s: %v", userId, err)
+		}
+	}
+	log.Infof("reminders of %d users rebuilt from chains", len(userIds))
+	return nil
+}
+
+// sortReminders sorts reminders by fire time, so the same reminders are stored alike
+func sortReminders(reminders []*Reminder) {
+	sort.SliceStable(reminders, func(i, j int) bool {
+		if reminders[i].FireAt != reminders[j].FireAt {
+			return reminders[i].FireAt < reminders[j].FireAt
+		}
+		if reminders[i].TaskId != reminders[j].TaskId {
+			return reminders[i].TaskId < reminders[j].TaskId
+		}
+		return reminders[i].Offset < reminders[j].Offset
+	})
+}
+
+// remindersDigest hashes sorted reminders of user to tell if they are changed
+func remindersDigest(reminders []*Reminder) (string, error) {
+	b, err := json.Marshal(reminders)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// compact drops replaced reminders if they outnumber the current ones (lock should be held)
+func (s *ReminderScheduler) compact() {
+	live := 0
+	for _, count := range s.live {
+		live += count
+	}
+	if len(s.reminders) <= 2*live+1024 {
+		return
+	}
+	compacted := make(reminderHeap, 0, live)
+	for _, scheduled := range s.reminders {
+		if scheduled.generation == s.generations[scheduled.reminder.UserId] {
+			compacted = append(compacted, scheduled)
+		}
+	}
+	heap.Init(&compacted)
+	s.reminders = compacted
+}
+
+// popDue pops reminders of current generations to fire, and returns the time until the next one
+func (s *ReminderScheduler) popDue() ([]*Reminder, time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now().UnixMilli()
+	due := make([]*Reminder, 0)
+	for len(s.reminders) > 0 && s.reminders[0].reminder.FireAt <= now {
+		scheduled := heap.Pop(&s.reminders).(scheduledReminder)
+		userId := scheduled.reminder.UserId
+		if scheduled.generation != s.generations[userId] {
+			continue
+		}
+		if s.live[userId]--; s.live[userId] <= 0 {
+			delete(s.live, userId)
+		}
+		due = append(due, scheduled.reminder)
+	}
+
+	wait := time.Hour
+	if len(s.reminders) > 0 {
+		if next := time.Duration(s.reminders[0].reminder.FireAt-now) * time.Millisecond; next < wait {
+			wait = next
+		}
+	}
+	return due, wait
+}
+
+// fire delivers reminder to live connections, or queues it for user being offline
+func (s *ReminderScheduler) fire(reminder *Reminder) {
+	if s.deliver != nil && s.deliver(reminder) {
+		return
+	}
+	if err := s.queue.Push(reminder); err != nil {
+		log.Errorf("Failed to queue reminder of task %s for user %s: %v", reminder.TaskId, reminder.UserId, err)
+	}
+}
+
+// Run fires reminders on time until Stop is called
+func (s *ReminderScheduler) Run() {
+	for {
+		due, wait := s.popDue()
+		for _, reminder := range due {
+			s.fire(reminder)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *ReminderScheduler) Stop() {
+	close(s.stop)
+}
+
+// Pending returns reminders queued while user was offline, they are removed from the queue
+func (s *ReminderScheduler) Pending(userId string) ([]*Reminder, error) {
+	reminders, err := s.queue.PopAll(userId)
+	if err != nil {
+		return nil, err
+	}
+	expiredAt := s.now().Add(-PendingReminderTTL).UnixMilli()
+	pending := make([]*Reminder, 0, len(reminders))
+	for _, reminder := range reminders {
+		if reminder.FireAt >= expiredAt {
+			pending = append(pending, reminder)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,147 @@
+package reminder
+
+import (
+	"memorial_app_server/service/state"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(now time.Time, online map[string]bool) (*ReminderScheduler, *[]*Reminder) {
+	delivered := make([]*Reminder, 0)
+	scheduler := NewReminderScheduler(func(reminder *Reminder) bool {
+		if !online[reminder.UserId] {
+			return false
+		}
+		delivered = append(delivered, reminder)
+		return true
+	}, NewMemoryQueue(), NewMemoryStore())
+	scheduler.now = func() time.Time { return now }
+	return scheduler, &delivered
+}
+
+func stateWithTask(task state.Task) *state.State {
+	s := state.NewState()
+	s.Tasks[task.Id] = task
+	return s
+}
+
+func TestScheduler_FiresAndQueues(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+	scheduler, delivered := newTestScheduler(now, map[string]bool{"online": true})
+
+	dueDate := now.Add(time.Hour).UnixMilli()
+	// 10 minutes and 1 day before, the latter is already past
+	task := state.Task{Id: "t1", Title: "task", DueDate: dueDate, Reminders: []int64{24 * 60, 10}}
+	scheduler.Update("online", stateWithTask(task))
+	scheduler.Update("offline", stateWithTask(task))
+
+	due, wait := scheduler.popDue()
+	if len(due) != 0 || wait != 50*time.Minute {
+		t.Fatalf("expected nothing to fire for 50 minutes, got %v in %s", due, wait)
+	}
+
+	scheduler.now = func() time.Time { return now.Add(50 * time.Minute) }
+	due, _ = scheduler.popDue()
+	if len(due) != 2 {
+		t.Fatalf("expected 2 reminders to fire, got %d", len(due))
+	}
+	for _, reminder := range due {
+		if reminder.TaskId != "t1" || reminder.Offset != 10 || reminder.FireAt != dueDate-10*time.Minute.Milliseconds() {
+			t.Errorf("unexpected reminder: %+v", reminder)
+		}
+		scheduler.fire(reminder)
+	}
+
+	if len(*delivered) != 1 || (*delivered)[0].UserId != "online" {
+		t.Errorf("expected reminder to be delivered to online user, got %v", *delivered)
+	}
+	pending, err := scheduler.Pending("offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("expected reminder to be queued for offline user, got %v", pending)
+	}
+	if pending, _ := scheduler.Pending("offline"); len(pending) != 0 {
+		t.Errorf("expected queue to be emptied, got %v", pending)
+	}
+}
+
+func TestScheduler_UpdateReplacesReminders(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+	scheduler, _ := newTestScheduler(now, nil)
+
+	task := state.Task{Id: "t1", DueDate: now.Add(time.Hour).UnixMilli(), Reminders: []int64{10}}
+	scheduler.Update("uid", stateWithTask(task))
+
+	// due date is postponed, the old reminder should not fire
+	task.DueDate = now.Add(2 * time.Hour).UnixMilli()
+	scheduler.Update("uid", stateWithTask(task))
+	// done task is not reminded
+	done := task
+	done.Id = "t2"
+	done.Done = true
+	scheduler.Update("other", stateWithTask(done))
+
+	scheduler.now = func() time.Time { return now.Add(3 * time.Hour) }
+	due, _ := scheduler.popDue()
+	if len(due) != 1 || due[0].FireAt != task.DueDate-10*time.Minute.Milliseconds() {
+		t.Errorf("expected only the reminder of the last state, got %v", due)
+	}
+}
+
+// countingStore counts replacements of stored reminders
+type countingStore struct {
+	*MemoryStore
+	replaced int
+}
+
+func (s *countingStore) Replace(userId string, reminders []*Reminder) error {
+	s.replaced++
+	return s.MemoryStore.Replace(userId, reminders)
+}
+
+func TestScheduler_RebuildFromStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	scheduler := NewReminderScheduler(nil, NewMemoryQueue(), store)
+	scheduler.now = func() time.Time { return now }
+
+	task := state.Task{Id: "t1", Title: "task", DueDate: now.Add(time.Hour).UnixMilli(), Reminders: []int64{10}}
+	scheduler.Update("uid", stateWithTask(task))
+	// other changes of the state don't touch the stored reminders
+	other := stateWithTask(task)
+	other.Tasks["t2"] = state.Task{Id: "t2", Title: "without reminders"}
+	scheduler.Update("uid", other)
+	if store.replaced != 1 {
+		t.Errorf("expected reminders stored once, stored %d times", store.replaced)
+	}
+
+	// restarted without chains loaded
+	restarted := NewReminderScheduler(nil, NewMemoryQueue(), store)
+	restarted.now = func() time.Time { return now }
+	postponed := task
+	postponed.Id = "t3"
+	postponed.DueDate = now.Add(2 * time.Hour).UnixMilli()
+	if err := store.Replace("updated", []*Reminder{{UserId: "updated", TaskId: "stale", FireAt: now.Add(time.Minute).UnixMilli()}}); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Update("updated", stateWithTask(postponed))
+	if err := restarted.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted.now = func() time.Time { return now.Add(3 * time.Hour) }
+	due, _ := restarted.popDue()
+	if len(due) != 2 {
+		t.Fatalf("expected stored reminder and the one updated since start, got %v", due)
+	}
+	for _, reminder := range due {
+		if reminder.TaskId == "stale" {
+			t.Errorf("expected reminders updated since start not replaced by stored ones")
+		}
+		if reminder.UserId == "uid" && (reminder.TaskId != "t1" || reminder.Title != "task") {
+			t.Errorf("unexpected stored reminder: %+v", reminder)
+		}
+	}
+}
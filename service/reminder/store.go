@@ -0,0 +1,103 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/service/database"
+	"sort"
+	"sync"
+)
+
+// Store keeps upcoming reminders of users, so they are rebuilt on start without loading chains
+type Store interface {
+	// Replace replaces upcoming reminders of user
+	Replace(userId string, reminders []*Reminder) error
+	// Upcoming returns reminders of all users firing after the given time (in ms)
+	Upcoming(after int64) ([]*Reminder, error)
+}
+
+// DatabaseStore stores upcoming reminders in upcoming_reminders table
+type DatabaseStore struct {
+	db *sqlx.DB
+}
+
+func NewDatabaseStore(db *sqlx.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+func (s *DatabaseStore) Replace(userId string, reminders []*Reminder) error {
+	ctx, err := s.db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ctx.Rollback() }()
+
+	if _, err := ctx.Exec("DELETE FROM upcoming_reminders WHERE uid = ?", userId); err != nil {
+		return err
+	}
+	for _, reminder := range reminders {
+		payload, err := json.Marshal(reminder)
+		if err != nil {
+			return err
+		}
+		if _, err := ctx.Exec("INSERT INTO upcoming_reminders (uid, payload, fire_at) VALUES (?, ?, ?)", userId, payload, reminder.FireAt); err != nil {
+			return err
+		}
+	}
+	return ctx.Commit()
+}
+
+func (s *DatabaseStore) Upcoming(after int64) ([]*Reminder, error) {
+	var entities []database.UpcomingReminderEntity
+	if err := s.db.Select(&entities, "SELECT * FROM upcoming_reminders WHERE fire_at > ? ORDER BY fire_at", after); err != nil {
+		return nil, err
+	}
+
+	reminders := make([]*Reminder, 0, len(entities))
+	for _, entity := range entities {
+		reminder := &Reminder{}
+		if err := json.Unmarshal(entity.Payload, reminder); err != nil {
+			return nil, err
+		}
+		reminder.UserId = *entity.UserId
+		reminders = append(reminders, reminder)
+	}
+	return reminders, nil
+}
+
+// MemoryStore keeps upcoming reminders in memory, for running without database
+type MemoryStore struct {
+	lock      sync.Mutex
+	reminders map[string][]*Reminder
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{reminders: make(map[string][]*Reminder)}
+}
+
+func (s *MemoryStore) Replace(userId string, reminders []*Reminder) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(reminders) == 0 {
+		delete(s.reminders, userId)
+	} else {
+		s.reminders[userId] = reminders
+	}
+	return nil
+}
+
+func (s *MemoryStore) Upcoming(after int64) ([]*Reminder, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	upcoming := make([]*Reminder, 0)
+	for _, reminders := range s.reminders {
+		for _, reminder := range reminders {
+			if reminder.FireAt > after {
+				upcoming = append(upcoming, reminder)
+			}
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].FireAt < upcoming[j].FireAt })
+	return upcoming, nil
+}
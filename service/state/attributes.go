@@ -0,0 +1,99 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	PriorityNone   = ""
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+const (
+	// MaxTags is the maximum number of tags of a task
+	MaxTags = 20
+	// MaxTagLength is the maximum length of a tag in characters
+	MaxTagLength = 32
+	// MaxEncryptedTagLength is the maximum length of a tag encrypted by client, as an envelope
+	MaxEncryptedTagLength = 256
+	// MaxEffort is the maximum estimated effort of a task in minutes (1000 hours)
+	MaxEffort = 1000 * 60
+)
+
+func validatePriority(priority string) error {
+	switch priority {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+		return nil
+	}
+	return fmt.Errorf("invalid priority: %q", priority)
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("too many tags: %d (max %d)", len(tags), MaxTags)
+	}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) != tag || tag == "" {
+			return fmt.Errorf("invalid tag: %q", tag)
+		}
+		maxLength := MaxTagLength
+		if IsEnvelope(tag) {
+			maxLength = MaxEncryptedTagLength
+		}
+		if utf8.RuneCountInString(tag) > maxLength {
+			return fmt.Errorf("tag too long: %q (max %d characters)", tag, maxLength)
+		}
+		if seen[tag] {
+			return fmt.Errorf("duplicated tag: %q", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+func validateEffort(effort int64) error {
+	if effort < 0 || effort > MaxEffort {
+		return fmt.Errorf("invalid effort: %d minutes", effort)
+	}
+	return nil
+}
+
+// validateTaskAttributes checks priority, tags and effort of task
+func validateTaskAttributes(priority string, tags []string, effort int64) error {
+	if err := validatePriority(priority); err != nil {
+		return err
+	}
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+	return validateEffort(effort)
+}
+
+// normalizeTags copies tags in given order, empty ones are nil
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	normalized := make([]string, len(tags))
+	copy(normalized, tags)
+	return normalized
+}
+
+// equalTags reports whether tags are the same in the same order
+func equalTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 )
 
+// CheckpointInterval is the interval of blocks that store the full state.
+// other blocks store only their transitions and are rebuilt from the closest checkpoint.
+var CheckpointInterval int64 = 100
+
 type rawBlock struct {
 	Number        int64  `json:"number"`
 	TxHash        string `json:"txHash"`
@@ -14,9 +18,12 @@ type rawBlock struct {
 type Block struct {
 	Number        int64 `json:"number"`
 	State         *State
+	StateRoot     string   `json:"stateRoot"` // merkle root of State, not included in block hash (see merkle.go)
 	Updates       *Updates `json:"updates"`
 	PrevBlockHash string
 	Hash          string `json:"hash"`
+	// ExecutedAt is the server time (unix millis) transaction was executed at, not included in block hash
+	ExecutedAt int64 `json:"executedAt,omitempty"`
 }
 
 func NewBlock(number int64, state *State, updates *Updates, prevBlockHash string) *Block {
@@ -31,7 +38,26 @@ func NewBlock(number int64, state *State, updates *Updates, prevBlockHash string
 }
 
 func InitialBlock() *Block {
-	return NewBlock(0, NewState(), nil, "")
+	b := NewBlock(0, NewState(), nil, "")
+	b.StateRoot = b.State.Hash().Hex()
+	return b
+}
+
+// setStateRoot sets state root stored with block, or calculates it if not stored (or state is not available)
+func (b *Block) setStateRoot(stored *string) error {
+	if stored != nil && *stored != "" {
+		b.StateRoot = *stored
+		return nil
+	}
+	if b.State == nil {
+		return nil
+	}
+	root, err := b.State.MerkleRoot()
+	if err != nil {
+		return err
+	}
+	b.StateRoot = root.Hex()
+	return nil
 }
 
 func (b *Block) CalcHash() Hash {
@@ -1,7 +1,6 @@
 package state
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,13 +8,24 @@ import (
 	"memorial_app_server/log"
 	"memorial_app_server/service/database"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Chain struct {
 	UserId          string           `json:"user_id"`
 	Blocks          map[int64]*Block `json:"blocks"`
-	LastBlockNumber int64            `json:"last_block_number"`
+	LastBlockNumber int64            `json:"last_block_number"` // guarded by cacheLock
 	lock            *sync.Mutex
+	store           chainStore
+	// refs counts callers holding the chain from ChainCluster.GetChain
+	refs atomic.Int32
+	// location of user's timezone transactions are executed in
+	location atomic.Pointer[time.Location]
+
+	// cacheLock guards Blocks and blockUsage, which are limited by BlockCacheSize, and LastBlockNumber
+	cacheLock  *sync.Mutex
+	blockUsage *lru[int64]
 }
 
 func newStateChain(userId string) *Chain {
@@ -25,6 +35,9 @@ func newStateChain(userId string) *Chain {
 		Blocks:          make(map[int64]*Block),
 		LastBlockNumber: 0,
 		lock:            &sync.Mutex{},
+		store:           databaseChainStore{},
+		cacheLock:       &sync.Mutex{},
+		blockUsage:      newLru[int64](),
 	}
 	newBlock := InitialBlock()
 	c.Blocks[0] = newBlock
@@ -32,16 +45,47 @@ func newStateChain(userId string) *Chain {
 	return c
 }
 
+// Retain pins chain once more for another holder (e.g. goroutine), caller should already hold it
+func (c *Chain) Retain() {
+	c.refs.Add(1)
+}
+
+// Release unpins chain got from ChainCluster.GetChain, it can be evicted once no one holds it
+func (c *Chain) Release() {
+	if c.refs.Add(-1) < 0 {
+		log.Errorf("chain of userId %s released more than got", c.UserId)
+	}
+}
+
+// Location returns timezone of user transactions of chain are executed in, server's local timezone if not set
+func (c *Chain) Location() *time.Location {
+	if location := c.location.Load(); location != nil {
+		return location
+	}
+	return time.Local
+}
+
+func (c *Chain) setLocation(location *time.Location) {
+	c.location.Store(location)
+}
+
 func (c *Chain) GetLastState() *State {
-	return c.Blocks[c.LastBlockNumber].State
+	lastBlock, err := c.GetBlockByNumber(c.GetLastBlockNumber())
+	if err != nil {
+		log.Errorf("failed to get last block of userId %s: %v", c.UserId, err)
+		return nil
+	}
+	return lastBlock.State
 }
 
 func (c *Chain) GetLastBlockNumber() int64 {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
 	return c.LastBlockNumber
 }
 
 func (c *Chain) GetWaitingBlockNumber() int64 {
-	return c.LastBlockNumber + 1
+	return c.GetLastBlockNumber() + 1
 }
 
 func (c *Chain) GetBlockHash(number int64) (string, error) {
@@ -68,57 +112,128 @@ func (c *Chain) GetBlockByNumber(number int64) (*Block, error) {
 	if number < 0 {
 		return nil, fmt.Errorf("invalid block number: %d", number)
 	}
-	if number == 0 {
-		return c.Blocks[0], nil
-	}
 
 	// find block on cache
-	block, exist := c.Blocks[number]
-	if !exist {
-		// find block on database
-		var blockEntity database.BlockEntity
-		err := database.DB.Get(&blockEntity, "SELECT * FROM blocks WHERE uid = ? AND block_number = ?", c.UserId, number)
+	if block, exist := c.getCachedBlock(number); exist {
+		return block, nil
+	}
+
+	// replay blocks from the closest checkpoint (or cached block after it), read at once
+	checkpoint, err := c.store.lastCheckpoint(c.UserId, number)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	base := c.closestCachedBlock(checkpoint, number)
+	start := checkpoint
+	if base != nil {
+		start = base.Number + 1
+	}
+	blockEntities, txEntities, err := c.store.blocks(c.UserId, start, number)
+	if err != nil {
+		log.Error(err)
+		log.Debug("block number: ", number)
+		return nil, err
+	}
+	if int64(len(blockEntities)) != number-start+1 {
+		return nil, fmt.Errorf("block #%d not found: %d of blocks #%d~#%d stored", number, len(blockEntities), start, number)
+	}
+
+	block := base
+	for _, blockEntity := range blockEntities {
+		block, err = c.blockFromStored(blockEntity, txEntities, block)
 		if err != nil {
 			log.Error(err)
-			log.Debug("block number: ", number)
 			return nil, err
 		}
+		c.InsertBlock(block)
+	}
+	return block, nil
+}
 
-		state := NewState()
-		if err = state.FromBytes(blockEntity.State); err != nil {
-			log.Error(err)
-			return nil, err
+// closestCachedBlock returns the last cached block from checkpoint to before number, nil if none
+func (c *Chain) closestCachedBlock(checkpoint int64, number int64) *Block {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	for n := number - 1; n >= checkpoint; n-- {
+		if block, exist := c.Blocks[n]; exist {
+			return block
 		}
+	}
+	return nil
+}
 
-		transitions := NewTransitions()
-		if err = transitions.FromBytes(blockEntity.Transitions); err != nil {
-			log.Error(err)
-			return nil, err
-		}
+// blockFromStored builds block from stored entities, state of non-checkpoint block is replayed on prevBlock
+func (c *Chain) blockFromStored(blockEntity database.BlockEntity, txEntities map[string]database.TransactionEntity, prevBlock *Block) (*Block, error) {
+	number := *blockEntity.Number
+	if prevBlock != nil && prevBlock.Number != number-1 {
+		return nil, fmt.Errorf("block #%d is stored after block #%d", number, prevBlock.Number)
+	}
+
+	transitions := NewTransitions()
+	if err := transitions.FromBytes(blockEntity.Transitions); err != nil {
+		return nil, err
+	}
 
-		var txEntity database.TransactionEntity
-		err = database.DB.Get(&txEntity, "SELECT * FROM transactions WHERE `from` = ? AND hash = ?", c.UserId, blockEntity.TxHash)
+	if blockEntity.TxHash == nil {
+		return nil, fmt.Errorf("block #%d has no transaction hash", number)
+	}
+	txEntity, ok := txEntities[*blockEntity.TxHash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s of block #%d not found", *blockEntity.TxHash, number)
+	}
+	tx, err := TransactionFromEntity(txEntity)
+	if err != nil {
+		return nil, err
+	}
+	updates := NewUpdatesWithTransitions(tx, transitions)
+
+	var state *State
+	if blockEntity.State != nil {
+		state, err = stateFromStored(blockEntity.State, tx.Version)
 		if err != nil {
-			log.Error(err)
 			return nil, err
 		}
-
-		// get previous blockHash
-		prevBlockHash, err := c.GetBlockHash(number - 1)
+	} else {
+		// not a checkpoint, replay transitions on top of previous state
+		if prevBlock == nil {
+			return nil, fmt.Errorf("no state to replay block #%d on", number)
+		}
+		state, err = updates.ApplyTransitions(prevBlock.State)
 		if err != nil {
-			log.Error(err)
-			return nil, err
+			return nil, fmt.Errorf("failed to replay transitions of block %d: %w", number, err)
 		}
+	}
 
-		tx := NewTransaction(*txEntity.Version, *txEntity.From, *txEntity.Type, *txEntity.Timestamp, txEntity.Content, *txEntity.Hash)
-		updates := NewUpdatesWithTransitions(tx, transitions)
-		block = NewBlock(number, state, updates, prevBlockHash)
-		c.InsertBlock(block)
+	var prevBlockHash string
+	if blockEntity.PrevBlockHash == nil {
+		log.Warnf("prev_block_hash is nil for block %s", *blockEntity.BlockHash)
+	} else {
+		prevBlockHash = *blockEntity.PrevBlockHash
 	}
 
+	block := NewBlock(number, state, updates, prevBlockHash)
+	if blockEntity.ExecutedAt != nil {
+		block.ExecutedAt = *blockEntity.ExecutedAt
+	}
+	if err := block.setStateRoot(blockEntity.StateRoot); err != nil {
+		return nil, err
+	}
 	return block, nil
 }
 
+func (c *Chain) getCachedBlock(number int64) (*Block, bool) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	block, exist := c.Blocks[number]
+	if exist && number != 0 {
+		// initial block is never evicted
+		c.blockUsage.Touch(number)
+	}
+	return block, exist
+}
+
 func (c *Chain) GetBlockByHash(hash Hash) (*Block, error) {
 	// TODO :: optimize this (maybe use cache)
 	var blockEntity database.BlockEntity
@@ -127,9 +242,9 @@ func (c *Chain) GetBlockByHash(hash Hash) (*Block, error) {
 		return nil, err
 	}
 
-	state := NewState()
-	if err = state.FromBytes(blockEntity.State); err != nil {
-		return nil, err
+	if blockEntity.State == nil {
+		// not a checkpoint, rebuild state from the closest one
+		return c.GetBlockByNumber(*blockEntity.Number)
 	}
 
 	transitions := NewTransitions()
@@ -144,13 +259,21 @@ func (c *Chain) GetBlockByHash(hash Hash) (*Block, error) {
 	}
 
 	prevBlockHash := *blockEntity.PrevBlockHash
+
+	tx, err := TransactionFromEntity(txEntity)
+	if err != nil {
+		return nil, err
+	}
+	state, err := stateFromStored(blockEntity.State, tx.Version)
 	if err != nil {
 		return nil, err
 	}
 
-	tx := NewTransaction(*txEntity.Version, *txEntity.From, *txEntity.Type, *txEntity.Timestamp, txEntity.Content, *txEntity.Hash)
 	updates := NewUpdatesWithTransitions(tx, transitions)
 	block := NewBlock(*blockEntity.Number, state, updates, prevBlockHash)
+	if err := block.setStateRoot(blockEntity.StateRoot); err != nil {
+		return nil, err
+	}
 
 	return block, nil
 }
@@ -171,9 +294,12 @@ func (c *Chain) DeleteBlockByInterval(start, end int64) error {
 	}
 
 	// delete in cache
+	c.cacheLock.Lock()
 	for i := start; i <= end; i++ {
 		delete(c.Blocks, i)
+		c.blockUsage.Remove(i)
 	}
+	c.cacheLock.Unlock()
 
 	// collect txHashes from database
 	var txHashes []string
@@ -198,18 +324,23 @@ func (c *Chain) DeleteBlockByInterval(start, end int64) error {
 
 	// check if block exists after end in cache
 	remain := 0
+	c.cacheLock.Lock()
 	for _, block := range c.Blocks {
 		if block.Number > end {
 			remain++
 		}
 	}
+	c.cacheLock.Unlock()
 	if remain > 0 {
 		log.Errorf("remain %d blocks in cache after deleting blocks from %d to %d", remain, start, end)
 	}
 
 	// update last block number
+	c.cacheLock.Lock()
 	c.LastBlockNumber = start - 1
+	c.cacheLock.Unlock()
 
+	notifyStateChange(c.UserId, c.GetLastState())
 	return nil
 }
 
@@ -238,13 +369,29 @@ func (c *Chain) Clear() error {
 		return err
 	}
 
+	c.cacheLock.Lock()
 	c.Blocks = make(map[int64]*Block)
 	c.Blocks[0] = InitialBlock()
+	c.blockUsage.Clear()
 	c.LastBlockNumber = 0
+	c.cacheLock.Unlock()
+
+	notifyStateChange(c.UserId, c.GetLastState())
 	return nil
 }
 
+// IsCheckpoint returns whether the block of given number stores its full state
+func IsCheckpoint(number int64) bool {
+	if CheckpointInterval <= 1 {
+		return true
+	}
+	return number%CheckpointInterval == 0
+}
+
 func (c *Chain) InsertBlock(block *Block) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
 	if _, ok := c.Blocks[block.Number]; ok {
 		// already exists
 		return
@@ -253,21 +400,74 @@ func (c *Chain) InsertBlock(block *Block) {
 	if block.Number > c.LastBlockNumber {
 		c.LastBlockNumber = block.Number
 	}
+	if block.Number != 0 {
+		c.blockUsage.Touch(block.Number)
+	}
+	c.evictBlocks()
 }
 
+// evictBlocks removes least recently used blocks over BlockCacheSize,
+// initial block and last block are always kept (cacheLock should be held)
+func (c *Chain) evictBlocks() {
+	over := c.blockUsage.Len() - BlockCacheSize
+	if over <= 0 {
+		return
+	}
+	for _, number := range c.blockUsage.Oldest() {
+		if over <= 0 {
+			break
+		}
+		if number == c.LastBlockNumber {
+			continue
+		}
+		delete(c.Blocks, number)
+		c.blockUsage.Remove(number)
+		over--
+	}
+}
+
+// ErrBlockNotSaved is returned when the block of an applied transaction couldn't be stored
+var ErrBlockNotSaved = errors.New("block not saved")
+
 // ApplyTransaction applies a transaction to build the new state
 func (c *Chain) ApplyTransaction(tx *Transaction, blockNumber int64) (*Block, error) {
-	var lastState *State
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	return c.applyTransaction(tx, blockNumber)
+}
 
-	lastBlock, exists := c.Blocks[c.LastBlockNumber]
-	if !exists {
-		lastState = NewState()
-	} else {
-		lastState = lastBlock.State
+// SubmitTransaction applies a transaction client built for blockNumber,
+// stale one (built on an older block) is rebased on the last block, and true is returned.
+// blockHash is the hash of the block client expects to be created, checked only when rebasing.
+func (c *Chain) SubmitTransaction(tx *Transaction, blockNumber int64, blockHash string) (*Block, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	waitingBlockNumber := c.GetLastBlockNumber() + 1
+	switch {
+	case tx.Type == TxInitialize || blockNumber == waitingBlockNumber:
+		block, err := c.applyTransaction(tx, blockNumber)
+		return block, false, err
+	case blockNumber > waitingBlockNumber:
+		return nil, false, fmt.Errorf("invalid block number: waiting for block #%d, but #%d given", waitingBlockNumber, blockNumber)
+	default:
+		block, err := c.rebaseTransaction(tx, blockNumber, blockHash)
+		return block, true, err
+	}
+}
+
+// applyTransaction applies a transaction on the last block (c.lock should be held)
+func (c *Chain) applyTransaction(tx *Transaction, blockNumber int64) (*Block, error) {
+	lastBlockNumber := c.GetLastBlockNumber()
+	if tx.Type != TxInitialize && blockNumber != lastBlockNumber+1 {
+		return nil, fmt.Errorf("invalid block number: waiting for block #%d, but #%d given", lastBlockNumber+1, blockNumber)
+	}
+	lastBlock, err := c.GetBlockByNumber(lastBlockNumber)
+	if err != nil {
+		return nil, err
 	}
 
+	lastState := lastBlock.State
 	if lastState == nil {
 		return nil, errors.New("last state is nil")
 	}
@@ -275,8 +475,14 @@ func (c *Chain) ApplyTransaction(tx *Transaction, blockNumber int64) (*Block, er
 	// block number should be last block number + 1, except for initializing
 	newBlockNumber := blockNumber
 
-	// pre-execute transaction
-	updates, err := PreExecuteTransaction(lastState, tx, newBlockNumber)
+	// pre-execute transaction as of server time, client's timestamp is not trusted
+	now := time.Now()
+	updates, err := preExecuteTransaction(lastState, tx, &TxContext{
+		NewBlockNumber: newBlockNumber,
+		Blocks:         c,
+		Location:       c.Location(),
+		Now:            now,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -294,74 +500,69 @@ func (c *Chain) ApplyTransaction(tx *Transaction, blockNumber int64) (*Block, er
 
 	// create new block
 	newBlock := NewBlock(newBlockNumber, newState, updates, lastBlock.Hash)
+	newBlock.ExecutedAt = now.UnixMilli()
+	if err := newBlock.setStateRoot(nil); err != nil {
+		return nil, err
+	}
+
+	// save block & transaction to database first, block not saved would be lost once evicted from cache
+	if err := c.saveBlock(tx, newBlock); err != nil {
+		log.Errorf("failed to save transaction/block %d: %v", newBlock.Number, err)
+		return nil, fmt.Errorf("%w: %v", ErrBlockNotSaved, err)
+	}
+	log.Infof("transaction/block %d saved successfully", newBlock.Number)
 
 	// update chain
 	c.InsertBlock(newBlock)
-	log.Infof("block %d inserted to cache successfully", newBlock.Number)
-
-	// save block & transaction to database
-	func() {
-		var marshaledContent []byte
-		if tx.Content != nil {
-			marshaledContent, err = json.Marshal(tx.Content)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-		}
-
-		marshaledState, err := newState.ToBytes()
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		marshaledTransitions, err := updates.Transitions.ToBytes()
 
-		ctx, err := database.DB.BeginTxx(context.Background(), nil)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		_, err = ctx.Exec("INSERT INTO transactions (version, type, `from`, timestamp, content, hash) VALUES (?, ?, ?, ?, ?, ?)", tx.Version, tx.Type, tx.From, tx.Timestamp, marshaledContent, tx.Hash)
-		if err != nil {
-			log.Error(err)
-			err := ctx.Rollback()
-			if err != nil {
-				return
-			}
-			return
-		}
+	notifyStateChange(c.UserId, newState)
+	return newBlock, nil
+}
 
-		_, err = ctx.Exec(
-			"INSERT INTO blocks (uid, transitions, state, block_number, block_hash, tx_hash, prev_block_hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
-			c.UserId,
-			marshaledTransitions,
-			marshaledState,
-			newBlock.Number,
-			newBlock.Hash,
-			newBlock.Updates.SrcTx.Hash,
-			newBlock.PrevBlockHash,
-		)
+// saveBlock stores block and its source transaction, full state is stored only on checkpoint blocks
+func (c *Chain) saveBlock(tx *Transaction, block *Block) error {
+	var marshaledContent []byte
+	if tx.Content != nil {
+		var err error
+		marshaledContent, err = json.Marshal(tx.Content)
 		if err != nil {
-			log.Error(err)
-			err := ctx.Rollback()
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			return
+			return err
 		}
+	}
 
-		err = ctx.Commit()
+	var marshaledState []byte
+	if IsCheckpoint(block.Number) {
+		var err error
+		marshaledState, err = block.State.ToBytes()
 		if err != nil {
-			log.Error(err)
-			return
+			return err
 		}
+	}
 
-		log.Infof("transaction/block %d saved successfully", newBlock.Number)
-	}()
+	marshaledTransitions, err := block.Updates.Transitions.ToBytes()
+	if err != nil {
+		return err
+	}
 
-	return newBlock, nil
+	return c.store.save(
+		database.TransactionEntity{
+			Version:   &tx.Version,
+			Type:      &tx.Type,
+			From:      &tx.From,
+			Timestamp: &tx.Timestamp,
+			Content:   marshaledContent,
+			Hash:      &tx.Hash,
+		},
+		database.BlockEntity{
+			UserId:        &c.UserId,
+			State:         marshaledState,
+			Transitions:   marshaledTransitions,
+			Number:        &block.Number,
+			TxHash:        &block.Updates.SrcTx.Hash,
+			BlockHash:     &block.Hash,
+			PrevBlockHash: &block.PrevBlockHash,
+			StateRoot:     &block.StateRoot,
+			ExecutedAt:    &block.ExecutedAt,
+		},
+	)
 }
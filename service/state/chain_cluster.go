@@ -1,136 +1,193 @@
 package state
 
 import (
+	"database/sql"
 	"encoding/json"
 	"github.com/jmoiron/sqlx"
 	"memorial_app_server/log"
-	"memorial_app_server/service/database"
+	"sync"
 )
 
 var Chains *ChainCluster = nil
 
-func InitializeService(db *sqlx.DB) error {
-	Chains = NewChainCluster()
-	if err := Chains.LoadFromDatabase(db); err != nil {
-		return err
-	}
-	return nil
-}
+var (
+	// ChainCacheSize is the maximum number of chains kept in memory
+	ChainCacheSize = 1000
+	// BlockCacheSize is the maximum number of blocks kept in memory per chain
+	BlockCacheSize = 256
+)
+
+// StateListener is called with the last state of user whenever the chain of user is changed
+type StateListener func(userId string, lastState *State)
 
-type ChainCluster map[string]*Chain
+var stateListeners []StateListener
 
-func NewChainCluster() *ChainCluster {
-	return &ChainCluster{}
+// AddStateListener registers listener of state changes, it should be registered before serving
+func AddStateListener(listener StateListener) {
+	stateListeners = append(stateListeners, listener)
 }
 
-func (sm *ChainCluster) GetChain(userId string) *Chain {
-	chain, ok := (*sm)[userId]
-	if !ok {
-		chain = newStateChain(userId)
-		(*sm)[userId] = chain
+func notifyStateChange(userId string, lastState *State) {
+	if lastState == nil {
+		return
+	}
+	for _, listener := range stateListeners {
+		listener(userId, lastState)
 	}
-	return chain
 }
 
-func (sm *ChainCluster) LoadFromDatabase(db *sqlx.DB) error {
-	// load transactions
-	transactions := make(map[string]*Transaction)
-	txRows, err := db.Queryx("SELECT * FROM transactions")
-	if err != nil {
-		return err
-	}
+func InitializeService(db *sqlx.DB) error {
+	Chains = NewChainCluster(db)
+	return nil
+}
 
-	for txRows.Next() {
-		var tx database.TransactionEntity
-		if err := txRows.StructScan(&tx); err != nil {
-			log.Errorf("failed to scan struct of transaction %v ", err)
-			return err
-		}
+// ChainCluster holds chains of users, loaded lazily from database on first access
+// and evicted by least recent use when ChainCacheSize is exceeded.
+type ChainCluster struct {
+	db      *sqlx.DB
+	chains  map[string]*Chain
+	loading map[string]*chainLoading // chains being loaded, without holding lock
+	usage   *lru[string]
+	lock    *sync.Mutex
+	load    func(userId string) (*Chain, error)
+}
 
-		var decodedContent interface{}
-		if err := json.Unmarshal(tx.Content, &decodedContent); err != nil {
-			return err
-		}
+// chainLoading is a chain being loaded, done is closed once it's loaded (or failed)
+type chainLoading struct {
+	done chan struct{}
+	err  error
+}
 
-		transactions[*tx.Hash] = NewTransaction(
-			*tx.Version,
-			*tx.From,
-			*tx.Type,
-			*tx.Timestamp,
-			decodedContent,
-			*tx.Hash,
-		)
+func NewChainCluster(db *sqlx.DB) *ChainCluster {
+	sm := &ChainCluster{
+		db:      db,
+		chains:  make(map[string]*Chain),
+		loading: make(map[string]*chainLoading),
+		usage:   newLru[string](),
+		lock:    &sync.Mutex{},
 	}
+	sm.load = sm.loadChain
+	return sm
+}
 
-	// load states
-	blockEntities := make(map[int64]database.BlockEntity)
-	blockRows, err := database.DB.Queryx("SELECT * FROM blocks ORDER BY block_number")
+// GetChain returns chain of user pinned in memory, caller should Release it when done.
+// pinned chain is never evicted, so there is only one chain of a user in use at a time.
+// chain is loaded without holding lock, others wait only for the chain of the same user.
+func (sm *ChainCluster) GetChain(userId string) (*Chain, error) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	chain, ok, err := sm.loadedOrWait(userId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	for blockRows.Next() {
-		// insert blocks
-		var block database.BlockEntity
-		if err := blockRows.StructScan(&block); err != nil {
-			log.Errorf("failed to scan struct of block %s: %v ", *block.BlockHash, err)
-			return err
+	if !ok {
+		loading := &chainLoading{done: make(chan struct{})}
+		sm.loading[userId] = loading
+		sm.lock.Unlock()
+		chain, err = sm.load(userId)
+		sm.lock.Lock()
+		delete(sm.loading, userId)
+		loading.err = err
+		close(loading.done)
+		if err != nil {
+			return nil, err
 		}
-		blockNumber := *block.Number
-		blockEntities[blockNumber] = block
+		sm.chains[userId] = chain
 	}
+	// pinned under sm.lock, so evict never sees a chain being handed out
+	chain.refs.Add(1)
+	sm.usage.Touch(userId)
+	sm.evict()
+	return chain, nil
+}
 
-	for blockNumber, block := range blockEntities {
-		tx, ok := transactions[*block.TxHash]
+// loadedOrWait returns chain of user in memory, waiting for it if being loaded (sm.lock should be held).
+// false is returned if it's neither loaded nor being loaded.
+func (sm *ChainCluster) loadedOrWait(userId string) (*Chain, bool, error) {
+	for {
+		if chain, ok := sm.chains[userId]; ok {
+			return chain, true, nil
+		}
+		loading, ok := sm.loading[userId]
 		if !ok {
-			log.Errorf("transaction not found for tx_hash %s: %v", *block.TxHash, err)
-			return err
+			return nil, false, nil
 		}
-
-		chain := sm.GetChain(tx.From)
-		if _, ok := chain.Blocks[blockNumber]; ok {
-			// no need to update
-			continue
+		sm.lock.Unlock()
+		<-loading.done
+		sm.lock.Lock()
+		if loading.err != nil {
+			return nil, false, loading.err
 		}
+		// loaded chain may be evicted again before lock is taken, so check again
+	}
+}
 
-		newState := NewState()
-		if err := newState.FromBytes(block.State); err != nil {
-			log.Errorf("failed to parse state of userId %s: %v", tx.From, err)
-			return err
-		}
+// loadedChain returns chain of user if it's in memory, without loading or pinning it.
+// chain being loaded is waited for, so it's not missed by changes made meanwhile (e.g. timezone).
+func (sm *ChainCluster) loadedChain(userId string) (*Chain, bool) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	chain, ok, _ := sm.loadedOrWait(userId)
+	return chain, ok
+}
 
-		transitions := NewTransitions()
-		if block.Transitions != nil {
-			// handle only transition-applied blocks
-			if err := transitions.FromBytes(block.Transitions); err != nil {
-				log.Errorf("failed to parse transitions of userId %s: %v", tx.From, err)
-				return err
-			}
-		}
+// LoadedChains returns snapshot of chains currently in memory
+func (sm *ChainCluster) LoadedChains() map[string]*Chain {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
 
-		// find transaction
-		tx, exists := transactions[*block.TxHash]
-		if !exists {
-			log.Errorf("transaction not found for tx_hash %s: %v", *block.TxHash, err)
-			return err
-		}
+	chains := make(map[string]*Chain, len(sm.chains))
+	for userId, chain := range sm.chains {
+		chains[userId] = chain
+	}
+	return chains
+}
 
-		var prevBlockHash string
-		if block.PrevBlockHash == nil {
-			log.Warnf("prev_block_hash is nil for block %s", *block.BlockHash)
-			prevBlockHash = ""
-		} else {
-			prevBlockHash = *block.PrevBlockHash
-			if err != nil {
-				log.Errorf("failed to parse prev_block_hash of userId %s: %v", tx.From, err)
-				return err
-			}
-		}
+func (sm *ChainCluster) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sm.LoadedChains())
+}
 
-		updates := NewUpdatesWithTransitions(tx, transitions)
-		newBlock := NewBlock(blockNumber, newState, updates, prevBlockHash)
-		chain.InsertBlock(newBlock)
+// loadChain loads only the chain head from database, other blocks are loaded on demand
+func (sm *ChainCluster) loadChain(userId string) (*Chain, error) {
+	chain := newStateChain(userId)
+	chain.setLocation(loadUserLocation(userId))
+
+	var lastBlockNumber sql.NullInt64
+	if err := sm.db.Get(&lastBlockNumber, "SELECT MAX(block_number) FROM blocks WHERE uid = ?", userId); err != nil {
+		log.Errorf("failed to get last block number of userId %s: %v", userId, err)
+		return nil, err
+	}
+	if !lastBlockNumber.Valid || lastBlockNumber.Int64 == 0 {
+		// no blocks yet
+		return chain, nil
 	}
 
-	return nil
+	chain.LastBlockNumber = lastBlockNumber.Int64
+	if _, err := chain.GetBlockByNumber(chain.LastBlockNumber); err != nil {
+		log.Errorf("failed to load last block of userId %s: %v", userId, err)
+		return nil, err
+	}
+	log.Debugf("chain of userId %s loaded with last block #%d", userId, chain.LastBlockNumber)
+	return chain, nil
+}
+
+// evict removes least recently used chains over ChainCacheSize, pinned chains are skipped
+func (sm *ChainCluster) evict() {
+	over := sm.usage.Len() - ChainCacheSize
+	if over <= 0 {
+		return
+	}
+	for _, userId := range sm.usage.Oldest() {
+		if over <= 0 {
+			break
+		}
+		if sm.chains[userId].refs.Load() > 0 {
+			continue
+		}
+		delete(sm.chains, userId)
+		sm.usage.Remove(userId)
+		log.Debugf("chain of userId %s evicted from cache", userId)
+		over--
+	}
 }
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChainCluster loads chains whose last block number is kept in committed, as if stored in database
+func testChainCluster(committed map[string]int64, lock *sync.Mutex) *ChainCluster {
+	sm := NewChainCluster(nil)
+	sm.load = func(userId string) (*Chain, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		chain := newStateChain(userId)
+		chain.LastBlockNumber = committed[userId]
+		return chain, nil
+	}
+	return sm
+}
+
+func TestChainCluster_PinnedChainNotEvicted(t *testing.T) {
+	defer func(size int) { ChainCacheSize = size }(ChainCacheSize)
+	ChainCacheSize = 1
+
+	sm := testChainCluster(map[string]int64{}, &sync.Mutex{})
+	a, _ := sm.GetChain("a")
+	b, _ := sm.GetChain("b")
+	b.Release()
+	c, _ := sm.GetChain("c")
+	c.Release()
+
+	if again, _ := sm.GetChain("a"); again != a {
+		t.Errorf("expected pinned chain to stay in cache")
+	}
+	a.Release()
+	a.Release()
+	if _, ok := sm.LoadedChains()["b"]; ok {
+		t.Errorf("expected released chain to be evicted")
+	}
+}
+
+func TestChainCluster_ConcurrentGetEvictApply(t *testing.T) {
+	defer func(size int) { ChainCacheSize = size }(ChainCacheSize)
+	ChainCacheSize = 1
+
+	committed := make(map[string]int64)
+	dbLock := &sync.Mutex{}
+	sm := testChainCluster(committed, dbLock)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				userId := fmt.Sprintf("user%d", (worker+i)%3)
+				chain, err := sm.GetChain(userId)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// apply: a second chain of the same user would be behind what is committed
+				chain.lock.Lock()
+				dbLock.Lock()
+				if chain.LastBlockNumber != committed[userId] {
+					select {
+					case errs <- fmt.Errorf("chain of %s forked at block %d (committed %d)", userId, chain.LastBlockNumber, committed[userId]):
+					default:
+					}
+				}
+				chain.LastBlockNumber++
+				committed[userId] = chain.LastBlockNumber
+				dbLock.Unlock()
+				chain.lock.Unlock()
+				chain.Release()
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserLocation_LoadedChain(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func(chains *ChainCluster) { Chains = chains }(Chains)
+	Chains = testChainCluster(map[string]int64{}, &sync.Mutex{})
+
+	chain, _ := Chains.GetChain("uid")
+	defer chain.Release()
+	chain.setLocation(seoul)
+	if location := UserLocation("uid"); location != seoul {
+		t.Errorf("expected location of loaded chain, got %s", location)
+	}
+	if location := UserLocation("other"); location != time.Local {
+		t.Errorf("expected local timezone without database, got %s", location)
+	}
+}
+
+func TestChainCluster_LoadWithoutBlockingOthers(t *testing.T) {
+	sm := testChainCluster(map[string]int64{}, &sync.Mutex{})
+	loaded, _ := sm.GetChain("loaded")
+	loaded.Release()
+
+	// loading chain of slow is blocked until release is closed
+	release := make(chan struct{})
+	loading := make(chan struct{})
+	var loads sync.Map
+	load := sm.load
+	sm.load = func(userId string) (*Chain, error) {
+		count, _ := loads.LoadOrStore(userId, new(int32))
+		*count.(*int32)++
+		if userId == "slow" {
+			close(loading)
+			<-release
+		}
+		return load(userId)
+	}
+
+	chains := make(chan *Chain, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			chain, err := sm.GetChain("slow")
+			if err != nil {
+				t.Error(err)
+			}
+			chains <- chain
+		}()
+	}
+	<-loading
+
+	done := make(chan struct{})
+	go func() {
+		chain, _ := sm.GetChain("loaded")
+		chain.Release()
+		other, _ := sm.GetChain("other")
+		other.Release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected chains of other users to be got while a chain is loading")
+	}
+
+	close(release)
+	first, second := <-chains, <-chains
+	if first != second {
+		t.Errorf("expected the same chain to be returned to concurrent callers")
+	}
+	if count, _ := loads.Load("slow"); *count.(*int32) != 1 {
+		t.Errorf("expected chain loaded once, loaded %d times", *count.(*int32))
+	}
+	if refs := first.refs.Load(); refs != 2 {
+		t.Errorf("expected chain pinned by both callers, got %d refs", refs)
+	}
+}
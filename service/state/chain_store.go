@@ -0,0 +1,85 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"memorial_app_server/service/database"
+)
+
+// chainStore reads and writes stored blocks of chains with their transactions
+type chainStore interface {
+	// lastCheckpoint returns number of the last block of user storing full state at or before number, 0 if none
+	lastCheckpoint(userId string, number int64) (int64, error)
+	// blocks returns stored blocks of user from start to end ordered by number, and their transactions by hash
+	blocks(userId string, start, end int64) ([]database.BlockEntity, map[string]database.TransactionEntity, error)
+	// save stores transaction and its block at once
+	save(tx database.TransactionEntity, block database.BlockEntity) error
+}
+
+// databaseChainStore stores chains in blocks and transactions tables of database.DB
+type databaseChainStore struct{}
+
+func (databaseChainStore) lastCheckpoint(userId string, number int64) (int64, error) {
+	var checkpoint sql.NullInt64
+	err := database.DB.Get(&checkpoint, "SELECT MAX(block_number) FROM blocks WHERE uid = ? AND block_number <= ? AND state IS NOT NULL", userId, number)
+	if err != nil {
+		return 0, err
+	}
+	return checkpoint.Int64, nil
+}
+
+func (databaseChainStore) blocks(userId string, start, end int64) ([]database.BlockEntity, map[string]database.TransactionEntity, error) {
+	var blockEntities []database.BlockEntity
+	err := database.DB.Select(&blockEntities, "SELECT * FROM blocks WHERE uid = ? AND block_number >= ? AND block_number <= ? ORDER BY block_number", userId, start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txs := make(map[string]database.TransactionEntity, len(blockEntities))
+	if len(blockEntities) == 0 {
+		return blockEntities, txs, nil
+	}
+	txHashes := make([]string, 0, len(blockEntities))
+	for _, blockEntity := range blockEntities {
+		if blockEntity.TxHash != nil {
+			txHashes = append(txHashes, *blockEntity.TxHash)
+		}
+	}
+	query, args, err := sqlx.In("SELECT * FROM transactions WHERE `from` = ? AND hash IN (?)", userId, txHashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	var txEntities []database.TransactionEntity
+	if err := database.DB.Select(&txEntities, sqlx.Rebind(sqlx.QUESTION, query), args...); err != nil {
+		return nil, nil, err
+	}
+	for _, txEntity := range txEntities {
+		txs[*txEntity.Hash] = txEntity
+	}
+	return blockEntities, txs, nil
+}
+
+func (databaseChainStore) save(tx database.TransactionEntity, block database.BlockEntity) error {
+	ctx, err := database.DB.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ctx.Rollback() }()
+
+	_, err = ctx.Exec(
+		"INSERT INTO transactions (version, type, `from`, timestamp, content, hash) VALUES (?, ?, ?, ?, ?, ?)",
+		tx.Version, tx.Type, tx.From, tx.Timestamp, tx.Content, tx.Hash,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = ctx.Exec(
+		"INSERT INTO blocks (uid, transitions, state, block_number, block_hash, tx_hash, prev_block_hash, state_root, executed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		block.UserId, block.Transitions, block.State, block.Number, block.BlockHash, block.TxHash, block.PrevBlockHash, block.StateRoot, block.ExecutedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return ctx.Commit()
+}
@@ -0,0 +1,246 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"memorial_app_server/service/database"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryChainStore keeps stored blocks in memory, rows are appended like the blocks table without unique block number
+type memoryChainStore struct {
+	lock    sync.Mutex
+	rows    map[string][]database.BlockEntity
+	txs     map[string]database.TransactionEntity
+	reads   int      // number of blocks queries
+	read    [2]int64 // range of the last blocks query
+	saveErr error    // returned by save if set
+}
+
+func newMemoryChainStore() *memoryChainStore {
+	return &memoryChainStore{rows: make(map[string][]database.BlockEntity), txs: make(map[string]database.TransactionEntity)}
+}
+
+func (s *memoryChainStore) lastCheckpoint(userId string, number int64) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	checkpoint := int64(0)
+	for _, row := range s.rows[userId] {
+		if *row.Number <= number && row.State != nil && *row.Number > checkpoint {
+			checkpoint = *row.Number
+		}
+	}
+	return checkpoint, nil
+}
+
+func (s *memoryChainStore) blocks(userId string, start, end int64) ([]database.BlockEntity, map[string]database.TransactionEntity, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reads++
+	s.read = [2]int64{start, end}
+	rows := make([]database.BlockEntity, 0)
+	txs := make(map[string]database.TransactionEntity)
+	for _, row := range s.rows[userId] {
+		if *row.Number >= start && *row.Number <= end {
+			rows = append(rows, row)
+			txs[*row.TxHash] = s.txs[*row.TxHash]
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return *rows[i].Number < *rows[j].Number })
+	return rows, txs, nil
+}
+
+func (s *memoryChainStore) save(tx database.TransactionEntity, block database.BlockEntity) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	if _, exists := s.txs[*tx.Hash]; exists {
+		return fmt.Errorf("duplicated transaction hash %s", *tx.Hash)
+	}
+	s.txs[*tx.Hash] = tx
+	s.rows[*block.UserId] = append(s.rows[*block.UserId], block)
+	return nil
+}
+
+// rowCount returns number of stored rows of block
+func (s *memoryChainStore) rowCount(userId string, number int64) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	count := 0
+	for _, row := range s.rows[userId] {
+		if *row.Number == number {
+			count++
+		}
+	}
+	return count
+}
+
+// testChain returns empty chain of user stored in store
+func testChain(userId string, store chainStore) *Chain {
+	chain := newStateChain(userId)
+	chain.store = store
+	return chain
+}
+
+// testTx returns transaction with its hash, timestamp makes hashes of the same content differ
+func testTx(txType int64, timestamp int64, content map[string]interface{}) *Transaction {
+	tx := NewTransaction(SchemeVersion, "uid", txType, timestamp, content, "")
+	tx.Hash = tx.CalcHash().Hex()
+	return tx
+}
+
+// applyTestTxs applies n transactions creating and renaming tasks, and returns the applied blocks
+func applyTestTxs(t *testing.T, chain *Chain, n int) []*Block {
+	blocks := make([]*Block, 0, n)
+	for i := 0; i < n; i++ {
+		content := map[string]interface{}{"tid": fmt.Sprintf("t%d", i%3), "title": fmt.Sprintf("title %d", i)}
+		var txType int64 = TxUpdateTaskTitle
+		if i < 3 {
+			txType = TxCreateTask
+			if i > 0 {
+				content["prevTaskId"] = fmt.Sprintf("t%d", i-1)
+			}
+		}
+		block, err := chain.ApplyTransaction(testTx(txType, int64(i+1), content), chain.GetWaitingBlockNumber())
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestChain_ReplayEvictedBlocks(t *testing.T) {
+	defer func(interval int64, size int) { CheckpointInterval, BlockCacheSize = interval, size }(CheckpointInterval, BlockCacheSize)
+	CheckpointInterval, BlockCacheSize = 5, 3
+
+	store := newMemoryChainStore()
+	chain := testChain("uid", store)
+	applied := applyTestTxs(t, chain, 12)
+	if len(chain.Blocks) > BlockCacheSize+1 {
+		t.Fatalf("expected blocks evicted, %d cached", len(chain.Blocks))
+	}
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		original := applied[i]
+		replayed, err := chain.GetBlockByNumber(original.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayed.Hash != original.Hash || replayed.PrevBlockHash != original.PrevBlockHash || replayed.StateRoot != original.StateRoot {
+			t.Errorf("block #%d: expected hashes %s/%s, got %s/%s", original.Number, original.Hash, original.StateRoot, replayed.Hash, replayed.StateRoot)
+		}
+		expected, _ := stateDigest(original.State)
+		actual, _ := stateDigest(replayed.State)
+		if expected != actual {
+			t.Errorf("block #%d: replayed state differs from the applied one", original.Number)
+		}
+	}
+}
+
+func TestChain_ReplayReadsRangeAtOnce(t *testing.T) {
+	defer func(interval int64) { CheckpointInterval = interval }(CheckpointInterval)
+	CheckpointInterval = 5
+
+	store := newMemoryChainStore()
+	applied := applyTestTxs(t, testChain("uid", store), 12)
+
+	// reloaded chain has only the initial block cached
+	chain := testChain("uid", store)
+	chain.LastBlockNumber = 12
+	reads := store.reads
+	block, err := chain.GetBlockByNumber(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != applied[6].Hash {
+		t.Errorf("expected hash of block #7 %s, got %s", applied[6].Hash, block.Hash)
+	}
+	if store.reads-reads != 1 || store.read != [2]int64{5, 7} {
+		t.Errorf("expected blocks from checkpoint #5 read at once, read %d times (last %v)", store.reads-reads, store.read)
+	}
+
+	// replayed from the cached block before, not from the checkpoint
+	reads = store.reads
+	block, err = chain.GetBlockByNumber(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != applied[8].Hash {
+		t.Errorf("expected hash of block #9 %s, got %s", applied[8].Hash, block.Hash)
+	}
+	if store.reads-reads != 1 || store.read != [2]int64{8, 9} {
+		t.Errorf("expected blocks after cached #7 read at once, read %d times (last %v)", store.reads-reads, store.read)
+	}
+	if _, cached := chain.getCachedBlock(8); !cached {
+		t.Errorf("expected replayed block #8 cached")
+	}
+
+	if _, err := chain.GetBlockByNumber(13); err == nil {
+		t.Errorf("expected block not stored to be not found")
+	}
+}
+
+func TestChain_ApplyTransactionSaveFailure(t *testing.T) {
+	store := newMemoryChainStore()
+	chain := testChain("uid", store)
+	applyTestTxs(t, chain, 1)
+
+	notified := 0
+	defer func(listeners []StateListener) { stateListeners = listeners }(stateListeners)
+	stateListeners = []StateListener{func(userId string, lastState *State) { notified++ }}
+
+	store.saveErr = errors.New("database is down")
+	tx := testTx(TxUpdateTaskTitle, 100, map[string]interface{}{"tid": "t0", "title": "not saved"})
+	if _, err := chain.ApplyTransaction(tx, 2); err == nil {
+		t.Fatalf("expected block not saved to fail")
+	}
+	if chain.GetLastBlockNumber() != 1 || notified != 0 {
+		t.Errorf("expected block not saved to be neither cached nor notified, last block #%d, notified %d", chain.GetLastBlockNumber(), notified)
+	}
+	if _, cached := chain.getCachedBlock(2); cached {
+		t.Errorf("expected block not saved to be not cached")
+	}
+
+	// same transaction is applied once the database is back
+	store.saveErr = nil
+	if block, err := chain.ApplyTransaction(tx, 2); err != nil || block.Number != 2 || notified != 1 {
+		t.Errorf("expected block #2 applied, got %v (%v)", block, err)
+	}
+}
+
+func TestChain_ApplyTransactionAsOfServerTime(t *testing.T) {
+	store := newMemoryChainStore()
+	chain := testChain("uid", store)
+	dueDate := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	create := testTx(TxCreateTask, dueDate.UnixMilli(), map[string]interface{}{
+		"tid": "t1", "title": "daily", "dueDate": dueDate.UnixMilli(), "repeatPeriod": "FREQ=DAILY",
+	})
+	if _, err := chain.ApplyTransaction(create, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// client's clock is years ahead
+	before := time.Now()
+	done := testTx(TxUpdateTaskDone, before.AddDate(10, 0, 0).UnixMilli(), map[string]interface{}{"tid": "t1", "done": true})
+	block, err := chain.ApplyTransaction(done, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	if block.ExecutedAt < before.UnixMilli() || block.ExecutedAt > after.UnixMilli() {
+		t.Errorf("expected block executed at server time, got %d", block.ExecutedAt)
+	}
+	if next := block.State.Tasks["t1"].DueDate; next <= before.UnixMilli() || next > after.AddDate(0, 0, 2).UnixMilli() {
+		t.Errorf("expected next due date after server time, got %s", time.UnixMilli(next))
+	}
+	if stored := store.rows["uid"][1]; stored.ExecutedAt == nil || *stored.ExecutedAt != block.ExecutedAt {
+		t.Errorf("expected execution time stored with block")
+	}
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EnvelopePrefix marks text encrypted by client (end-to-end), server stores and hashes it as it is.
+// envelope is "e2e1:<key id>:<nonce>:<ciphertext>", nonce and ciphertext in unpadded base64url.
+const EnvelopePrefix = "e2e1:"
+
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// Envelope is text of task encrypted with a key of user that server doesn't know
+type Envelope struct {
+	KeyId      string
+	Nonce      string
+	Ciphertext string
+}
+
+func (e *Envelope) String() string {
+	return EnvelopePrefix + e.KeyId + ":" + e.Nonce + ":" + e.Ciphertext
+}
+
+// IsEnvelope reports whether text is (or looks like) an envelope, it's opaque to server
+func IsEnvelope(text string) bool {
+	return strings.HasPrefix(text, EnvelopePrefix)
+}
+
+func ParseEnvelope(text string) (*Envelope, error) {
+	if !IsEnvelope(text) {
+		return nil, ErrInvalidEnvelope
+	}
+	parts := strings.Split(strings.TrimPrefix(text, EnvelopePrefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrInvalidEnvelope
+	}
+	envelope := &Envelope{KeyId: parts[0], Nonce: parts[1], Ciphertext: parts[2]}
+	if validateKeyId(envelope.KeyId) != nil || envelope.Nonce == "" || envelope.Ciphertext == "" {
+		return nil, ErrInvalidEnvelope
+	}
+	for _, encoded := range []string{envelope.Nonce, envelope.Ciphertext} {
+		if _, err := base64.RawURLEncoding.DecodeString(encoded); err != nil {
+			return nil, ErrInvalidEnvelope
+		}
+	}
+	return envelope, nil
+}
+
+func validateKeyId(keyId string) error {
+	if keyId == "" || len(keyId) > 64 {
+		return fmt.Errorf("invalid key id: %q", keyId)
+	}
+	for _, c := range keyId {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("invalid key id: %q", keyId)
+		}
+	}
+	return nil
+}
+
+// validateEncryptedText checks if text is empty or an envelope of key
+func validateEncryptedText(text string, keyId string) error {
+	if text == "" {
+		return nil
+	}
+	envelope, err := ParseEnvelope(text)
+	if err != nil {
+		return err
+	}
+	if envelope.KeyId != keyId {
+		return fmt.Errorf("%w: encrypted with key %s, not %s", ErrInvalidEnvelope, envelope.KeyId, keyId)
+	}
+	return nil
+}
+
+// validateTaskEncryption checks if texts (and tags) of task in encrypted category are envelopes of the category key.
+// task can't be in encrypted categories of different keys.
+func validateTaskEncryption(task Task, categories map[string]Category) error {
+	keyId := ""
+	for categoryId := range task.Categories {
+		category := categories[categoryId]
+		if !category.Encrypted() {
+			continue
+		}
+		if keyId != "" && keyId != category.KeyId {
+			return fmt.Errorf("task %s is in categories encrypted with different keys", task.Id)
+		}
+		keyId = category.KeyId
+	}
+	if keyId == "" {
+		return nil
+	}
+
+	if err := validateEncryptedText(task.Title, keyId); err != nil {
+		return fmt.Errorf("title of task %s: %w", task.Id, err)
+	}
+	if err := validateEncryptedText(task.Memo, keyId); err != nil {
+		return fmt.Errorf("memo of task %s: %w", task.Id, err)
+	}
+	for _, tag := range task.Tags {
+		if err := validateEncryptedText(tag, keyId); err != nil {
+			return fmt.Errorf("tag of task %s: %w", task.Id, err)
+		}
+	}
+	for _, subtask := range task.Subtasks {
+		if err := validateEncryptedText(subtask.Title, keyId); err != nil {
+			return fmt.Errorf("title of subtask %s: %w", subtask.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	envelope, err := ParseEnvelope("e2e1:key-1:bm9uY2U:Y2lwaGVy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyId != "key-1" || envelope.String() != "e2e1:key-1:bm9uY2U:Y2lwaGVy" {
+		t.Errorf("unexpected envelope: %+v", envelope)
+	}
+	for _, text := range []string{"plain", "e2e1:key", "e2e1:key:bm9uY2U:", "e2e1:k:ey:bm9uY2U:Y2lwaGVy", "e2e1:key:!!:Y2lwaGVy"} {
+		if _, err := ParseEnvelope(text); err == nil {
+			t.Errorf("%q: expected invalid envelope", text)
+		}
+	}
+}
+
+func TestRotateCategoryKey(t *testing.T) {
+	state := NewState()
+	state.Categories["c1"] = Category{Id: "c1", Secret: true}
+	state.Tasks["t1"] = Task{
+		Id:         "t1",
+		Title:      "plain title",
+		Tags:       []string{"plain tag"},
+		Subtasks:   map[string]Subtask{"s1": {Id: "s1", Title: "plain subtask"}},
+		Categories: map[string]bool{"c1": true},
+	}
+
+	// tags have to be re-wrapped too
+	tx := NewTransaction(SchemeVersion, "uid", TxRotateCategoryKey, 0, map[string]interface{}{
+		"cid":   "c1",
+		"keyId": "k1",
+		"contents": map[string]interface{}{
+			"t1": map[string]interface{}{"title": "e2e1:k1:bjE:dDE", "subtasks": map[string]string{"s1": "e2e1:k1:bjI:czE"}},
+		},
+	}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected rotation without tags to fail")
+	}
+
+	// encrypt category from plain
+	state = executeTx(t, state, TxRotateCategoryKey, map[string]interface{}{
+		"cid":   "c1",
+		"keyId": "k1",
+		"contents": map[string]interface{}{
+			"t1": map[string]interface{}{"title": "e2e1:k1:bjE:dDE", "tags": []string{"e2e1:k1:bjM:dGFn"}, "subtasks": map[string]string{"s1": "e2e1:k1:bjI:czE"}},
+		},
+	})
+	if err := state.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if task := state.Tasks["t1"]; state.Categories["c1"].KeyId != "k1" || task.Subtasks["s1"].Title != "e2e1:k1:bjI:czE" || !equalTags(task.Tags, []string{"e2e1:k1:bjM:dGFn"}) {
+		t.Errorf("expected texts re-wrapped, got %+v", state.Tasks["t1"])
+	}
+
+	// plain tag or tag of old key is invalid in encrypted category
+	for _, tag := range []string{"plain", "e2e1:k0:bjM:dGFn"} {
+		invalid := state.Copy()
+		task := invalid.Tasks["t1"]
+		task.Tags = []string{tag}
+		invalid.Tasks["t1"] = task
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%q: expected invalid tag in encrypted category", tag)
+		}
+	}
+
+	// plain text or envelope of old key is invalid in encrypted category
+	for _, title := range []string{"plain", "e2e1:k0:bjE:dDE"} {
+		invalid := state.Copy()
+		task := invalid.Tasks["t1"]
+		task.Title = title
+		invalid.Tasks["t1"] = task
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%q: expected invalid title in encrypted category", title)
+		}
+	}
+
+	// contents of every task are required
+	tx = NewTransaction(SchemeVersion, "uid", TxRotateCategoryKey, 0, map[string]interface{}{"cid": "c1", "keyId": "k2", "contents": map[string]interface{}{}}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected rotation without contents to fail")
+	}
+}
@@ -3,21 +3,29 @@ package state
 import (
 	"fmt"
 	"memorial_app_server/log"
-	"memorial_app_server/util"
+	"sort"
 	"time"
 )
 
 const (
 	TxInitialize = 0
 
-	TxCreateTask             = 10000
-	TxDeleteTask             = 10001
-	TxUpdateTaskOrder        = 10002
-	TxUpdateTaskTitle        = 10003
-	TxUpdateTaskDueDate      = 10004
-	TxUpdateTaskMemo         = 10005
-	TxUpdateTaskDone         = 10006
-	TxUpdateTaskRepeatPeriod = 10007
+	TxCreateTask               = 10000
+	TxDeleteTask               = 10001
+	TxUpdateTaskOrder          = 10002
+	TxUpdateTaskTitle          = 10003
+	TxUpdateTaskDueDate        = 10004
+	TxUpdateTaskMemo           = 10005
+	TxUpdateTaskDone           = 10006
+	TxUpdateTaskRepeatPeriod   = 10007
+	TxSkipTaskOccurrence       = 10008
+	TxRescheduleTaskOccurrence = 10009
+	TxUpdateTaskRepeatMode     = 10010
+	TxFoldTaskClones           = 10011
+	TxUpdateTaskReminders      = 10012
+	TxUpdateTaskPriority       = 10013
+	TxUpdateTaskTags           = 10014
+	TxUpdateTaskEffort         = 10015
 
 	TxAddTaskCategory    = 10100
 	TxDeleteTaskCategory = 10101
@@ -27,66 +35,117 @@ const (
 	TxUpdateSubtaskTitle   = 11002
 	TxUpdateSubtaskDueDate = 11003
 	TxUpdateSubtaskDone    = 11004
-
-	TxCreateCategory      = 12000
-	TxDeleteCategory      = 12001
-	TxUpdateCategoryColor = 12002
+	TxUpdateSubtaskOrder   = 11005
+
+	TxCreateCategory       = 12000
+	TxDeleteCategory       = 12001
+	TxUpdateCategoryColor  = 12002
+	TxUpdateCategoryTitle  = 12003
+	TxUpdateCategorySecret = 12004
+	TxUpdateCategoryLocked = 12005
+	TxRotateCategoryKey    = 12006
+
+	TxRevertBlock = 20000
+	TxBatch       = 20001
 )
 
-func PreExecuteTransaction(prevState *State, tx *Transaction, newBlockNumber int64) (*Updates, error) {
-	state := prevState.Copy()
+func init() {
+	registerSimpleTx(TxInitialize, "initialize", validateInitialize, InitializeState)
+
+	registerSimpleTx(TxCreateTask, "createTask", validateCreateTask, CreateTask)
+	registerSimpleTx(TxDeleteTask, "deleteTask", nil, DeleteTask)
+	registerSimpleTx(TxUpdateTaskOrder, "updateTaskOrder", nil, UpdateTaskOrder)
+	registerSimpleTx(TxUpdateTaskTitle, "updateTaskTitle", nil, UpdateTaskTitle)
+	registerSimpleTx(TxUpdateTaskDueDate, "updateTaskDueDate", validateUpdateTaskDueDate, UpdateTaskDueDate)
+	registerSimpleTx(TxUpdateTaskMemo, "updateTaskMemo", nil, UpdateTaskMemo)
+	registerTx(TxUpdateTaskDone, "updateTaskDone", nil, UpdateTaskDone)
+	registerSimpleTx(TxUpdateTaskRepeatPeriod, "updateTaskRepeatPeriod", validateUpdateTaskRepeatPeriod, UpdateTaskRepeatPeriod)
+	registerTx(TxSkipTaskOccurrence, "skipTaskOccurrence", nil, SkipTaskOccurrence)
+	registerTx(TxRescheduleTaskOccurrence, "rescheduleTaskOccurrence", nil, RescheduleTaskOccurrence)
+	registerSimpleTx(TxUpdateTaskRepeatMode, "updateTaskRepeatMode", validateUpdateTaskRepeatMode, UpdateTaskRepeatMode)
+	registerSimpleTx(TxFoldTaskClones, "foldTaskClones", nil, FoldTaskClones)
+	registerSimpleTx(TxUpdateTaskReminders, "updateTaskReminders", validateUpdateTaskReminders, UpdateTaskReminders)
+	registerSimpleTx(TxUpdateTaskPriority, "updateTaskPriority", validateUpdateTaskPriority, UpdateTaskPriority)
+	registerSimpleTx(TxUpdateTaskTags, "updateTaskTags", validateUpdateTaskTags, UpdateTaskTags)
+	registerSimpleTx(TxUpdateTaskEffort, "updateTaskEffort", validateUpdateTaskEffort, UpdateTaskEffort)
+
+	registerSimpleTx(TxAddTaskCategory, "addTaskCategory", nil, AddTaskCategory)
+	registerSimpleTx(TxDeleteTaskCategory, "deleteTaskCategory", nil, DeleteTaskCategory)
+
+	registerSimpleTx(TxCreateSubtask, "createSubtask", nil, CreateSubtask)
+	registerSimpleTx(TxDeleteSubtask, "deleteSubtask", nil, DeleteSubtask)
+	registerSimpleTx(TxUpdateSubtaskTitle, "updateSubtaskTitle", nil, UpdateSubtaskTitle)
+	registerSimpleTx(TxUpdateSubtaskDueDate, "updateSubtaskDueDate", nil, UpdateSubtaskDueDate)
+	registerSimpleTx(TxUpdateSubtaskDone, "updateSubtaskDone", nil, UpdateSubtaskDone)
+	registerSimpleTx(TxUpdateSubtaskOrder, "updateSubtaskOrder", nil, UpdateSubtaskOrder)
+
+	registerSimpleTx(TxCreateCategory, "createCategory", validateCreateCategory, CreateCategory)
+	registerSimpleTx(TxDeleteCategory, "deleteCategory", validateDeleteCategory, DeleteCategory)
+	registerSimpleTx(TxUpdateCategoryColor, "updateCategoryColor", nil, UpdateCategoryColor)
+	registerSimpleTx(TxUpdateCategoryTitle, "updateCategoryTitle", nil, UpdateCategoryTitle)
+	registerSimpleTx(TxUpdateCategorySecret, "updateCategorySecret", nil, UpdateCategorySecret)
+	registerSimpleTx(TxUpdateCategoryLocked, "updateCategoryLocked", nil, UpdateCategoryLocked)
+	registerSimpleTx(TxRotateCategoryKey, "rotateCategoryKey", validateRotateCategoryKey, RotateCategoryKey)
+
+	registerTx(TxRevertBlock, "revertBlock", nil, RevertBlock)
+	registerTx(TxBatch, "batch", validateBatch, Batch)
+}
 
-	switch tx.Type {
-	case TxInitialize:
-		return InitializeState(state, tx, newBlockNumber)
-	case TxCreateTask:
-		return CreateTask(state, tx)
-	case TxDeleteTask:
-		return DeleteTask(state, tx)
-	case TxUpdateTaskOrder:
-		return UpdateTaskOrder(state, tx)
-	case TxUpdateTaskTitle:
-		return UpdateTaskTitle(state, tx)
-	case TxUpdateTaskDueDate:
-		return UpdateTaskDueDate(state, tx)
-	case TxUpdateTaskMemo:
-		return UpdateTaskMemo(state, tx)
-	case TxUpdateTaskDone:
-		return UpdateTaskDone(state, tx)
-	case TxUpdateTaskRepeatPeriod:
-		return UpdateTaskRepeatPeriod(state, tx)
-	case TxAddTaskCategory:
-		return AddTaskCategory(state, tx)
-	case TxDeleteTaskCategory:
-		return DeleteTaskCategory(state, tx)
-	case TxCreateSubtask:
-		return CreateSubtask(state, tx)
-	case TxDeleteSubtask:
-		return DeleteSubtask(state, tx)
-	case TxUpdateSubtaskTitle:
-		return UpdateSubtaskTitle(state, tx)
-	case TxUpdateSubtaskDueDate:
-		return UpdateSubtaskDueDate(state, tx)
-	case TxUpdateSubtaskDone:
-		return UpdateSubtaskDone(state, tx)
-	case TxCreateCategory:
-		return CreateCategory(state, tx)
-	case TxDeleteCategory:
-		return DeleteCategory(state, tx)
-	case TxUpdateCategoryColor:
-		return UpdateCategoryColor(state, tx)
-	default:
-		return nil, ErrInvalidTxType
-	}
+// PreExecuteTransaction builds transitions of tx on prevState,
+// blocks are read by transactions referencing other blocks (can be nil otherwise).
+// location is the timezone of user recurrences are calculated in (server's local timezone if nil).
+// transaction is executed as of the current time.
+func PreExecuteTransaction(prevState *State, tx *Transaction, newBlockNumber int64, blocks BlockReader, location *time.Location) (*Updates, error) {
+	return preExecuteTransaction(prevState, tx, &TxContext{
+		NewBlockNumber: newBlockNumber,
+		Blocks:         blocks,
+		Location:       location,
+		Now:            time.Now(),
+	})
 }
 
-func InitializeState(prevState *State, tx *Transaction, newBlockNumber int64) (*Updates, error) {
-	updates := NewUpdates(tx)
+// preExecuteTransaction builds transitions of tx on prevState in ctx
+func preExecuteTransaction(prevState *State, tx *Transaction, ctx *TxContext) (*Updates, error) {
+	state := prevState.Copy()
 
-	var body TxInitializeBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
+	spec, err := GetTxSpec(tx.Type)
+	if err != nil {
+		return nil, err
+	}
+	// transactions of older scheme are executed as of the current one
+	upcastedTx, err := UpcastTransaction(tx)
+	if err != nil {
 		return nil, err
 	}
+	body, err := spec.Decode(upcastedTx.Content)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Validate != nil {
+		if err := spec.Validate(body); err != nil {
+			return nil, err
+		}
+	}
+	updates, err := spec.Execute(state, upcastedTx, body, ctx)
+	if err != nil {
+		return nil, err
+	}
+	// block refers to the original transaction
+	updates.SrcTx = tx
+	return updates, nil
+}
+
+func validateInitialize(body *TxInitializeBody) error {
+	for _, task := range body.Tasks {
+		if err := validateTaskAttributes(task.Priority, task.Tags, task.Effort); err != nil {
+			return fmt.Errorf("task %s: %w", task.Id, err)
+		}
+	}
+	return nil
+}
+
+func InitializeState(prevState *State, tx *Transaction, body *TxInitializeBody) (*Updates, error) {
+	updates := NewUpdates(tx)
 
 	// delete all data from old state
 	updates.add(OpDeleteAll, nil)
@@ -99,6 +158,7 @@ func InitializeState(prevState *State, tx *Transaction, newBlockNumber int64) (*
 			Locked:    category.Locked,
 			Color:     category.Color,
 			CreatedAt: category.CreatedAt,
+			KeyId:     category.KeyId,
 		})
 	}
 
@@ -116,9 +176,18 @@ func InitializeState(prevState *State, tx *Transaction, newBlockNumber int64) (*
 			Memo:          task.Memo,
 			Done:          task.Done,
 			DueDate:       task.DueDate,
+			AllDay:        task.AllDay,
+			Reminders:     task.Reminders,
+			Priority:      task.Priority,
+			Tags:          task.Tags,
+			Effort:        task.Effort,
 			RepeatPeriod:  task.RepeatPeriod,
 			RepeatStartAt: task.RepeatStartAt,
 			Categories:    categories,
+
+			RepeatMode:      task.RepeatMode,
+			RescheduledFrom: task.RescheduledFrom,
+			Occurrences:     task.Occurrences,
 		})
 
 		updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{
@@ -137,17 +206,32 @@ func InitializeState(prevState *State, tx *Transaction, newBlockNumber int64) (*
 				DoneAt:    subtask.DoneAt,
 			})
 		}
+		addSubtaskOrder(updates, task)
 	}
 
 	return updates, nil
 }
 
-func CreateTask(state *State, tx *Transaction) (*Updates, error) {
-	updates := NewUpdates(tx)
-	var body TxCreateTaskBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
+func validateCreateTask(body *TxCreateTaskBody) error {
+	if err := validateDueDate(body.DueDate, body.AllDay); err != nil {
+		return err
+	}
+	if body.RepeatPeriod != "" {
+		if _, err := ParseRepeatPeriod(body.RepeatPeriod); err != nil {
+			return err
+		}
+	}
+	if err := validateReminders(body.Reminders); err != nil {
+		return err
 	}
+	if err := validateTaskAttributes(body.Priority, body.Tags, body.Effort); err != nil {
+		return err
+	}
+	return validateRepeatMode(body.RepeatMode)
+}
+
+func CreateTask(state *State, tx *Transaction, body *TxCreateTaskBody) (*Updates, error) {
+	updates := NewUpdates(tx)
 
 	categories := make(map[string]bool)
 	for categoryId := range body.Categories {
@@ -165,8 +249,14 @@ func CreateTask(state *State, tx *Transaction) (*Updates, error) {
 		Memo:          body.Memo,
 		Done:          body.Done,
 		DueDate:       body.DueDate,
+		AllDay:        body.AllDay,
+		Reminders:     normalizeReminders(body.Reminders),
+		Priority:      body.Priority,
+		Tags:          normalizeTags(body.Tags),
+		Effort:        body.Effort,
 		RepeatPeriod:  body.RepeatPeriod,
 		RepeatStartAt: body.RepeatStartAt,
+		RepeatMode:    body.RepeatMode,
 		Categories:    categories,
 	})
 
@@ -182,12 +272,8 @@ func CreateTask(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func DeleteTask(state *State, tx *Transaction) (*Updates, error) {
+func DeleteTask(state *State, tx *Transaction, body *TxDeleteTaskBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxDeleteTaskBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	sortedTasks, err := state.SortTasks()
 	if err != nil {
@@ -226,12 +312,8 @@ func DeleteTask(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateTaskOrder(state *State, tx *Transaction) (*Updates, error) {
+func UpdateTaskOrder(state *State, tx *Transaction, body *TxUpdateTaskOrderBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskOrderBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	currentTask, ok := state.Tasks[body.Id]
 	if !ok {
@@ -331,12 +413,8 @@ func UpdateTaskOrder(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateTaskTitle(state *State, tx *Transaction) (*Updates, error) {
+func UpdateTaskTitle(state *State, tx *Transaction, body *TxUpdateTaskTitleBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskTitleBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	_, ok := state.Tasks[body.Id]
 	if !ok {
@@ -351,12 +429,12 @@ func UpdateTaskTitle(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateTaskDueDate(state *State, tx *Transaction) (*Updates, error) {
+func validateUpdateTaskDueDate(body *TxUpdateTaskDueDateBody) error {
+	return validateDueDate(body.DueDate, body.AllDay)
+}
+
+func UpdateTaskDueDate(state *State, tx *Transaction, body *TxUpdateTaskDueDateBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskDueDateBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.Id]
 	if !ok {
@@ -375,16 +453,14 @@ func UpdateTaskDueDate(state *State, tx *Transaction) (*Updates, error) {
 	updates.add(OpUpdateTaskDueDate, &UpdateTaskDueDateParams{
 		Id:      body.Id,
 		DueDate: body.DueDate,
+		AllDay:  body.AllDay,
 	})
+	addRescheduledFromReset(updates, task)
 	return updates, nil
 }
 
-func UpdateTaskMemo(state *State, tx *Transaction) (*Updates, error) {
+func UpdateTaskMemo(state *State, tx *Transaction, body *TxUpdateTaskMemoBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskMemoBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	_, ok := state.Tasks[body.Id]
 	if !ok {
@@ -399,12 +475,8 @@ func UpdateTaskMemo(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateTaskDone(state *State, tx *Transaction) (*Updates, error) {
+func UpdateTaskDone(state *State, tx *Transaction, body *TxUpdateTaskDoneBody, ctx *TxContext) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskDoneBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -412,63 +484,42 @@ func UpdateTaskDone(state *State, tx *Transaction) (*Updates, error) {
 		return nil, ErrStateMismatch
 	}
 
+	// next due date of repeating task, task is done as usual when the recurrence is over
+	repeating := false
+	var nextDueDateMilli int64
 	if task.RepeatPeriod != "" {
-		// create clone of this task with done and save
-		// with repeat deletion
-		doneTask := task.CopyNew()
-		updates.add(OpCreateTask, &CreateTaskParams{
-			Id:            doneTask.Id,
-			Title:         doneTask.Title,
-			CreatedAt:     doneTask.CreatedAt,
-			DoneAt:        doneTask.DoneAt,
-			Memo:          doneTask.Memo,
-			Done:          true,
-			DueDate:       doneTask.DueDate,
-			RepeatPeriod:  "",
-			RepeatStartAt: 0,
-			Categories:    doneTask.Categories,
-		})
-
-		// update task order
-		bidirectionalTasks, err := state.SortTasks()
+		var err error
+		if task.RepeatMode == RepeatModeAfterCompletion {
+			completedAt := body.DoneAt
+			if completedAt == 0 {
+				completedAt = ctx.Now.UnixMilli()
+			}
+			nextDueDateMilli, repeating, err = nextDueDateAfterCompletion(task, completedAt, ctx.Location)
+		} else {
+			nextDueDateMilli, repeating, err = nextDueDate(task, ctx.Now, ctx.Location)
+		}
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		prevTaskId := bidirectionalTasks[task.Id].Prev
-
-		// set new task order
-		if prevTaskId != "" {
-			updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{
-				Id:   prevTaskId,
-				Next: doneTask.Id,
-			})
+	if repeating {
+		// log this occurrence on the task, instead of cloning a done task
+		occurrence := newOccurrence(task)
+		occurrence.DoneAt = body.DoneAt
+		if task.RepeatMode != RepeatModeAfterCompletion {
+			missed, err := countMissedOccurrences(task, nextDueDateMilli, ctx.Location)
+			if err != nil {
+				return nil, err
+			}
+			occurrence.Missed = missed
 		}
-		updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{
-			Id:   doneTask.Id,
-			Next: task.Id,
+		updates.add(OpAddTaskOccurrence, &AddTaskOccurrenceParams{
+			Id:         task.Id,
+			Occurrence: occurrence,
 		})
 
-		repeatStartAt := task.RepeatStartAt
-		if repeatStartAt == 0 {
-			repeatStartAt = task.DueDate
-		}
-		repeatPeriod := task.RepeatPeriod
-		// date milli (int64) -> time
-		nextDueDate := time.Unix(0, repeatStartAt*int64(time.Millisecond))
-		// compare with milliseconds
-		for nextDueDate.Before(time.Now()) || nextDueDate.UnixNano()/int64(time.Millisecond) <= task.DueDate {
-			switch repeatPeriod {
-			case "day":
-				nextDueDate = nextDueDate.AddDate(0, 0, 1)
-			case "week":
-				nextDueDate = nextDueDate.AddDate(0, 0, 7)
-			case "month":
-				nextDueDate = nextDueDate.AddDate(0, 1, 0)
-			case "year":
-				nextDueDate = nextDueDate.AddDate(1, 0, 0)
-			}
-		}
+		nextDueDate := time.UnixMilli(nextDueDateMilli)
 
 		updates.add(OpUpdateTaskDone, &UpdateTaskDoneParams{
 			Id:   body.TaskId,
@@ -481,59 +532,177 @@ func UpdateTaskDone(state *State, tx *Transaction) (*Updates, error) {
 		updates.add(OpUpdateTaskDueDate, &UpdateTaskDueDateParams{
 			Id:      body.TaskId,
 			DueDate: nextDueDate.UnixMilli(),
+			AllDay:  task.AllDay,
 		})
-
-		// calculate difference between task due date and next due date
-		dueDateTime := time.UnixMilli(task.DueDate)
-		diffTime := nextDueDate.Sub(dueDateTime)
+		addRescheduledFromReset(updates, task)
 
 		// update subtasks done & due date
-		for sid, subtask := range task.Subtasks {
+		addSubtasksRollover(updates, task, nextDueDate.UnixMilli(), true)
+	} else {
+		updates.add(OpUpdateTaskDone, &UpdateTaskDoneParams{
+			Id:   body.TaskId,
+			Done: body.Done,
+		})
+		updates.add(OpUpdateTaskDoneAt, &UpdateTaskDoneAtParams{
+			Id:     body.TaskId,
+			DoneAt: body.DoneAt,
+		})
+	}
+
+	return updates, nil
+}
+
+// addSubtasksRollover moves due dates of subtasks along with the due date of task.
+// subtasks are undone if they belong to a new occurrence.
+func addSubtasksRollover(updates *Updates, task Task, newDueDate int64, undone bool) {
+	// calculate difference between task due date and next due date
+	diffTime := time.UnixMilli(newDueDate).Sub(time.UnixMilli(task.DueDate))
+
+	for sid, subtask := range task.Subtasks {
+		if undone {
 			// set done as false
 			updates.add(OpUpdateSubtaskDone, &UpdateSubtaskDoneParams{
-				Id:        body.TaskId,
+				Id:        task.Id,
 				SubtaskId: sid,
 				Done:      false,
 			})
 			updates.add(OpUpdateSubtaskDoneAt, &UpdateSubtaskDoneAtParams{
-				Id:        body.TaskId,
+				Id:        task.Id,
 				SubtaskId: sid,
 				DoneAt:    0,
 			})
+		}
 
-			// update subtasks due date
-			subtaskDueDate := subtask.DueDate
-			if subtaskDueDate != 0 {
-				subtaskDueDateTime := time.UnixMilli(subtaskDueDate)
-				newSubtaskDueDateTime := subtaskDueDateTime.Add(diffTime)
-				updates.add(OpUpdateSubtaskDueDate, &UpdateSubtaskDueDateParams{
-					Id:        body.TaskId,
-					SubtaskId: sid,
-					DueDate:   newSubtaskDueDateTime.UnixMilli(),
-				})
-			}
+		// update subtasks due date
+		subtaskDueDate := subtask.DueDate
+		if subtaskDueDate != 0 {
+			subtaskDueDateTime := time.UnixMilli(subtaskDueDate)
+			newSubtaskDueDateTime := subtaskDueDateTime.Add(diffTime)
+			updates.add(OpUpdateSubtaskDueDate, &UpdateSubtaskDueDateParams{
+				Id:        task.Id,
+				SubtaskId: sid,
+				DueDate:   newSubtaskDueDateTime.UnixMilli(),
+			})
 		}
-	} else {
-		updates.add(OpUpdateTaskDone, &UpdateTaskDoneParams{
-			Id:   body.TaskId,
-			Done: body.Done,
+	}
+}
+
+// addRepeatStartAtAnchor fixes repeat start of task to its due date before the due date is moved,
+// since recurrence is anchored to the due date if repeat start is not set
+func addRepeatStartAtAnchor(updates *Updates, task Task) {
+	if task.RepeatStartAt == 0 && task.DueDate != 0 {
+		updates.add(OpUpdateTaskRepeatStartAt, &UpdateTaskRepeatStartAtParams{
+			Id:            task.Id,
+			RepeatStartAt: task.DueDate,
 		})
-		updates.add(OpUpdateTaskDoneAt, &UpdateTaskDoneAtParams{
-			Id:     body.TaskId,
-			DoneAt: body.DoneAt,
+	}
+}
+
+// addRescheduledFromReset clears the rescheduled occurrence of task, if any
+func addRescheduledFromReset(updates *Updates, task Task) {
+	if task.RescheduledFrom != 0 {
+		updates.add(OpUpdateTaskRescheduledFrom, &UpdateTaskRescheduledFromParams{
+			Id:              task.Id,
+			RescheduledFrom: 0,
 		})
 	}
+}
+
+// SkipTaskOccurrence moves repeating task to its next occurrence without completing the current one
+func SkipTaskOccurrence(state *State, tx *Transaction, body *TxSkipTaskOccurrenceBody, ctx *TxContext) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	task, ok := state.Tasks[body.TaskId]
+	if !ok {
+		log.Warnf("skipping occurrence task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+	if task.RepeatPeriod == "" || task.DueDate == 0 {
+		log.Warnf("skipping occurrence task(%s) is not repeating", body.TaskId)
+		return nil, ErrStateMismatch
+	}
 
+	// the occurrence after the current one, even if it is already past.
+	// task repeating after completion is skipped as if it were completed at the occurrence
+	var next int64
+	var err error
+	if task.RepeatMode == RepeatModeAfterCompletion {
+		next, ok, err = nextDueDateAfterCompletion(task, currentOccurrence(task), ctx.Location)
+	} else {
+		next, ok, err = nextOccurrence(task, currentOccurrence(task), ctx.Location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoNextOccurrence
+	}
+
+	occurrence := newOccurrence(task)
+	occurrence.Skipped = true
+	updates.add(OpAddTaskOccurrence, &AddTaskOccurrenceParams{
+		Id:         task.Id,
+		Occurrence: occurrence,
+	})
+
+	addRepeatStartAtAnchor(updates, task)
+	updates.add(OpUpdateTaskDueDate, &UpdateTaskDueDateParams{
+		Id:      task.Id,
+		DueDate: next,
+		AllDay:  task.AllDay,
+	})
+	addRescheduledFromReset(updates, task)
+	addSubtasksRollover(updates, task, next, true)
 	return updates, nil
 }
 
-func UpdateTaskRepeatPeriod(state *State, tx *Transaction) (*Updates, error) {
+// RescheduleTaskOccurrence moves due date of the current occurrence of repeating task only.
+// next occurrences are calculated from the original occurrence, not from the rescheduled due date.
+func RescheduleTaskOccurrence(state *State, tx *Transaction, body *TxRescheduleTaskOccurrenceBody, ctx *TxContext) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateTaskRepeatPeriodBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
+
+	task, ok := state.Tasks[body.TaskId]
+	if !ok {
+		log.Warnf("rescheduling occurrence task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+	if task.RepeatPeriod == "" || task.DueDate == 0 {
+		log.Warnf("rescheduling occurrence task(%s) is not repeating", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+	if err := validateDueDate(body.DueDate, task.AllDay); err != nil {
 		return nil, err
 	}
 
+	addRepeatStartAtAnchor(updates, task)
+	updates.add(OpUpdateTaskDueDate, &UpdateTaskDueDateParams{
+		Id:      task.Id,
+		DueDate: body.DueDate,
+		AllDay:  task.AllDay,
+	})
+	// original occurrence is kept when it is rescheduled again
+	if task.RescheduledFrom == 0 {
+		updates.add(OpUpdateTaskRescheduledFrom, &UpdateTaskRescheduledFromParams{
+			Id:              task.Id,
+			RescheduledFrom: task.DueDate,
+		})
+	}
+	addSubtasksRollover(updates, task, body.DueDate, false)
+	return updates, nil
+}
+
+// validateUpdateTaskRepeatPeriod checks RRULE of repeat period, empty one stops repeating
+func validateUpdateTaskRepeatPeriod(body *TxUpdateTaskRepeatPeriodBody) error {
+	if body.RepeatPeriod == "" {
+		return nil
+	}
+	_, err := ParseRepeatPeriod(body.RepeatPeriod)
+	return err
+}
+
+func UpdateTaskRepeatPeriod(state *State, tx *Transaction, body *TxUpdateTaskRepeatPeriodBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
 		log.Warnf("updating repeatPeriod task(%s) not found", body.TaskId)
@@ -562,12 +731,119 @@ func UpdateTaskRepeatPeriod(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func AddTaskCategory(state *State, tx *Transaction) (*Updates, error) {
+// FoldTaskClones folds done clones of repeating tasks, created on completion before the occurrence log, into their logs
+func FoldTaskClones(state *State, tx *Transaction, body *TxFoldTaskClonesBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxAddTaskCategoryBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
+
+	if body.TaskId != "" {
+		if _, ok := state.Tasks[body.TaskId]; !ok {
+			log.Warnf("folding clones task(%s) not found", body.TaskId)
+			return nil, ErrStateMismatch
+		}
+	}
+	if err := foldTaskClones(updates, state, body.TaskId); err != nil {
 		return nil, err
 	}
+	return updates, nil
+}
+
+func validateUpdateTaskRepeatMode(body *TxUpdateTaskRepeatModeBody) error {
+	return validateRepeatMode(body.RepeatMode)
+}
+
+func UpdateTaskRepeatMode(state *State, tx *Transaction, body *TxUpdateTaskRepeatModeBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	if _, ok := state.Tasks[body.TaskId]; !ok {
+		log.Warnf("updating repeatMode task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateTaskRepeatMode, &UpdateTaskRepeatModeParams{
+		Id:         body.TaskId,
+		RepeatMode: body.RepeatMode,
+	})
+	return updates, nil
+}
+
+func validateUpdateTaskReminders(body *TxUpdateTaskRemindersBody) error {
+	return validateReminders(body.Reminders)
+}
+
+func UpdateTaskReminders(state *State, tx *Transaction, body *TxUpdateTaskRemindersBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	if _, ok := state.Tasks[body.TaskId]; !ok {
+		log.Warnf("updating reminders task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateTaskReminders, &UpdateTaskRemindersParams{
+		Id:        body.TaskId,
+		Reminders: normalizeReminders(body.Reminders),
+	})
+	return updates, nil
+}
+
+func validateUpdateTaskPriority(body *TxUpdateTaskPriorityBody) error {
+	return validatePriority(body.Priority)
+}
+
+func UpdateTaskPriority(state *State, tx *Transaction, body *TxUpdateTaskPriorityBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	if _, ok := state.Tasks[body.TaskId]; !ok {
+		log.Warnf("updating priority task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateTaskPriority, &UpdateTaskPriorityParams{
+		Id:       body.TaskId,
+		Priority: body.Priority,
+	})
+	return updates, nil
+}
+
+func validateUpdateTaskTags(body *TxUpdateTaskTagsBody) error {
+	return validateTags(body.Tags)
+}
+
+func UpdateTaskTags(state *State, tx *Transaction, body *TxUpdateTaskTagsBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	if _, ok := state.Tasks[body.TaskId]; !ok {
+		log.Warnf("updating tags task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateTaskTags, &UpdateTaskTagsParams{
+		Id:   body.TaskId,
+		Tags: normalizeTags(body.Tags),
+	})
+	return updates, nil
+}
+
+func validateUpdateTaskEffort(body *TxUpdateTaskEffortBody) error {
+	return validateEffort(body.Effort)
+}
+
+func UpdateTaskEffort(state *State, tx *Transaction, body *TxUpdateTaskEffortBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	if _, ok := state.Tasks[body.TaskId]; !ok {
+		log.Warnf("updating effort task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateTaskEffort, &UpdateTaskEffortParams{
+		Id:     body.TaskId,
+		Effort: body.Effort,
+	})
+	return updates, nil
+}
+
+func AddTaskCategory(state *State, tx *Transaction, body *TxAddTaskCategoryBody) (*Updates, error) {
+	updates := NewUpdates(tx)
 
 	_, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -588,12 +864,8 @@ func AddTaskCategory(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func DeleteTaskCategory(state *State, tx *Transaction) (*Updates, error) {
+func DeleteTaskCategory(state *State, tx *Transaction, body *TxDeleteTaskCategoryBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxDeleteTaskCategoryBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -614,12 +886,8 @@ func DeleteTaskCategory(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func CreateSubtask(state *State, tx *Transaction) (*Updates, error) {
+func CreateSubtask(state *State, tx *Transaction, body *TxCreateSubtaskBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxCreateSubtaskBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	_, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -639,12 +907,8 @@ func CreateSubtask(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func DeleteSubtask(state *State, tx *Transaction) (*Updates, error) {
+func DeleteSubtask(state *State, tx *Transaction, body *TxDeleteSubtaskBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxDeleteSubtaskBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -665,12 +929,8 @@ func DeleteSubtask(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateSubtaskTitle(state *State, tx *Transaction) (*Updates, error) {
+func UpdateSubtaskTitle(state *State, tx *Transaction, body *TxUpdateSubtaskTitleBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateSubtaskTitleBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -692,12 +952,8 @@ func UpdateSubtaskTitle(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateSubtaskDueDate(state *State, tx *Transaction) (*Updates, error) {
+func UpdateSubtaskDueDate(state *State, tx *Transaction, body *TxUpdateSubtaskDueDateBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateSubtaskDueDateBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -719,12 +975,8 @@ func UpdateSubtaskDueDate(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateSubtaskDone(state *State, tx *Transaction) (*Updates, error) {
+func UpdateSubtaskDone(state *State, tx *Transaction, body *TxUpdateSubtaskDoneBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateSubtaskDoneBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	task, ok := state.Tasks[body.TaskId]
 	if !ok {
@@ -751,12 +1003,70 @@ func UpdateSubtaskDone(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func CreateCategory(state *State, tx *Transaction) (*Updates, error) {
+// UpdateSubtaskOrder moves subtask before or after target subtask.
+// without target, subtask moves to the last (or the first if AfterTarget).
+func UpdateSubtaskOrder(state *State, tx *Transaction, body *TxUpdateSubtaskOrderBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxCreateCategoryBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
+
+	task, ok := state.Tasks[body.TaskId]
+	if !ok {
+		log.Warnf("updating subtask order task(%s) not found", body.TaskId)
+		return nil, ErrStateMismatch
+	}
+	if _, ok := task.Subtasks[body.SubtaskId]; !ok {
+		log.Warnf("updating subtask order subtask(%s) not found", body.SubtaskId)
+		return nil, ErrStateMismatch
+	}
+	if body.TargetSubtaskId == body.SubtaskId {
+		log.Warnf("updating subtask order target is the subtask itself(%s)", body.SubtaskId)
+		return nil, ErrStateMismatch
 	}
+	if _, ok := task.Subtasks[body.TargetSubtaskId]; body.TargetSubtaskId != "" && !ok {
+		log.Warnf("updating subtask order target subtask(%s) not found", body.TargetSubtaskId)
+		return nil, ErrStateMismatch
+	}
+
+	order := make([]string, 0, len(task.Subtasks))
+	for _, sid := range task.SortedSubtaskIds() {
+		if sid != body.SubtaskId {
+			order = append(order, sid)
+		}
+	}
+
+	index := len(order)
+	if body.AfterTarget {
+		index = 0
+	}
+	for i, sid := range order {
+		if sid == body.TargetSubtaskId {
+			index = i
+			if body.AfterTarget {
+				index++
+			}
+			break
+		}
+	}
+	order = append(order[:index], append([]string{body.SubtaskId}, order[index:]...)...)
+
+	updates.add(OpUpdateSubtaskOrder, &UpdateSubtaskOrderParams{
+		Id:    body.TaskId,
+		Order: order,
+	})
+	return updates, nil
+}
+
+func validateCreateCategory(body *TxCreateCategoryBody) error {
+	if body.KeyId == "" {
+		return nil
+	}
+	if !body.Secret {
+		return fmt.Errorf("only secret category can be encrypted")
+	}
+	return validateKeyId(body.KeyId)
+}
+
+func CreateCategory(state *State, tx *Transaction, body *TxCreateCategoryBody) (*Updates, error) {
+	updates := NewUpdates(tx)
 
 	updates.add(OpCreateCategory, &CreateCategoryParams{
 		Id:        body.Id,
@@ -765,29 +1075,77 @@ func CreateCategory(state *State, tx *Transaction) (*Updates, error) {
 		Locked:    body.Locked,
 		Color:     body.Color,
 		CreatedAt: body.CreatedAt,
+		KeyId:     body.KeyId,
 	})
 	return updates, nil
 }
 
-func DeleteCategory(state *State, tx *Transaction) (*Updates, error) {
-	updates := NewUpdates(tx)
-	var body TxDeleteCategoryBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
+const (
+	CategoryDeleteModeRefuse   = ""         // category used by tasks is not deleted
+	CategoryDeleteModeCascade  = "cascade"  // category is removed from tasks
+	CategoryDeleteModeReassign = "reassign" // tasks move to the replacement category
+)
+
+func validateDeleteCategory(body *TxDeleteCategoryBody) error {
+	switch body.Mode {
+	case CategoryDeleteModeRefuse, CategoryDeleteModeCascade:
+		if body.ReplacementId != "" {
+			return fmt.Errorf("replacement category is only for %s mode", CategoryDeleteModeReassign)
+		}
+	case CategoryDeleteModeReassign:
+		if body.ReplacementId == "" {
+			return fmt.Errorf("replacement category is required for %s mode", CategoryDeleteModeReassign)
+		}
+		if body.ReplacementId == body.Id {
+			return fmt.Errorf("replacement category is the deleted category")
+		}
+	default:
+		return fmt.Errorf("invalid category delete mode: %q", body.Mode)
 	}
+	return nil
+}
+
+func DeleteCategory(state *State, tx *Transaction, body *TxDeleteCategoryBody) (*Updates, error) {
+	updates := NewUpdates(tx)
 
-	// if tasks that contains category exists, return error
-	alreadyUsing := 0
+	// tasks that contains category, sorted to make transitions deterministic
+	usingTaskIds := make([]string, 0)
 	for _, task := range state.Tasks {
-		for categoryId, _ := range task.Categories {
-			if categoryId == body.Id {
-				alreadyUsing++
-				break
-			}
+		if task.Categories[body.Id] {
+			usingTaskIds = append(usingTaskIds, task.Id)
 		}
 	}
-	if alreadyUsing > 0 {
-		return nil, fmt.Errorf("category is already used by %d tasks", alreadyUsing)
+	sort.Strings(usingTaskIds)
+
+	switch body.Mode {
+	case CategoryDeleteModeRefuse:
+		if len(usingTaskIds) > 0 {
+			return nil, fmt.Errorf("category is already used by %d tasks", len(usingTaskIds))
+		}
+	case CategoryDeleteModeCascade, CategoryDeleteModeReassign:
+		if _, ok := state.Categories[body.Id]; !ok {
+			log.Warnf("deleting category category(%s) not found", body.Id)
+			return nil, ErrStateMismatch
+		}
+		if body.Mode == CategoryDeleteModeReassign {
+			if _, ok := state.Categories[body.ReplacementId]; !ok {
+				log.Warnf("deleting category replacement category(%s) not found", body.ReplacementId)
+				return nil, ErrStateMismatch
+			}
+		}
+
+		for _, taskId := range usingTaskIds {
+			updates.add(OpDeleteTaskCategory, &DeleteTaskCategoryParams{
+				Id:         taskId,
+				CategoryId: body.Id,
+			})
+			if body.Mode == CategoryDeleteModeReassign && !state.Tasks[taskId].Categories[body.ReplacementId] {
+				updates.add(OpCreateTaskCategory, &CreateTaskCategoryParams{
+					Id:         taskId,
+					CategoryId: body.ReplacementId,
+				})
+			}
+		}
 	}
 
 	updates.add(OpDeleteCategory, &DeleteCategoryParams{
@@ -796,12 +1154,8 @@ func DeleteCategory(state *State, tx *Transaction) (*Updates, error) {
 	return updates, nil
 }
 
-func UpdateCategoryColor(state *State, tx *Transaction) (*Updates, error) {
+func UpdateCategoryColor(state *State, tx *Transaction, body *TxUpdateCategoryColorBody) (*Updates, error) {
 	updates := NewUpdates(tx)
-	var body TxUpdateCategoryColorBody
-	if err := util.InterfaceToStruct(tx.Content, &body); err != nil {
-		return nil, err
-	}
 
 	_, ok := state.Categories[body.Id]
 	if !ok {
@@ -815,3 +1169,177 @@ func UpdateCategoryColor(state *State, tx *Transaction) (*Updates, error) {
 	})
 	return updates, nil
 }
+
+func UpdateCategoryTitle(state *State, tx *Transaction, body *TxUpdateCategoryTitleBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	_, ok := state.Categories[body.Id]
+	if !ok {
+		log.Warnf("updating category title category(%s) not found", body.Id)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateCategoryTitle, &UpdateCategoryTitleParams{
+		Id:    body.Id,
+		Title: body.Title,
+	})
+	return updates, nil
+}
+
+func UpdateCategorySecret(state *State, tx *Transaction, body *TxUpdateCategorySecretBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	_, ok := state.Categories[body.Id]
+	if !ok {
+		log.Warnf("updating category secret category(%s) not found", body.Id)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateCategorySecret, &UpdateCategorySecretParams{
+		Id:     body.Id,
+		Secret: body.Secret,
+	})
+	return updates, nil
+}
+
+func UpdateCategoryLocked(state *State, tx *Transaction, body *TxUpdateCategoryLockedBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	_, ok := state.Categories[body.Id]
+	if !ok {
+		log.Warnf("updating category locked category(%s) not found", body.Id)
+		return nil, ErrStateMismatch
+	}
+
+	updates.add(OpUpdateCategoryLocked, &UpdateCategoryLockedParams{
+		Id:     body.Id,
+		Locked: body.Locked,
+	})
+	return updates, nil
+}
+
+func validateRotateCategoryKey(body *TxRotateCategoryKeyBody) error {
+	if body.KeyId != "" {
+		if err := validateKeyId(body.KeyId); err != nil {
+			return err
+		}
+	}
+	for taskId, content := range body.Contents {
+		if err := validateTags(content.Tags); err != nil {
+			return fmt.Errorf("content of task %s: %w", taskId, err)
+		}
+		texts := append([]string{content.Title, content.Memo}, content.Tags...)
+		for _, title := range content.Subtasks {
+			texts = append(texts, title)
+		}
+		for _, text := range texts {
+			var err error
+			if body.KeyId != "" {
+				err = validateEncryptedText(text, body.KeyId)
+			} else if IsEnvelope(text) {
+				err = fmt.Errorf("%w: category is decrypted", ErrInvalidEnvelope)
+			}
+			if err != nil {
+				return fmt.Errorf("content of task %s: %w", taskId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RotateCategoryKey replaces texts and tags of every task in category with ones re-wrapped by client with the new key.
+// category gets encrypted from plain, or decrypted with empty key.
+func RotateCategoryKey(state *State, tx *Transaction, body *TxRotateCategoryKeyBody) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	category, ok := state.Categories[body.Id]
+	if !ok {
+		log.Warnf("rotating category key category(%s) not found", body.Id)
+		return nil, ErrStateMismatch
+	}
+	if body.KeyId != "" && !category.Secret {
+		return nil, fmt.Errorf("only secret category can be encrypted")
+	}
+
+	// contents should be given for every task in category, no more
+	taskIds := make([]string, 0, len(body.Contents))
+	for _, task := range state.Tasks {
+		if task.Categories[body.Id] {
+			taskIds = append(taskIds, task.Id)
+		}
+	}
+	if len(taskIds) != len(body.Contents) {
+		log.Warnf("rotating category key %d contents given for %d tasks", len(body.Contents), len(taskIds))
+		return nil, ErrStateMismatch
+	}
+	sort.Strings(taskIds)
+
+	for _, taskId := range taskIds {
+		task := state.Tasks[taskId]
+		content, ok := body.Contents[taskId]
+		if !ok || len(content.Subtasks) != len(task.Subtasks) || len(content.Tags) != len(task.Tags) {
+			log.Warnf("rotating category key content of task(%s) not matched", taskId)
+			return nil, ErrStateMismatch
+		}
+
+		if content.Title != task.Title {
+			updates.add(OpUpdateTaskTitle, &UpdateTaskTitleParams{Id: taskId, Title: content.Title})
+		}
+		if content.Memo != task.Memo {
+			updates.add(OpUpdateTaskMemo, &UpdateTaskMemoParams{Id: taskId, Memo: content.Memo})
+		}
+		if !equalTags(content.Tags, task.Tags) {
+			updates.add(OpUpdateTaskTags, &UpdateTaskTagsParams{Id: taskId, Tags: normalizeTags(content.Tags)})
+		}
+		for _, sid := range task.SortedSubtaskIds() {
+			title, ok := content.Subtasks[sid]
+			if !ok {
+				log.Warnf("rotating category key content of subtask(%s) not found", sid)
+				return nil, ErrStateMismatch
+			}
+			if title != task.Subtasks[sid].Title {
+				updates.add(OpUpdateSubtaskTitle, &UpdateSubtaskTitleParams{Id: taskId, SubtaskId: sid, Title: title})
+			}
+		}
+	}
+
+	updates.add(OpUpdateCategoryKey, &UpdateCategoryKeyParams{
+		Id:    body.Id,
+		KeyId: body.KeyId,
+	})
+	return updates, nil
+}
+
+func validateBatch(body *TxBatchBody) error {
+	if len(body.Transactions) == 0 {
+		return fmt.Errorf("empty batch transaction")
+	}
+	for i, item := range body.Transactions {
+		if item.Type == TxBatch || item.Type == TxInitialize {
+			return fmt.Errorf("batch transaction #%d: type %d is not allowed in batch", i, item.Type)
+		}
+	}
+	return nil
+}
+
+// Batch pre-executes transactions in sequence on a scratch state,
+// all of their transitions are committed as a single block or not at all.
+func Batch(state *State, tx *Transaction, body *TxBatchBody, ctx *TxContext) (*Updates, error) {
+	updates := NewUpdates(tx)
+
+	scratch := state
+	for i, item := range body.Transactions {
+		subTx := NewTransaction(tx.Version, tx.From, item.Type, tx.Timestamp, item.Content, "")
+		subUpdates, err := preExecuteTransaction(scratch, subTx, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("batch transaction #%d: %w", i, err)
+		}
+		scratch, err = subUpdates.ApplyTransitions(scratch)
+		if err != nil {
+			return nil, fmt.Errorf("batch transaction #%d: %w", i, err)
+		}
+		updates.Transitions = append(updates.Transitions, subUpdates.Transitions...)
+	}
+
+	return updates, nil
+}
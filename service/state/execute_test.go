@@ -0,0 +1,233 @@
+package state
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func batchTestState() *State {
+	state := NewState()
+	state.Categories["c1"] = Category{Id: "c1"}
+	state.Tasks["t1"] = Task{Id: "t1", Next: "t2", Subtasks: map[string]Subtask{}, Categories: map[string]bool{}}
+	state.Tasks["t2"] = Task{Id: "t2", Subtasks: map[string]Subtask{}, Categories: map[string]bool{}}
+	return state
+}
+
+func TestBatch(t *testing.T) {
+	state := batchTestState()
+	tx := NewTransaction(SchemeVersion, "uid", TxBatch, 0, map[string]interface{}{
+		"transactions": []interface{}{
+			map[string]interface{}{"type": TxAddTaskCategory, "content": map[string]interface{}{"tid": "t1", "cid": "c1"}},
+			map[string]interface{}{"type": TxAddTaskCategory, "content": map[string]interface{}{"tid": "t2", "cid": "c1"}},
+		},
+	}, "")
+
+	updates, err := PreExecuteTransaction(state, tx, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates.Transitions) != 2 {
+		t.Fatalf("expected 2 transitions, got %d", len(updates.Transitions))
+	}
+	newState, err := updates.ApplyTransitions(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newState.Tasks["t1"].Categories["c1"] || !newState.Tasks["t2"].Categories["c1"] {
+		t.Error("expected both tasks to have category c1")
+	}
+}
+
+func TestBatch_FailsAtomically(t *testing.T) {
+	state := batchTestState()
+	tx := NewTransaction(SchemeVersion, "uid", TxBatch, 0, map[string]interface{}{
+		"transactions": []interface{}{
+			map[string]interface{}{"type": TxAddTaskCategory, "content": map[string]interface{}{"tid": "t1", "cid": "c1"}},
+			map[string]interface{}{"type": TxAddTaskCategory, "content": map[string]interface{}{"tid": "unknown", "cid": "c1"}},
+		},
+	}, "")
+
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if state.Tasks["t1"].Categories["c1"] {
+		t.Error("state should not be changed by failed batch")
+	}
+}
+
+func TestUpdateSubtaskOrder(t *testing.T) {
+	state := NewState()
+	state.Tasks["t1"] = Task{
+		Id: "t1",
+		Subtasks: map[string]Subtask{
+			"s1": {Id: "s1", CreatedAt: 1},
+			"s2": {Id: "s2", CreatedAt: 2},
+			"s3": {Id: "s3", CreatedAt: 3},
+		},
+		Categories: map[string]bool{},
+	}
+	expectOrder := func(state *State, expected ...string) {
+		t.Helper()
+		if order := state.Tasks["t1"].SubtaskOrder; !reflect.DeepEqual(order, expected) {
+			t.Errorf("expected order %v, got %v", expected, order)
+		}
+		if err := state.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// subtasks never reordered are in order of creation
+	state = executeTx(t, state, TxUpdateSubtaskOrder, map[string]interface{}{"tid": "t1", "sid": "s3", "targetSubtaskId": "s1"})
+	expectOrder(state, "s3", "s1", "s2")
+	state = executeTx(t, state, TxUpdateSubtaskOrder, map[string]interface{}{"tid": "t1", "sid": "s3", "targetSubtaskId": "s1", "afterTarget": true})
+	expectOrder(state, "s1", "s3", "s2")
+	state = executeTx(t, state, TxUpdateSubtaskOrder, map[string]interface{}{"tid": "t1", "sid": "s2", "afterTarget": true})
+	expectOrder(state, "s2", "s1", "s3")
+
+	// created subtask goes last, deleted one is removed
+	state = executeTx(t, state, TxCreateSubtask, map[string]interface{}{"tid": "t1", "sid": "s4"})
+	expectOrder(state, "s2", "s1", "s3", "s4")
+	deleteTx := NewTransaction(SchemeVersion, "uid", TxDeleteSubtask, 0, map[string]interface{}{"tid": "t1", "sid": "s1"}, "")
+	updates, err := PreExecuteTransaction(state, deleteTx, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := updates.ApplyTransitions(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOrder(deleted, "s2", "s3", "s4")
+
+	// reverting deletion puts subtask back in place
+	inverse, err := updates.Transitions.Inverse(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := NewUpdatesWithTransitions(nil, inverse).ApplyTransitions(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOrder(reverted, "s2", "s1", "s3", "s4")
+
+	// clone keeps order with new subtask ids
+	task := state.Tasks["t1"]
+	clone := task.CopyNew()
+	for i, sid := range clone.SubtaskOrder {
+		if clone.Subtasks[sid].CreatedAt != task.Subtasks[task.SubtaskOrder[i]].CreatedAt {
+			t.Errorf("expected clone to keep subtask order, got %v", clone.SubtaskOrder)
+		}
+	}
+
+	task.SubtaskOrder = []string{"s1", "s1", "s2", "s3"}
+	state.Tasks["t1"] = task
+	if err := state.Validate(); err == nil {
+		t.Errorf("expected inconsistent subtask order to be invalid")
+	}
+}
+
+func TestUpdateTaskAttributes(t *testing.T) {
+	state := executeTx(t, NewState(), TxCreateTask, map[string]interface{}{
+		"tid": "t1", "title": "task", "priority": PriorityLow, "tags": []string{"home"}, "effort": 15,
+	})
+	state = executeTx(t, state, TxUpdateTaskPriority, map[string]interface{}{"tid": "t1", "priority": PriorityUrgent})
+	state = executeTx(t, state, TxUpdateTaskTags, map[string]interface{}{"tid": "t1", "tags": []string{"work", "read"}})
+	state = executeTx(t, state, TxUpdateTaskEffort, map[string]interface{}{"tid": "t1", "effort": 90})
+	task := state.Tasks["t1"]
+	if task.Priority != PriorityUrgent || !reflect.DeepEqual(task.Tags, []string{"work", "read"}) || task.Effort != 90 {
+		t.Errorf("unexpected attributes: %+v", task)
+	}
+	if copied := task.CopyNew(); copied.Priority != task.Priority || !reflect.DeepEqual(copied.Tags, task.Tags) || copied.Effort != task.Effort {
+		t.Errorf("expected attributes copied, got %+v", copied)
+	}
+
+	invalid := map[int64]map[string]interface{}{
+		TxUpdateTaskPriority: {"tid": "t1", "priority": "highest"},
+		TxUpdateTaskTags:     {"tid": "t1", "tags": []string{"a", "a"}},
+		TxUpdateTaskEffort:   {"tid": "t1", "effort": -1},
+		TxCreateTask:         {"tid": "t2", "tags": []string{strings.Repeat("x", MaxTagLength+1)}},
+	}
+	for txType, content := range invalid {
+		tx := NewTransaction(SchemeVersion, "uid", txType, 0, content, "")
+		if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+			t.Errorf("tx %d: expected invalid attributes to fail", txType)
+		}
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	state := NewState()
+	state.Categories["c1"] = Category{Id: "c1", Title: "before", Color: "red"}
+
+	state = executeTx(t, state, TxUpdateCategoryTitle, map[string]interface{}{"cid": "c1", "title": "after"})
+	state = executeTx(t, state, TxUpdateCategorySecret, map[string]interface{}{"cid": "c1", "secret": true})
+	state = executeTx(t, state, TxUpdateCategoryLocked, map[string]interface{}{"cid": "c1", "locked": true})
+	expected := Category{Id: "c1", Title: "after", Secret: true, Locked: true, Color: "red"}
+	if category := state.Categories["c1"]; category != expected {
+		t.Errorf("expected %+v, got %+v", expected, category)
+	}
+
+	for _, txType := range []int64{TxUpdateCategoryTitle, TxUpdateCategorySecret, TxUpdateCategoryLocked} {
+		tx := NewTransaction(SchemeVersion, "uid", txType, 0, map[string]interface{}{"cid": "c2"}, "")
+		if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); !errors.Is(err, ErrStateMismatch) {
+			t.Errorf("tx %d: expected state mismatch for missing category, got %v", txType, err)
+		}
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	prevState := NewState()
+	prevState.Categories["c1"] = Category{Id: "c1"}
+	prevState.Categories["c2"] = Category{Id: "c2"}
+	prevState.Tasks["t1"] = Task{Id: "t1", Next: "t2", Subtasks: map[string]Subtask{}, Categories: map[string]bool{"c1": true}}
+	prevState.Tasks["t2"] = Task{Id: "t2", Subtasks: map[string]Subtask{}, Categories: map[string]bool{"c1": true, "c2": true}}
+
+	for _, content := range []map[string]interface{}{
+		{"cid": "c1"},
+		{"cid": "c1", "mode": CategoryDeleteModeReassign},
+		{"cid": "c1", "mode": CategoryDeleteModeReassign, "replacementCid": "c3"},
+		{"cid": "c1", "mode": "unknown"},
+	} {
+		tx := NewTransaction(SchemeVersion, "uid", TxDeleteCategory, 0, content, "")
+		if _, err := PreExecuteTransaction(prevState, tx, 1, nil, nil); err == nil {
+			t.Errorf("%v: expected deletion to fail", content)
+		}
+	}
+
+	cascaded := executeTx(t, prevState.Copy(), TxDeleteCategory, map[string]interface{}{"cid": "c1", "mode": CategoryDeleteModeCascade})
+	if len(cascaded.Tasks["t1"].Categories) != 0 || !reflect.DeepEqual(cascaded.Tasks["t2"].Categories, map[string]bool{"c2": true}) {
+		t.Errorf("expected category removed from tasks, got %+v", cascaded.Tasks)
+	}
+
+	tx := NewTransaction(SchemeVersion, "uid", TxDeleteCategory, 0, map[string]interface{}{"cid": "c1", "mode": CategoryDeleteModeReassign, "replacementCid": "c2"}, "")
+	updates, err := PreExecuteTransaction(prevState, tx, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reassigned, err := updates.ApplyTransitions(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reassigned.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, taskId := range []string{"t1", "t2"} {
+		if categories := reassigned.Tasks[taskId].Categories; !reflect.DeepEqual(categories, map[string]bool{"c2": true}) {
+			t.Errorf("%s: expected task moved to replacement category, got %v", taskId, categories)
+		}
+	}
+
+	// reverting the block restores category and tasks at once
+	inverse, err := updates.Transitions.Inverse(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := NewUpdatesWithTransitions(nil, inverse).ApplyTransitions(reassigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reverted, prevState) {
+		t.Errorf("expected %+v, got %+v", prevState, reverted)
+	}
+}
@@ -0,0 +1,61 @@
+package state
+
+import "fmt"
+
+type HashPolicy string
+
+const (
+	HashPolicyOff    HashPolicy = "off"    // hashes are not checked
+	HashPolicyWarn   HashPolicy = "warn"   // mismatches are logged only
+	HashPolicyReject HashPolicy = "reject" // mismatches reject the transaction
+)
+
+const (
+	HashKindTx    = "tx"
+	HashKindBlock = "block"
+)
+
+// TxHashPolicy decides how transaction & expected block hashes from clients are checked
+var TxHashPolicy = HashPolicyOff
+
+func ParseHashPolicy(str string) (HashPolicy, error) {
+	switch policy := HashPolicy(str); policy {
+	case HashPolicyOff, HashPolicyWarn, HashPolicyReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid hash policy: %s", str)
+	}
+}
+
+// HashMismatchError is returned when hash given by client differs from the one calculated by server
+type HashMismatchError struct {
+	Kind     string `json:"kind"`
+	Expected string `json:"expected"`
+	Given    string `json:"given"`
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("invalid %s hash: expected %s, but %s given", e.Kind, e.Expected, e.Given)
+}
+
+// CheckTransactionHash checks tx.Hash given by client with the canonical hash
+func CheckTransactionHash(tx *Transaction) *HashMismatchError {
+	expected := tx.CalcHash().Hex()
+	if tx.Hash != expected {
+		return &HashMismatchError{Kind: HashKindTx, Expected: expected, Given: tx.Hash}
+	}
+	return nil
+}
+
+// CheckBlockHash checks block hash expected by client for the block to be created with tx
+func CheckBlockHash(chain *Chain, tx *Transaction, blockNumber int64, blockHash string) (*HashMismatchError, error) {
+	prevBlock, err := chain.GetBlockByNumber(blockNumber - 1)
+	if err != nil {
+		return nil, err
+	}
+	expected := ExpectedBlockHash(blockNumber, tx.Hash, prevBlock.Hash).Hex()
+	if blockHash != expected {
+		return &HashMismatchError{Kind: HashKindBlock, Expected: expected, Given: blockHash}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,50 @@
+package state
+
+import "container/list"
+
+// lru keeps keys in order of recent use, the front is the most recently used
+type lru[K comparable] struct {
+	order    *list.List
+	elements map[K]*list.Element
+}
+
+func newLru[K comparable]() *lru[K] {
+	return &lru[K]{
+		order:    list.New(),
+		elements: make(map[K]*list.Element),
+	}
+}
+
+// Touch marks key as most recently used
+func (l *lru[K]) Touch(key K) {
+	if element, ok := l.elements[key]; ok {
+		l.order.MoveToFront(element)
+		return
+	}
+	l.elements[key] = l.order.PushFront(key)
+}
+
+func (l *lru[K]) Remove(key K) {
+	if element, ok := l.elements[key]; ok {
+		l.order.Remove(element)
+		delete(l.elements, key)
+	}
+}
+
+// Oldest returns keys from the least recently used
+func (l *lru[K]) Oldest() []K {
+	keys := make([]K, 0, l.order.Len())
+	for element := l.order.Back(); element != nil; element = element.Prev() {
+		keys = append(keys, element.Value.(K))
+	}
+	return keys
+}
+
+func (l *lru[K]) Len() int {
+	return l.order.Len()
+}
+
+func (l *lru[K]) Clear() {
+	l.order.Init()
+	l.elements = make(map[K]*list.Element)
+}
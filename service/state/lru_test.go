@@ -0,0 +1,37 @@
+package state
+
+import "testing"
+
+func TestLru_Oldest(t *testing.T) {
+	l := newLru[int64]()
+	l.Touch(1)
+	l.Touch(2)
+	l.Touch(3)
+	l.Touch(1)
+
+	oldest := l.Oldest()
+	expected := []int64{2, 3, 1}
+	if len(oldest) != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(oldest))
+	}
+	for i, key := range expected {
+		if oldest[i] != key {
+			t.Errorf("expected %d at %d, got %d", key, i, oldest[i])
+		}
+	}
+}
+
+func TestLru_Remove(t *testing.T) {
+	l := newLru[string]()
+	l.Touch("a")
+	l.Touch("b")
+	l.Remove("a")
+	l.Remove("unknown")
+
+	if l.Len() != 1 {
+		t.Fatalf("expected 1 key, got %d", l.Len())
+	}
+	if l.Oldest()[0] != "b" {
+		t.Errorf("expected b, got %s", l.Oldest()[0])
+	}
+}
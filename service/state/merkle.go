@@ -0,0 +1,241 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Merkle tree of state is built over leaves of tasks (with their subtasks) and categories, sorted by key.
+// leaf hash = sha256(0x00 || key || 0x00 || JSON of entity), node hash = sha256(0x01 || left || right).
+// like RFC 6962, the left subtree of n leaves has the largest power of 2 less than n leaves.
+// root of the empty state is sha256 of empty bytes.
+//
+// state root is not included in block hash (clients compute block hashes without it), so the chain doesn't vouch for it.
+// a proof shows a task is in the root reported by server, it's only as trustworthy as the server.
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+
+	merkleTaskKeyPrefix     = "task:"
+	merkleCategoryKeyPrefix = "category:"
+)
+
+type merkleLeaf struct {
+	Key  string
+	Hash Hash
+}
+
+// MerkleProofStep is a sibling hash on the path from leaf to root
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // whether sibling is on the left side
+}
+
+// MerkleProof proves inclusion of a leaf in state root
+type MerkleProof struct {
+	Key      string            `json:"key"`
+	LeafHash string            `json:"leafHash"`
+	Steps    []MerkleProofStep `json:"steps"`
+	Root     string            `json:"root"`
+}
+
+func merkleLeafHash(key string, value interface{}) (Hash, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return Hash{}, err
+	}
+	data := make([]byte, 0, len(key)+len(b)+2)
+	data = append(data, merkleLeafPrefix)
+	data = append(data, key...)
+	data = append(data, merkleLeafPrefix)
+	data = append(data, b...)
+	return sha256.Sum256(data), nil
+}
+
+func merkleNodeHash(left, right Hash) Hash {
+	data := make([]byte, 0, 1+2*len(left))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// merkleCache keeps leaf hashes of a state by key, so only tasks & categories changed by transitions are hashed again.
+// state is not changed in place once its leaves are cached, ApplyTransitions carries the cache to the new state.
+type merkleCache struct {
+	lock   sync.Mutex
+	hashes map[string]Hash
+}
+
+func newMerkleCache() *merkleCache {
+	return &merkleCache{hashes: make(map[string]Hash)}
+}
+
+// without returns new cache without leaves of keys
+func (c *merkleCache) without(keys map[string]bool) *merkleCache {
+	carried := newMerkleCache()
+	if c == nil {
+		return carried
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, hash := range c.hashes {
+		if !keys[key] {
+			carried.hashes[key] = hash
+		}
+	}
+	return carried
+}
+
+// merkleKey returns key of the leaf changed by transition, false if it can change any leaf (e.g. deleting all)
+func (t *Transition) merkleKey() (string, bool) {
+	var prefix string
+	switch {
+	case t.Operation >= OpCreateTask && t.Operation < OpCreateCategory:
+		// task, its categories and subtasks are in the leaf of task
+		prefix = merkleTaskKeyPrefix
+	case t.Operation >= OpCreateCategory && t.Operation < OpCreateCategory+100:
+		prefix = merkleCategoryKeyPrefix
+	default:
+		return "", false
+	}
+
+	params := t.Params
+	if spec, err := GetOpSpec(t.Operation); err == nil {
+		if decoded, err := spec.Decode(params); err == nil {
+			params = decoded
+		}
+	}
+	value := reflect.ValueOf(params)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return "", false
+	}
+	id := value.Elem().FieldByName("Id")
+	if !id.IsValid() || id.Kind() != reflect.String {
+		return "", false
+	}
+	return prefix + id.String(), true
+}
+
+// merkleLeaves returns leaves of tasks & categories sorted by key
+func (s *State) merkleLeaves() ([]merkleLeaf, error) {
+	cache := s.merkle
+	if cache == nil {
+		cache = newMerkleCache()
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	leaves := make([]merkleLeaf, 0, len(s.Tasks)+len(s.Categories))
+	add := func(key string, value interface{}) error {
+		hash, ok := cache.hashes[key]
+		if !ok {
+			var err error
+			if hash, err = merkleLeafHash(key, value); err != nil {
+				return err
+			}
+			cache.hashes[key] = hash
+		}
+		leaves = append(leaves, merkleLeaf{Key: key, Hash: hash})
+		return nil
+	}
+	for id, task := range s.Tasks {
+		if err := add(merkleTaskKeyPrefix+id, task); err != nil {
+			return nil, err
+		}
+	}
+	for id, category := range s.Categories {
+		if err := add(merkleCategoryKeyPrefix+id, category); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].Key < leaves[j].Key })
+	return leaves, nil
+}
+
+// splitPoint returns the largest power of 2 less than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleRoot(leaves []merkleLeaf) Hash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0].Hash
+	}
+	k := splitPoint(len(leaves))
+	return merkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath returns sibling hashes from leaf at index up to root
+func merklePath(leaves []merkleLeaf, index int) []MerkleProofStep {
+	if len(leaves) <= 1 {
+		return []MerkleProofStep{}
+	}
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(merklePath(leaves[:k], index), MerkleProofStep{Hash: merkleRoot(leaves[k:]).Hex(), Left: false})
+	}
+	return append(merklePath(leaves[k:], index-k), MerkleProofStep{Hash: merkleRoot(leaves[:k]).Hex(), Left: true})
+}
+
+// MerkleRoot returns the root hash of tasks, subtasks and categories
+func (s *State) MerkleRoot() (Hash, error) {
+	leaves, err := s.merkleLeaves()
+	if err != nil {
+		return Hash{}, err
+	}
+	return merkleRoot(leaves), nil
+}
+
+// TaskProof returns inclusion proof of task (with its subtasks) in the state root
+func (s *State) TaskProof(taskId string) (*MerkleProof, error) {
+	if _, ok := s.Tasks[taskId]; !ok {
+		return nil, ErrTaskNotFound
+	}
+	leaves, err := s.merkleLeaves()
+	if err != nil {
+		return nil, err
+	}
+
+	key := merkleTaskKeyPrefix + taskId
+	index := sort.Search(len(leaves), func(i int) bool { return leaves[i].Key >= key })
+	return &MerkleProof{
+		Key:      key,
+		LeafHash: leaves[index].Hash.Hex(),
+		Steps:    merklePath(leaves, index),
+		Root:     merkleRoot(leaves).Hex(),
+	}, nil
+}
+
+// VerifyTask checks that proof is of task and leads to root
+func (p *MerkleProof) VerifyTask(task Task, root string) bool {
+	leafHash, err := merkleLeafHash(merkleTaskKeyPrefix+task.Id, task)
+	if err != nil || leafHash.Hex() != p.LeafHash || p.Key != merkleTaskKeyPrefix+task.Id {
+		return false
+	}
+
+	hash := leafHash
+	for _, step := range p.Steps {
+		siblingHash, err := hexToHash(step.Hash)
+		if err != nil || len(step.Hash) != 2*len(siblingHash) {
+			return false
+		}
+		if step.Left {
+			hash = merkleNodeHash(siblingHash, hash)
+		} else {
+			hash = merkleNodeHash(hash, siblingHash)
+		}
+	}
+	return hash.Hex() == root
+}
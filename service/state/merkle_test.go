@@ -0,0 +1,110 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+func merkleTestState(taskCount int) *State {
+	state := NewState()
+	for i := 0; i < taskCount; i++ {
+		id := fmt.Sprintf("task-%d", i)
+		state.Tasks[id] = Task{
+			Id:         id,
+			Title:      fmt.Sprintf("title %d", i),
+			Subtasks:   map[string]Subtask{"s": {Id: "s", Title: "subtask"}},
+			Categories: map[string]bool{},
+		}
+	}
+	state.Categories["c"] = Category{Id: "c", Title: "category"}
+	return state
+}
+
+func TestMerkleRoot_CoversCategoriesAndSubtasks(t *testing.T) {
+	state := merkleTestState(3)
+	root := state.Hash()
+
+	withCategory := state.Copy()
+	category := withCategory.Categories["c"]
+	category.Color = "red"
+	withCategory.Categories["c"] = category
+	if withCategory.Hash() == root {
+		t.Errorf("expected root to change with category")
+	}
+
+	withSubtask := state.Copy()
+	withSubtask.Tasks["task-0"].Subtasks["s"] = Subtask{Id: "s", Title: "changed"}
+	if withSubtask.Hash() == root {
+		t.Errorf("expected root to change with subtask")
+	}
+
+	if state.Copy().Hash() != root {
+		t.Errorf("expected root to be deterministic")
+	}
+}
+
+func TestTaskProof(t *testing.T) {
+	// various sizes for unbalanced trees
+	for taskCount := 1; taskCount <= 9; taskCount++ {
+		state := merkleTestState(taskCount)
+		root := state.Hash().Hex()
+
+		for id, task := range state.Tasks {
+			proof, err := state.TaskProof(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.Root != root {
+				t.Errorf("%d tasks: expected proof root %s, got %s", taskCount, root, proof.Root)
+			}
+			if !proof.VerifyTask(task, root) {
+				t.Errorf("%d tasks: proof of %s is not verified", taskCount, id)
+			}
+
+			changed := task
+			changed.Title = "changed"
+			if proof.VerifyTask(changed, root) {
+				t.Errorf("%d tasks: proof of changed %s is verified", taskCount, id)
+			}
+		}
+	}
+
+	if _, err := NewState().TaskProof("unknown"); err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestMerkleRoot_CachedLeavesAfterTransitions(t *testing.T) {
+	state := merkleTestState(4)
+	state.Hash()
+
+	steps := []struct {
+		operation int64
+		params    interface{}
+	}{
+		{OpUpdateTaskTitle, &UpdateTaskTitleParams{Id: "task-1", Title: "changed"}},
+		{OpUpdateSubtaskTitle, &UpdateSubtaskTitleParams{Id: "task-2", SubtaskId: "s", Title: "changed"}},
+		{OpCreateTaskCategory, &CreateTaskCategoryParams{Id: "task-3", CategoryId: "c"}},
+		{OpUpdateCategoryColor, &UpdateCategoryColorParams{Id: "c", Color: "blue"}},
+		{OpDeleteTask, &DeleteTaskParams{Id: "task-0"}},
+		{OpDeleteAll, nil},
+	}
+	for _, step := range steps {
+		updates := NewUpdates(nil)
+		updates.add(step.operation, step.params)
+		newState, err := updates.ApplyTransitions(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.operation == OpUpdateTaskTitle {
+			if _, ok := newState.merkle.hashes[merkleTaskKeyPrefix+"task-2"]; !ok {
+				t.Errorf("expected leaf of unchanged task to be kept")
+			}
+		}
+		// a copy has no cached leaves
+		if newState.Hash() != newState.Copy().Hash() {
+			t.Errorf("operation %d: cached root differs from recalculated root", step.operation)
+		}
+		state = newState
+	}
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"memorial_app_server/service/database"
+)
+
+type MigrateReport struct {
+	UserId               string `json:"userId"`
+	MigratedTransactions int64  `json:"migratedTransactions"`
+	MigratedStates       int64  `json:"migratedStates"`
+	RehashedBlocks       int64  `json:"rehashedBlocks"`
+}
+
+var ErrBrokenHistory = errors.New("history can't be migrated, verify the chain")
+
+// checkMigratableBlock checks if block can be rewritten right after block of prevNumber
+func checkMigratableBlock(blockEntity database.BlockEntity, prevNumber int64) error {
+	if blockEntity.Number == nil || blockEntity.TxHash == nil {
+		return fmt.Errorf("%w: block after #%d has null number or tx hash", ErrBrokenHistory, prevNumber)
+	}
+	if *blockEntity.Number != prevNumber+1 {
+		return fmt.Errorf("%w: block #%d is missing", ErrBrokenHistory, prevNumber+1)
+	}
+	return nil
+}
+
+// MigrateHistory rewrites stored transactions and states of user to SchemeVersion in a database transaction.
+// Hashes of upcasted transactions change, so block hashes are recalculated from the first changed block.
+// Transitions are kept as they are, broken history (null or missing blocks) fails the migration. It should be run while the server is stopped (chains are not reloaded).
+func MigrateHistory(userId string) (*MigrateReport, error) {
+	report := &MigrateReport{UserId: userId}
+
+	ctx, err := database.DB.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = ctx.Rollback() }()
+
+	var blockEntities []database.BlockEntity
+	if err := ctx.Select(&blockEntities, "SELECT * FROM blocks WHERE uid = ? ORDER BY block_number", userId); err != nil {
+		return nil, err
+	}
+
+	prevBlockHash := InitialBlock().Hash
+	prevNumber := int64(0)
+	for _, blockEntity := range blockEntities {
+		// skipping a block would link the next one to the wrong predecessor
+		if err := checkMigratableBlock(blockEntity, prevNumber); err != nil {
+			return nil, fmt.Errorf("user %s: %w", userId, err)
+		}
+		number := *blockEntity.Number
+		prevNumber = number
+
+		var txEntity database.TransactionEntity
+		if err := ctx.Get(&txEntity, "SELECT * FROM transactions WHERE `from` = ? AND hash = ?", userId, *blockEntity.TxHash); err != nil {
+			return nil, err
+		}
+		tx, err := TransactionFromEntity(txEntity)
+		if err != nil {
+			return nil, err
+		}
+		storedVersion := tx.Version
+
+		// transaction is re-inserted with the new hash, blocks reference it by hash
+		if tx.Version < SchemeVersion {
+			upcastedTx, err := UpcastTransaction(tx)
+			if err != nil {
+				return nil, err
+			}
+			upcastedTx.Hash = upcastedTx.CalcHash().Hex()
+
+			var marshaledContent []byte
+			if upcastedTx.Content != nil {
+				if marshaledContent, err = json.Marshal(upcastedTx.Content); err != nil {
+					return nil, err
+				}
+			}
+			_, err = ctx.Exec(
+				"INSERT INTO transactions (version, type, `from`, timestamp, content, hash) VALUES (?, ?, ?, ?, ?, ?)",
+				upcastedTx.Version, upcastedTx.Type, upcastedTx.From, upcastedTx.Timestamp, marshaledContent, upcastedTx.Hash,
+			)
+			if err != nil {
+				return nil, err
+			}
+			tx = upcastedTx
+			report.MigratedTransactions++
+		}
+
+		state := blockEntity.State
+		if state != nil && storedVersion < SchemeVersion {
+			if state, err = UpcastState(state, storedVersion); err != nil {
+				return nil, err
+			}
+			report.MigratedStates++
+		}
+
+		blockHash := ExpectedBlockHash(number, tx.Hash, prevBlockHash).Hex()
+		storedPrevBlockHash := ""
+		if blockEntity.PrevBlockHash != nil {
+			storedPrevBlockHash = *blockEntity.PrevBlockHash
+		}
+		changed := tx.Hash != *blockEntity.TxHash || storedPrevBlockHash != prevBlockHash ||
+			blockEntity.BlockHash == nil || *blockEntity.BlockHash != blockHash
+		if changed || storedVersion < SchemeVersion {
+			// state root of upcasted state is recalculated on load
+			stateRoot := blockEntity.StateRoot
+			if storedVersion < SchemeVersion {
+				stateRoot = nil
+			}
+			_, err = ctx.Exec(
+				"UPDATE blocks SET tx_hash = ?, block_hash = ?, prev_block_hash = ?, state = ?, state_root = ? WHERE uid = ? AND block_number = ?",
+				tx.Hash, blockHash, prevBlockHash, state, stateRoot, userId, number,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				report.RehashedBlocks++
+			}
+		}
+		if tx.Hash != *blockEntity.TxHash {
+			if _, err := ctx.Exec("DELETE FROM transactions WHERE txid = ?", *txEntity.TxId); err != nil {
+				return nil, err
+			}
+		}
+
+		prevBlockHash = blockHash
+	}
+
+	if err := ctx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"memorial_app_server/util"
+)
+
+// SchemeVersionWindow is the number of older scheme versions accepted from clients.
+// transactions of those versions are upcasted to SchemeVersion before execution.
+var SchemeVersionWindow = 0
+
+// Migration upcasts transaction contents and states from scheme version From to From+1.
+// nil upcaster keeps the value as it is.
+type Migration struct {
+	From      int
+	TxContent func(txType int64, content interface{}) (interface{}, error)
+	State     func(state map[string]interface{}) (map[string]interface{}, error)
+}
+
+var migrations = map[int]*Migration{}
+
+// registerMigration registers upcaster of scheme version m.From
+func registerMigration(m *Migration) {
+	if _, exists := migrations[m.From]; exists {
+		panic(fmt.Sprintf("migration from scheme version %d is already registered", m.From))
+	}
+	migrations[m.From] = m
+}
+
+// UnsupportedSchemeVersionError is returned for transactions out of the supported window
+type UnsupportedSchemeVersionError struct {
+	Version    int `json:"version"`
+	MinVersion int `json:"minVersion"`
+	MaxVersion int `json:"maxVersion"`
+}
+
+func (e *UnsupportedSchemeVersionError) Error() string {
+	return fmt.Sprintf("unsupported scheme version %d: supported versions are %d ~ %d", e.Version, e.MinVersion, e.MaxVersion)
+}
+
+// CheckSchemeVersion checks if transactions of version are accepted from clients
+func CheckSchemeVersion(version int) error {
+	minVersion := SchemeVersion - SchemeVersionWindow
+	if version < minVersion || version > SchemeVersion {
+		return &UnsupportedSchemeVersionError{
+			Version:    version,
+			MinVersion: minVersion,
+			MaxVersion: SchemeVersion,
+		}
+	}
+	return nil
+}
+
+// UpcastTransaction returns copy of tx whose content is upcasted to SchemeVersion.
+// hash is kept as it is, since it identifies the original transaction.
+func UpcastTransaction(tx *Transaction) (*Transaction, error) {
+	if tx.Version > SchemeVersion {
+		return nil, &UnsupportedSchemeVersionError{Version: tx.Version, MaxVersion: SchemeVersion}
+	}
+	if tx.Version == SchemeVersion {
+		return tx, nil
+	}
+
+	content := tx.Content
+	for version := tx.Version; version < SchemeVersion; version++ {
+		m, ok := migrations[version]
+		if !ok {
+			continue
+		}
+		var err error
+		content, err = upcastContent(m, tx.Type, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upcast transaction %s from scheme version %d: %w", tx.Hash, version, err)
+		}
+	}
+
+	return NewTransaction(SchemeVersion, tx.From, tx.Type, tx.Timestamp, content, tx.Hash), nil
+}
+
+func upcastContent(m *Migration, txType int64, content interface{}) (interface{}, error) {
+	if m.TxContent == nil {
+		return content, nil
+	}
+	content, err := m.TxContent(txType, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// contents of batched transactions are upcasted one by one
+	if txType == TxBatch {
+		var body TxBatchBody
+		if err := util.InterfaceToStruct(content, &body); err != nil {
+			return nil, err
+		}
+		for i, item := range body.Transactions {
+			body.Transactions[i].Content, err = upcastContent(m, item.Type, item.Content)
+			if err != nil {
+				return nil, fmt.Errorf("batch transaction #%d: %w", i, err)
+			}
+		}
+		content = &body
+	}
+	return content, nil
+}
+
+// UpcastState upcasts marshaled state of version to SchemeVersion
+func UpcastState(b []byte, version int) ([]byte, error) {
+	if version >= SchemeVersion {
+		return b, nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	for ; version < SchemeVersion; version++ {
+		m, ok := migrations[version]
+		if !ok || m.State == nil {
+			continue
+		}
+		var err error
+		raw, err = m.State(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upcast state from scheme version %d: %w", version, err)
+		}
+	}
+	return json.Marshal(raw)
+}
+
+// stateFromStored decodes state stored with a block, whose scheme version is of its transaction
+func stateFromStored(b []byte, version int) (*State, error) {
+	upcasted, err := UpcastState(b, version)
+	if err != nil {
+		return nil, err
+	}
+	state := NewState()
+	if err := state.FromBytes(upcasted); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"errors"
+	"memorial_app_server/service/database"
+	"testing"
+)
+
+// withMigrations replaces scheme version and migrations during a test
+func withMigrations(t *testing.T, schemeVersion int, registered ...*Migration) {
+	prevSchemeVersion, prevMigrations := SchemeVersion, migrations
+	t.Cleanup(func() {
+		SchemeVersion, migrations = prevSchemeVersion, prevMigrations
+	})
+
+	SchemeVersion = schemeVersion
+	migrations = map[int]*Migration{}
+	for _, m := range registered {
+		registerMigration(m)
+	}
+}
+
+// renameTitle renames "name" of v1 contents & categories to "title"
+var renameTitle = &Migration{
+	From: 1,
+	TxContent: func(txType int64, content interface{}) (interface{}, error) {
+		body, ok := content.(map[string]interface{})
+		if !ok || txType != TxCreateCategory {
+			return content, nil
+		}
+		upcasted := map[string]interface{}{}
+		for key, value := range body {
+			upcasted[key] = value
+		}
+		upcasted["title"] = body["name"]
+		delete(upcasted, "name")
+		return upcasted, nil
+	},
+	State: func(state map[string]interface{}) (map[string]interface{}, error) {
+		categories, _ := state["categories"].(map[string]interface{})
+		for _, raw := range categories {
+			category := raw.(map[string]interface{})
+			category["title"] = category["name"]
+			delete(category, "name")
+		}
+		return state, nil
+	},
+}
+
+func TestUpcastTransaction(t *testing.T) {
+	withMigrations(t, 2, renameTitle)
+
+	tx := NewTransaction(1, "uid", TxCreateCategory, 0, map[string]interface{}{"cid": "c1", "name": "work"}, "hash")
+	updates, err := PreExecuteTransaction(NewState(), tx, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := updates.ApplyTransitions(NewState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := state.Categories["c1"].Title; title != "work" {
+		t.Errorf("expected upcasted title, got %q", title)
+	}
+	if updates.SrcTx != tx {
+		t.Errorf("expected block to refer to the original transaction")
+	}
+}
+
+func TestUpcastTransaction_Batch(t *testing.T) {
+	withMigrations(t, 2, renameTitle)
+
+	tx := NewTransaction(1, "uid", TxBatch, 0, map[string]interface{}{
+		"transactions": []interface{}{
+			map[string]interface{}{"type": TxCreateCategory, "content": map[string]interface{}{"cid": "c1", "name": "work"}},
+		},
+	}, "hash")
+	upcasted, err := UpcastTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := upcasted.Content.(*TxBatchBody)
+	if title := body.Transactions[0].Content.(map[string]interface{})["title"]; title != "work" {
+		t.Errorf("expected batched content to be upcasted, got %v", body.Transactions[0].Content)
+	}
+}
+
+func TestUpcastState(t *testing.T) {
+	withMigrations(t, 2, renameTitle)
+
+	state, err := stateFromStored([]byte(`{"tasks":{},"categories":{"c1":{"id":"c1","name":"work"}}}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := state.Categories["c1"].Title; title != "work" {
+		t.Errorf("expected upcasted title, got %q", title)
+	}
+}
+
+func TestCheckSchemeVersion(t *testing.T) {
+	withMigrations(t, 3)
+	prevWindow := SchemeVersionWindow
+	t.Cleanup(func() { SchemeVersionWindow = prevWindow })
+	SchemeVersionWindow = 1
+
+	for version, supported := range map[int]bool{1: false, 2: true, 3: true, 4: false} {
+		if err := CheckSchemeVersion(version); (err == nil) != supported {
+			t.Errorf("version %d: expected supported %v, got error %v", version, supported, err)
+		}
+	}
+}
+
+func TestCheckMigratableBlock(t *testing.T) {
+	number, next, txHash := int64(1), int64(3), "hash"
+	if err := checkMigratableBlock(database.BlockEntity{Number: &number, TxHash: &txHash}, 0); err != nil {
+		t.Errorf("expected block #1 to be migratable, got %v", err)
+	}
+	for _, blockEntity := range []database.BlockEntity{{Number: &number}, {TxHash: &txHash}, {Number: &next, TxHash: &txHash}} {
+		if err := checkMigratableBlock(blockEntity, 1); !errors.Is(err, ErrBrokenHistory) {
+			t.Errorf("expected broken history, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package state
+
+import (
+	"sort"
+	"time"
+)
+
+// maxMissedOccurrences bounds counting of occurrences passed without completion
+const maxMissedOccurrences = 10000
+
+// Occurrence is a finished occurrence of repeating task, logged on the task instead of a done clone
+type Occurrence struct {
+	DueDate       int64 `json:"dueDate"`
+	DoneAt        int64 `json:"doneAt,omitempty"`
+	Skipped       bool  `json:"skipped,omitempty"`
+	Missed        int   `json:"missed,omitempty"` // occurrences passed without completion before this one
+	DoneSubtasks  int   `json:"doneSubtasks,omitempty"`
+	TotalSubtasks int   `json:"totalSubtasks,omitempty"`
+}
+
+// newOccurrence records the current occurrence of task with the state of its subtasks
+func newOccurrence(task Task) Occurrence {
+	occurrence := Occurrence{
+		DueDate:       task.DueDate,
+		TotalSubtasks: len(task.Subtasks),
+	}
+	for _, subtask := range task.Subtasks {
+		if subtask.Done {
+			occurrence.DoneSubtasks++
+		}
+	}
+	return occurrence
+}
+
+// countMissedOccurrences counts occurrences of fixed schedule strictly between the current occurrence and next due date
+func countMissedOccurrences(task Task, nextDueDate int64, location *time.Location) (int, error) {
+	missed := 0
+	after := currentOccurrence(task)
+	for missed < maxMissedOccurrences {
+		next, ok, err := nextOccurrence(task, after, location)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || next >= nextDueDate {
+			break
+		}
+		missed++
+		after = next
+	}
+	return missed, nil
+}
+
+// OccurrenceStats summarizes the occurrence log of repeating task
+type OccurrenceStats struct {
+	TaskId         string  `json:"taskId"`
+	Completed      int     `json:"completed"`
+	Skipped        int     `json:"skipped"`
+	Missed         int     `json:"missed"`
+	CompletionRate float64 `json:"completionRate"` // completed / (completed + missed), skipped ones are not counted
+	CurrentStreak  int     `json:"currentStreak"`  // completions since the last miss, up to the last logged occurrence
+	LongestStreak  int     `json:"longestStreak"`
+	LastDoneAt     int64   `json:"lastDoneAt"`
+}
+
+// OccurrenceStats returns streaks and completion rate of task.
+// skipping keeps the streak, missing an occurrence breaks it.
+func (t *Task) OccurrenceStats() OccurrenceStats {
+	stats := OccurrenceStats{TaskId: t.Id}
+	for _, occurrence := range t.Occurrences {
+		stats.Missed += occurrence.Missed
+		if occurrence.Missed > 0 {
+			stats.CurrentStreak = 0
+		}
+		if occurrence.Skipped {
+			stats.Skipped++
+			continue
+		}
+		stats.Completed++
+		stats.CurrentStreak++
+		if stats.CurrentStreak > stats.LongestStreak {
+			stats.LongestStreak = stats.CurrentStreak
+		}
+		if occurrence.DoneAt > stats.LastDoneAt {
+			stats.LastDoneAt = occurrence.DoneAt
+		}
+	}
+	if total := stats.Completed + stats.Missed; total > 0 {
+		stats.CompletionRate = float64(stats.Completed) / float64(total)
+	}
+	return stats
+}
+
+// isDoneClone checks if task looks like a done clone of repeating task, created on completion before the occurrence log
+func isDoneClone(clone Task, task Task) bool {
+	if !clone.Done || clone.RepeatPeriod != "" || clone.RepeatStartAt != 0 || clone.Title != task.Title {
+		return false
+	}
+	if len(clone.Categories) != len(task.Categories) {
+		return false
+	}
+	for categoryId := range clone.Categories {
+		if !task.Categories[categoryId] {
+			return false
+		}
+	}
+	return true
+}
+
+// foldTaskClones replaces done clones right before repeating task in the task list with its occurrence log.
+// clones were inserted right before the task on every completion, so they are detected backward from the task.
+// all repeating tasks are folded if taskId is empty.
+func foldTaskClones(updates *Updates, state *State, taskId string) error {
+	sortedTasks, err := state.SortTasks()
+	if err != nil && len(state.Tasks) > 0 {
+		return err
+	}
+
+	taskIds := make([]string, 0)
+	for id, task := range state.Tasks {
+		if task.RepeatPeriod != "" && (taskId == "" || taskId == id) {
+			taskIds = append(taskIds, id)
+		}
+	}
+	sort.Strings(taskIds)
+
+	for _, id := range taskIds {
+		task := state.Tasks[id]
+
+		// clones from the newest one
+		clones := make([]Task, 0)
+		prevId := sortedTasks[id].Prev
+		for prevId != "" && isDoneClone(state.Tasks[prevId], task) {
+			clones = append(clones, state.Tasks[prevId])
+			prevId = sortedTasks[prevId].Prev
+		}
+		if len(clones) == 0 {
+			continue
+		}
+
+		// clones are older than occurrences logged on the task
+		occurrences := make([]Occurrence, 0, len(clones)+len(task.Occurrences))
+		for i := len(clones) - 1; i >= 0; i-- {
+			occurrence := newOccurrence(clones[i])
+			occurrence.DoneAt = clones[i].DoneAt
+			occurrences = append(occurrences, occurrence)
+		}
+		occurrences = append(occurrences, task.Occurrences...)
+		updates.add(OpUpdateTaskOccurrences, &UpdateTaskOccurrencesParams{
+			Id:          id,
+			Occurrences: occurrences,
+		})
+
+		if prevId != "" {
+			updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{
+				Id:   prevId,
+				Next: id,
+			})
+		}
+		for _, clone := range clones {
+			updates.add(OpDeleteTask, &DeleteTaskParams{Id: clone.Id})
+		}
+	}
+	return nil
+}
+
+type FoldReport struct {
+	UserId       string `json:"userId"`
+	FoldedClones int    `json:"foldedClones"`
+	BlockNumber  int64  `json:"blockNumber"` // block of the folding transaction, 0 if nothing is folded
+}
+
+// FoldUserTaskClones appends a block of TxFoldTaskClones to the chain of user, if any clone is detected.
+// clients get the block on their next sync, like a transaction from another device.
+func FoldUserTaskClones(userId string) (*FoldReport, error) {
+	report := &FoldReport{UserId: userId}
+
+	chain, err := Chains.GetChain(userId)
+	if err != nil {
+		return nil, err
+	}
+	defer chain.Release()
+	lastState := chain.GetLastState()
+	if lastState == nil {
+		return report, nil
+	}
+
+	// check clones before appending a block
+	preview := NewUpdates(nil)
+	if err := foldTaskClones(preview, lastState, ""); err != nil {
+		return nil, err
+	}
+	for _, transition := range preview.Transitions {
+		if transition.Operation == OpDeleteTask {
+			report.FoldedClones++
+		}
+	}
+	if report.FoldedClones == 0 {
+		return report, nil
+	}
+
+	tx := NewTransaction(SchemeVersion, userId, TxFoldTaskClones, time.Now().UnixMilli(), &TxFoldTaskClonesBody{}, "")
+	tx.Hash = tx.CalcHash().Hex()
+	block, err := chain.ApplyTransaction(tx, chain.GetLastBlockNumber()+1)
+	if err != nil {
+		return nil, err
+	}
+	report.BlockNumber = block.Number
+	return report, nil
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateTaskDone_LogsOccurrence(t *testing.T) {
+	now := time.Now()
+	// daily task left undone for 3 days
+	dueDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -3)
+	state := NewState()
+	state.Tasks["t1"] = Task{
+		Id:           "t1",
+		DueDate:      dueDate.UnixMilli(),
+		RepeatPeriod: "day",
+		Subtasks:     map[string]Subtask{"s1": {Id: "s1", Done: true}, "s2": {Id: "s2"}},
+		Categories:   map[string]bool{},
+	}
+
+	state = executeTx(t, state, TxUpdateTaskDone, map[string]interface{}{"tid": "t1", "done": true, "doneAt": now.UnixMilli()})
+	if len(state.Tasks) != 1 {
+		t.Fatalf("expected no done clone, got %d tasks", len(state.Tasks))
+	}
+	occurrences := state.Tasks["t1"].Occurrences
+	if len(occurrences) != 1 {
+		t.Fatalf("expected 1 occurrence, got %v", occurrences)
+	}
+	expected := Occurrence{DueDate: dueDate.UnixMilli(), DoneAt: now.UnixMilli(), Missed: 3, DoneSubtasks: 1, TotalSubtasks: 2}
+	if occurrences[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, occurrences[0])
+	}
+
+	state = executeTx(t, state, TxSkipTaskOccurrence, map[string]interface{}{"tid": "t1"})
+	if occurrences := state.Tasks["t1"].Occurrences; len(occurrences) != 2 || !occurrences[1].Skipped {
+		t.Errorf("expected skipped occurrence to be logged, got %v", occurrences)
+	}
+}
+
+func TestOccurrenceStats(t *testing.T) {
+	task := Task{Id: "t1", Occurrences: []Occurrence{
+		{DoneAt: 1},
+		{DoneAt: 2},
+		{DoneAt: 3},
+		{Missed: 2, DoneAt: 4},
+		{Skipped: true},
+		{DoneAt: 5},
+	}}
+	stats := task.OccurrenceStats()
+	expected := OccurrenceStats{
+		TaskId:         "t1",
+		Completed:      5,
+		Skipped:        1,
+		Missed:         2,
+		CompletionRate: 5.0 / 7.0,
+		CurrentStreak:  2,
+		LongestStreak:  3,
+		LastDoneAt:     5,
+	}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestFoldTaskClones(t *testing.T) {
+	categories := map[string]bool{"c1": true}
+	state := NewState()
+	state.Categories["c1"] = Category{Id: "c1"}
+	// first -> clone1 -> clone2 -> repeating -> last
+	state.Tasks["first"] = Task{Id: "first", Title: "other", Done: true, Next: "clone1", Categories: map[string]bool{}}
+	state.Tasks["clone1"] = Task{Id: "clone1", Title: "habit", Done: true, DueDate: 1, DoneAt: 10, Next: "clone2", Categories: categories}
+	state.Tasks["clone2"] = Task{Id: "clone2", Title: "habit", Done: true, DueDate: 2, DoneAt: 20, Next: "repeating", Categories: categories,
+		Subtasks: map[string]Subtask{"s1": {Id: "s1", Done: true}}}
+	state.Tasks["repeating"] = Task{Id: "repeating", Title: "habit", DueDate: 4, RepeatPeriod: "day", Next: "last", Categories: categories,
+		Occurrences: []Occurrence{{DueDate: 3, DoneAt: 30}}}
+	state.Tasks["last"] = Task{Id: "last", Title: "habit", Done: true, Categories: categories}
+
+	state = executeTx(t, state, TxFoldTaskClones, map[string]interface{}{})
+	if err := state.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Tasks["clone1"]; ok || len(state.Tasks) != 3 {
+		t.Fatalf("expected clones to be folded, got %v", state.Tasks)
+	}
+	if next := state.Tasks["first"].Next; next != "repeating" {
+		t.Errorf("expected first task to be linked to repeating task, got %s", next)
+	}
+	expected := []Occurrence{
+		{DueDate: 1, DoneAt: 10},
+		{DueDate: 2, DoneAt: 20, DoneSubtasks: 1, TotalSubtasks: 1},
+		{DueDate: 3, DoneAt: 30},
+	}
+	occurrences := state.Tasks["repeating"].Occurrences
+	if len(occurrences) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, occurrences)
+	}
+	for i := range expected {
+		if occurrences[i] != expected[i] {
+			t.Errorf("occurrence #%d: expected %+v, got %+v", i, expected[i], occurrences[i])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"memorial_app_server/util"
+)
+
+// MaxRebaseDepth is the maximum number of blocks a stale transaction can be rebased over
+var MaxRebaseDepth int64 = 100
+
+const entityAll = "*"
+
+// RebaseConflictError is returned when a stale transaction can't be rebased on the last block
+type RebaseConflictError struct {
+	TargetBlockNumber   int64  `json:"targetBlockNumber"`
+	ConflictBlockNumber int64  `json:"conflictBlockNumber"`
+	Entity              string `json:"entity,omitempty"`
+	Reason              string `json:"reason"`
+}
+
+func (e *RebaseConflictError) Error() string {
+	return fmt.Sprintf("transaction for block #%d conflicts with block #%d: %s", e.TargetBlockNumber, e.ConflictBlockNumber, e.Reason)
+}
+
+// touchedParams collects entity ids used by any transition params
+type touchedParams struct {
+	TaskId     string          `json:"tid"`
+	SubtaskId  string          `json:"sid"`
+	CategoryId string          `json:"cid"`
+	Next       string          `json:"next"`
+	Categories map[string]bool `json:"categories"`
+}
+
+// TouchedEntities returns keys of tasks, subtasks and categories changed by transitions
+func (t Transitions) TouchedEntities() (map[string]bool, error) {
+	entities := make(map[string]bool)
+	for _, transition := range t {
+		if transition.Operation == OpDeleteAll {
+			entities[entityAll] = true
+			continue
+		}
+
+		var params touchedParams
+		if err := util.InterfaceToStruct(transition.Params, &params); err != nil {
+			return nil, err
+		}
+		switch {
+		case params.SubtaskId != "":
+			entities["subtask:"+params.TaskId+"/"+params.SubtaskId] = true
+		case params.TaskId != "":
+			entities["task:"+params.TaskId] = true
+		}
+		if params.CategoryId != "" {
+			entities["category:"+params.CategoryId] = true
+		}
+		if params.Next != "" {
+			entities["task:"+params.Next] = true
+		}
+		for categoryId := range params.Categories {
+			entities["category:"+categoryId] = true
+		}
+	}
+	return entities, nil
+}
+
+// findConflict returns the first entity in both sets
+func findConflict(a, b map[string]bool) (string, bool) {
+	if len(a) == 0 || len(b) == 0 {
+		return "", false
+	}
+	if a[entityAll] || b[entityAll] {
+		return entityAll, true
+	}
+	for entity := range a {
+		if b[entity] {
+			return entity, true
+		}
+	}
+	return "", false
+}
+
+// RebaseTransaction applies a transaction built for an older block on the last block,
+// if the entities it touches are not changed by blocks applied since then.
+// blockHash is the hash of the target block client expects, client's chain diverged if it differs (not checked if empty).
+func (c *Chain) RebaseTransaction(tx *Transaction, targetBlockNumber int64, blockHash string) (*Block, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rebaseTransaction(tx, targetBlockNumber, blockHash)
+}
+
+// rebaseTransaction rebases a transaction on the last block (c.lock should be held)
+func (c *Chain) rebaseTransaction(tx *Transaction, targetBlockNumber int64, blockHash string) (*Block, error) {
+	lastBlockNumber := c.GetLastBlockNumber()
+	if targetBlockNumber < 1 || targetBlockNumber > lastBlockNumber {
+		return nil, fmt.Errorf("invalid block number to rebase: %d", targetBlockNumber)
+	}
+	if lastBlockNumber-targetBlockNumber+1 > MaxRebaseDepth {
+		return nil, &RebaseConflictError{
+			TargetBlockNumber:   targetBlockNumber,
+			ConflictBlockNumber: lastBlockNumber,
+			Reason:              fmt.Sprintf("too many blocks to rebase over (max %d)", MaxRebaseDepth),
+		}
+	}
+
+	// execute on the base state, as client did
+	baseBlock, err := c.GetBlockByNumber(targetBlockNumber - 1)
+	if err != nil {
+		return nil, err
+	}
+	if blockHash != "" && ExpectedBlockHash(targetBlockNumber, tx.Hash, baseBlock.Hash).Hex() != blockHash {
+		return nil, &RebaseConflictError{
+			TargetBlockNumber:   targetBlockNumber,
+			ConflictBlockNumber: targetBlockNumber - 1,
+			Reason:              "client's chain diverged from base block",
+		}
+	}
+	baseUpdates, err := PreExecuteTransaction(baseBlock.State, tx, targetBlockNumber, c, c.Location())
+	if err != nil {
+		return nil, err
+	}
+	touched, err := baseUpdates.Transitions.TouchedEntities()
+	if err != nil {
+		return nil, err
+	}
+
+	// compare with entities of blocks applied since then
+	for number := targetBlockNumber; number <= lastBlockNumber; number++ {
+		block, err := c.GetBlockByNumber(number)
+		if err != nil {
+			return nil, err
+		}
+		if block.Updates == nil {
+			continue
+		}
+		applied, err := block.Updates.Transitions.TouchedEntities()
+		if err != nil {
+			return nil, err
+		}
+		if entity, conflict := findConflict(touched, applied); conflict {
+			return nil, &RebaseConflictError{
+				TargetBlockNumber:   targetBlockNumber,
+				ConflictBlockNumber: number,
+				Entity:              entity,
+				Reason:              "entity changed since target block",
+			}
+		}
+	}
+
+	// re-execute on the last block
+	newBlock, err := c.applyTransaction(tx, lastBlockNumber+1)
+	if err != nil {
+		// block not saved is not a conflict, the transaction can be sent again as is
+		if errors.Is(err, ErrBlockNotSaved) {
+			return nil, err
+		}
+		return nil, &RebaseConflictError{
+			TargetBlockNumber:   targetBlockNumber,
+			ConflictBlockNumber: lastBlockNumber,
+			Reason:              err.Error(),
+		}
+	}
+	return newBlock, nil
+}
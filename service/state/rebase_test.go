@@ -0,0 +1,174 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestTransitions_TouchedEntities(t *testing.T) {
+	updates := NewUpdates(nil)
+	updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{Id: "t1", Next: "t2"})
+	updates.add(OpCreateTaskCategory, &CreateTaskCategoryParams{Id: "t3", CategoryId: "c1"})
+	updates.add(OpUpdateSubtaskTitle, &UpdateSubtaskTitleParams{Id: "t4", SubtaskId: "s1", Title: "title"})
+
+	entities, err := updates.Transitions.TouchedEntities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range []string{"task:t1", "task:t2", "task:t3", "category:c1", "subtask:t4/s1"} {
+		if !entities[entity] {
+			t.Errorf("expected %s to be touched", entity)
+		}
+	}
+	if entities["task:t4"] {
+		t.Error("subtask update should not touch its task")
+	}
+}
+
+func TestFindConflict(t *testing.T) {
+	a := map[string]bool{"task:t1": true}
+	if _, conflict := findConflict(a, map[string]bool{"task:t2": true}); conflict {
+		t.Error("expected no conflict")
+	}
+	if entity, conflict := findConflict(a, map[string]bool{"task:t1": true}); !conflict || entity != "task:t1" {
+		t.Error("expected conflict on task:t1")
+	}
+	if _, conflict := findConflict(a, map[string]bool{entityAll: true}); !conflict {
+		t.Error("expected conflict with delete all")
+	}
+}
+
+// rebaseTestChain returns chain with tasks t0~t2 created, and t0 renamed at block #4
+func rebaseTestChain(t *testing.T) (*Chain, *memoryChainStore) {
+	store := newMemoryChainStore()
+	chain := testChain("uid", store)
+	applyTestTxs(t, chain, 4)
+	return chain, store
+}
+
+// renameTx returns transaction renaming task, timestamp tells transactions of the same content apart
+func renameTx(taskId, title string, timestamp int64) *Transaction {
+	return testTx(TxUpdateTaskTitle, timestamp, map[string]interface{}{"tid": taskId, "title": title})
+}
+
+func TestChain_RebaseTransaction(t *testing.T) {
+	chain, _ := rebaseTestChain(t)
+	baseBlock, _ := chain.GetBlockByNumber(3)
+
+	// built on block #3 by a client which didn't get block #4 yet
+	tx := renameTx("t1", "renamed on device", 100)
+	blockHash := ExpectedBlockHash(4, tx.Hash, baseBlock.Hash).Hex()
+	block, rebased, err := chain.SubmitTransaction(tx, 4, blockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rebased || block.Number != 5 || chain.GetLastBlockNumber() != 5 {
+		t.Errorf("expected transaction rebased to block #5, got #%d (rebased %v)", block.Number, rebased)
+	}
+	if title := block.State.Tasks["t1"].Title; title != "renamed on device" {
+		t.Errorf("expected title of rebased transaction, got %s", title)
+	}
+	if title := block.State.Tasks["t0"].Title; title != "title 3" {
+		t.Errorf("expected title of block #4 kept, got %s", title)
+	}
+}
+
+func TestChain_RebaseTransactionConflict(t *testing.T) {
+	chain, _ := rebaseTestChain(t)
+
+	// block #4 renamed the same task
+	_, _, err := chain.SubmitTransaction(renameTx("t0", "renamed on device", 100), 4, "")
+	var conflict *RebaseConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected rebase conflict, got %v", err)
+	}
+	if conflict.ConflictBlockNumber != 4 || conflict.Entity != "task:t0" {
+		t.Errorf("expected conflict with task:t0 of block #4, got %+v", conflict)
+	}
+
+	// client's block #3 is not the one of server
+	_, _, err = chain.SubmitTransaction(renameTx("t1", "renamed on device", 100), 4, "diverged")
+	if !errors.As(err, &conflict) || conflict.ConflictBlockNumber != 3 {
+		t.Errorf("expected conflict with base block #3, got %v", err)
+	}
+	if chain.GetLastBlockNumber() != 4 {
+		t.Errorf("expected conflicting transactions not applied, last block #%d", chain.GetLastBlockNumber())
+	}
+}
+
+func TestChain_RebaseTransactionMaxDepth(t *testing.T) {
+	defer func(depth int64) { MaxRebaseDepth = depth }(MaxRebaseDepth)
+	MaxRebaseDepth = 2
+	// blocks #4~#6 rename t0, t1 and t2
+	chain := testChain("uid", newMemoryChainStore())
+	applyTestTxs(t, chain, 6)
+
+	var conflict *RebaseConflictError
+	if _, _, err := chain.SubmitTransaction(renameTx("t0", "too old", 100), 4, ""); !errors.As(err, &conflict) {
+		t.Errorf("expected transaction over 3 blocks to conflict, got %v", err)
+	}
+	if block, rebased, err := chain.SubmitTransaction(renameTx("t0", "recent", 101), 5, ""); err != nil || !rebased || block.Number != 7 {
+		t.Errorf("expected transaction over 2 blocks rebased to block #7, got %v", err)
+	}
+}
+
+func TestChain_ConcurrentTransactionsForSameBlock(t *testing.T) {
+	chain, store := rebaseTestChain(t)
+
+	// devices send transactions for block #5 at once, each touching another task
+	var wg sync.WaitGroup
+	results := make(chan bool, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, rebased, err := chain.SubmitTransaction(renameTx(fmt.Sprintf("t%d", i), "concurrent", int64(100+i)), 5, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- rebased
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	applied := 0
+	for rebased := range results {
+		if !rebased {
+			applied++
+		}
+	}
+	if applied != 1 {
+		t.Errorf("expected one transaction applied on block #5 and the others rebased, %d applied", applied)
+	}
+	for number := int64(1); number <= 7; number++ {
+		if count := store.rowCount("uid", number); count != 1 {
+			t.Errorf("expected one row of block #%d, got %d", number, count)
+		}
+	}
+
+	// applying directly doesn't create a duplicated block either
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := chain.ApplyTransaction(renameTx(fmt.Sprintf("t%d", i), "applied", int64(200+i)), 8)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 2 || store.rowCount("uid", 8) != 1 {
+		t.Errorf("expected one transaction applied on block #8, %d failed and %d rows stored", failed, store.rowCount("uid", 8))
+	}
+}
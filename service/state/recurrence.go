@@ -0,0 +1,164 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"memorial_app_server/libs/rrule"
+	"time"
+)
+
+// ErrNoNextOccurrence is returned when there's no occurrence to move repeating task to
+var ErrNoNextOccurrence = errors.New("no next occurrence")
+
+// recurrence modes of repeating task
+const (
+	RepeatModeFixed           = ""                // due dates are on the fixed schedule from RepeatStartAt
+	RepeatModeAfterCompletion = "afterCompletion" // the next due date is counted from the completion
+)
+
+func validateRepeatMode(repeatMode string) error {
+	switch repeatMode {
+	case RepeatModeFixed, RepeatModeAfterCompletion:
+		return nil
+	}
+	return fmt.Errorf("invalid repeat mode: %q", repeatMode)
+}
+
+// legacyRepeatPeriods are repeat periods used before RRULE was supported (months and years repeat by legacyMonthPeriods)
+var legacyRepeatPeriods = map[string]string{
+	"day":   "FREQ=DAILY",
+	"week":  "FREQ=WEEKLY",
+	"month": "FREQ=MONTHLY",
+	"year":  "FREQ=YEARLY",
+}
+
+// legacyMonthPeriods are legacy periods repeated as before RRULE was supported, by adding months to the previous occurrence.
+// a day missing in the month overflows into the next month (Jan 31 is followed by Mar 3, then Apr 3),
+// unlike FREQ=MONTHLY which skips the month, so stored tasks keep their due dates.
+var legacyMonthPeriods = map[string]int{
+	"month": 1,
+	"year":  12,
+}
+
+// nextRecurrence returns the first occurrence of repeat period from dtstart strictly after the given time.
+// false is returned if the recurrence is over (COUNT or UNTIL).
+func nextRecurrence(repeatPeriod string, dtstart, after time.Time) (time.Time, bool, error) {
+	if months, ok := legacyMonthPeriods[repeatPeriod]; ok {
+		next := dtstart
+		for !next.After(after) {
+			next = next.AddDate(0, months, 0)
+		}
+		return next, true, nil
+	}
+	rule, err := ParseRepeatPeriod(repeatPeriod)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	next, ok := rule.Next(dtstart, after)
+	return next, ok, nil
+}
+
+// ParseRepeatPeriod parses repeat period of task, which is RRULE (e.g. "FREQ=WEEKLY;BYDAY=MO,FR") or a legacy period
+func ParseRepeatPeriod(repeatPeriod string) (*rrule.Rule, error) {
+	if legacy, ok := legacyRepeatPeriods[repeatPeriod]; ok {
+		repeatPeriod = legacy
+	}
+	rule, err := rrule.Parse(repeatPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repeat period %q: %w", repeatPeriod, err)
+	}
+	return rule, nil
+}
+
+// nextDueDate returns the next due date of repeating task after both its due date and now.
+// recurrence is calculated in the timezone of user, so it follows DST of the zone.
+// all-day due dates are calendar dates (UTC midnight), they are calculated in UTC to keep the date.
+// false is returned if the recurrence is over (COUNT or UNTIL).
+func nextDueDate(task Task, now time.Time, location *time.Location) (int64, bool, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	after := currentOccurrence(task)
+	if task.AllDay {
+		// today of user as a calendar date
+		if today := calendarDate(now.In(location)).UnixMilli(); today > after {
+			after = today
+		}
+	} else if now.UnixMilli() > after {
+		after = now.UnixMilli()
+	}
+	return nextOccurrence(task, after, location)
+}
+
+// currentOccurrence returns the occurrence of repeating task which its due date is for.
+// it differs from the due date only if the occurrence is rescheduled.
+func currentOccurrence(task Task) int64 {
+	if task.RescheduledFrom != 0 {
+		return task.RescheduledFrom
+	}
+	return task.DueDate
+}
+
+// nextOccurrence returns the first occurrence of repeating task strictly after the given time (in ms).
+// false is returned if the recurrence is over (COUNT or UNTIL).
+func nextOccurrence(task Task, after int64, location *time.Location) (int64, bool, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	repeatStartAt := task.RepeatStartAt
+	if repeatStartAt == 0 {
+		repeatStartAt = task.DueDate
+	}
+
+	dtstart := time.UnixMilli(repeatStartAt).In(location)
+	if task.AllDay {
+		dtstart = dtstart.UTC()
+	}
+	next, ok, err := nextRecurrence(task.RepeatPeriod, dtstart, time.UnixMilli(after))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return next.UnixMilli(), true, nil
+}
+
+// nextDueDateAfterCompletion returns the next due date of task repeating after completion.
+// the recurrence restarts at the completion date, keeping the time of the due date
+// (e.g. "FREQ=DAILY;INTERVAL=3" is 3 days after the last completion).
+// COUNT is counted from each completion since the recurrence restarts every time, UNTIL ends it as usual.
+func nextDueDateAfterCompletion(task Task, completedAt int64, location *time.Location) (int64, bool, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	completed := time.UnixMilli(completedAt).In(location)
+	dtstart := completed
+	if task.AllDay {
+		dtstart = calendarDate(completed)
+	} else if task.DueDate != 0 {
+		dueDate := time.UnixMilli(task.DueDate).In(location)
+		year, month, day := completed.Date()
+		dtstart = time.Date(year, month, day, dueDate.Hour(), dueDate.Minute(), dueDate.Second(), dueDate.Nanosecond(), location)
+	}
+
+	next, ok, err := nextRecurrence(task.RepeatPeriod, dtstart, dtstart)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return next.UnixMilli(), true, nil
+}
+
+// calendarDate returns the date of t as UTC midnight
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// validateDueDate checks all-day due date is at UTC midnight (calendar date)
+func validateDueDate(dueDate int64, allDay bool) error {
+	if allDay && dueDate%(24*time.Hour).Milliseconds() != 0 {
+		return fmt.Errorf("all-day due date should be UTC midnight of the date: %d", dueDate)
+	}
+	return nil
+}
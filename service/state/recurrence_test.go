@@ -0,0 +1,220 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDueDate(t *testing.T) {
+	dueDate := time.Date(2024, 1, 31, 9, 0, 0, 0, time.Local)
+	now := dueDate.Add(time.Hour)
+
+	for repeatPeriod, expected := range map[string]time.Time{
+		"day":                        dueDate.AddDate(0, 0, 1),
+		"FREQ=MONTHLY":               time.Date(2024, 3, 31, 9, 0, 0, 0, time.Local),
+		"FREQ=MONTHLY;BYMONTHDAY=-1": time.Date(2024, 2, 29, 9, 0, 0, 0, time.Local),
+		// legacy period overflows into the next month like before RRULE was supported
+		"month": time.Date(2024, 3, 2, 9, 0, 0, 0, time.Local),
+	} {
+		task := Task{RepeatPeriod: repeatPeriod, DueDate: dueDate.UnixMilli()}
+		next, ok, err := nextDueDate(task, now, time.Local)
+		if err != nil || !ok {
+			t.Fatalf("%s: unexpected result %v %v", repeatPeriod, ok, err)
+		}
+		if next != expected.UnixMilli() {
+			t.Errorf("%s: expected %s, got %s", repeatPeriod, expected, time.UnixMilli(next))
+		}
+	}
+}
+
+func TestNextDueDate_LegacyMonthPeriods(t *testing.T) {
+	// occurrences follow the previous one added a month: Jan 31, Mar 2, Apr 2, ...
+	task := Task{RepeatPeriod: "month", RepeatStartAt: time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC).UnixMilli()}
+	task.DueDate = time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC).UnixMilli()
+	next, _, err := nextOccurrence(task, task.DueDate, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 4, 2, 9, 0, 0, 0, time.UTC); next != expected.UnixMilli() {
+		t.Errorf("month: expected %s, got %s", expected, time.UnixMilli(next).UTC())
+	}
+
+	// Feb 29 is followed by Mar 1 of the next year, not by the next leap year
+	task = Task{RepeatPeriod: "year", DueDate: time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC).UnixMilli()}
+	next, _, err = nextOccurrence(task, task.DueDate, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC); next != expected.UnixMilli() {
+		t.Errorf("year: expected %s, got %s", expected, time.UnixMilli(next).UTC())
+	}
+}
+
+func TestNextDueDate_UserTimezone(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// 09:00 in New York is kept across DST (2024-03-10)
+	dueDate := time.Date(2024, 3, 9, 9, 0, 0, 0, newYork)
+	task := Task{RepeatPeriod: "day", DueDate: dueDate.UnixMilli()}
+	next, _, err := nextDueDate(task, dueDate, newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 3, 10, 9, 0, 0, 0, newYork); next != expected.UnixMilli() {
+		t.Errorf("expected %s, got %s", expected, time.UnixMilli(next).In(newYork))
+	}
+
+	// month end in Seoul is not the one in UTC
+	dueDate = time.Date(2024, 1, 31, 8, 0, 0, 0, seoul)
+	task = Task{RepeatPeriod: "FREQ=MONTHLY;BYMONTHDAY=-1", DueDate: dueDate.UnixMilli()}
+	next, _, err = nextDueDate(task, dueDate, seoul)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 2, 29, 8, 0, 0, 0, seoul); next != expected.UnixMilli() {
+		t.Errorf("expected %s, got %s", expected, time.UnixMilli(next).In(seoul))
+	}
+}
+
+func TestNextDueDate_AllDay(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// all-day due date keeps the calendar date regardless of user's timezone
+	dueDate := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	task := Task{RepeatPeriod: "week", DueDate: dueDate.UnixMilli(), AllDay: true}
+	for _, location := range []*time.Location{time.UTC, seoul} {
+		// done on the due date in user's timezone
+		now := time.Date(2024, 3, 9, 23, 0, 0, 0, location)
+		next, _, err := nextDueDate(task, now, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC); next != expected.UnixMilli() {
+			t.Errorf("%s: expected %s, got %s", location, expected, time.UnixMilli(next).UTC())
+		}
+	}
+
+	if err := validateDueDate(dueDate.UnixMilli()+1, true); err == nil {
+		t.Errorf("expected all-day due date not at midnight to be invalid")
+	}
+}
+
+func TestUpdateTaskDone_InvalidRepeatPeriod(t *testing.T) {
+	state := NewState()
+	state.Tasks["t1"] = Task{Id: "t1", RepeatPeriod: "fortnight", DueDate: 1, Subtasks: map[string]Subtask{}, Categories: map[string]bool{}}
+
+	tx := NewTransaction(SchemeVersion, "uid", TxUpdateTaskDone, 0, map[string]interface{}{"tid": "t1", "done": true}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected error for invalid repeat period")
+	}
+
+	tx = NewTransaction(SchemeVersion, "uid", TxUpdateTaskRepeatPeriod, 0, map[string]interface{}{"tid": "t1", "repeatPeriod": "fortnight"}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected invalid repeat period to be rejected")
+	}
+}
+
+func executeTx(t *testing.T, state *State, txType int64, content map[string]interface{}) *State {
+	tx := NewTransaction(SchemeVersion, "uid", txType, 0, content, "")
+	updates, err := PreExecuteTransaction(state, tx, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newState, err := updates.ApplyTransitions(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newState
+}
+
+func TestSkipAndRescheduleTaskOccurrence(t *testing.T) {
+	monday := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+	state := NewState()
+	state.Tasks["t1"] = Task{
+		Id:           "t1",
+		DueDate:      monday.UnixMilli(),
+		RepeatPeriod: "week",
+		Subtasks:     map[string]Subtask{"s1": {Id: "s1", DueDate: monday.UnixMilli(), Done: true}},
+		Categories:   map[string]bool{},
+	}
+
+	// postpone this monday to wednesday
+	wednesday := monday.AddDate(0, 0, 2)
+	state = executeTx(t, state, TxRescheduleTaskOccurrence, map[string]interface{}{"tid": "t1", "dueDate": wednesday.UnixMilli()})
+	task := state.Tasks["t1"]
+	if task.DueDate != wednesday.UnixMilli() || task.RescheduledFrom != monday.UnixMilli() || task.RepeatStartAt != monday.UnixMilli() {
+		t.Fatalf("unexpected rescheduled task: %+v", task)
+	}
+	if subtask := task.Subtasks["s1"]; subtask.DueDate != wednesday.UnixMilli() || !subtask.Done {
+		t.Errorf("unexpected subtask of rescheduled task: %+v", subtask)
+	}
+
+	// rescheduling again keeps the original occurrence
+	thursday := monday.AddDate(0, 0, 3)
+	state = executeTx(t, state, TxRescheduleTaskOccurrence, map[string]interface{}{"tid": "t1", "dueDate": thursday.UnixMilli()})
+	if task := state.Tasks["t1"]; task.DueDate != thursday.UnixMilli() || task.RescheduledFrom != monday.UnixMilli() {
+		t.Fatalf("unexpected rescheduled task: %+v", task)
+	}
+
+	// skipping goes to the monday after the original occurrence, without done clone
+	state = executeTx(t, state, TxSkipTaskOccurrence, map[string]interface{}{"tid": "t1"})
+	if len(state.Tasks) != 1 {
+		t.Fatalf("expected no clone of skipped task, got %d tasks", len(state.Tasks))
+	}
+	nextMonday := monday.AddDate(0, 0, 7)
+	task = state.Tasks["t1"]
+	if task.DueDate != nextMonday.UnixMilli() || task.RescheduledFrom != 0 || task.RepeatStartAt != monday.UnixMilli() || task.Done {
+		t.Fatalf("unexpected skipped task: %+v", task)
+	}
+	if subtask := task.Subtasks["s1"]; subtask.DueDate != nextMonday.UnixMilli() || subtask.Done {
+		t.Errorf("unexpected subtask of skipped task: %+v", subtask)
+	}
+
+	// not repeating task has no occurrence
+	task.RepeatPeriod = ""
+	state.Tasks["t1"] = task
+	tx := NewTransaction(SchemeVersion, "uid", TxSkipTaskOccurrence, 0, map[string]interface{}{"tid": "t1"}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected skipping not repeating task to be rejected")
+	}
+}
+
+func TestUpdateTaskDone_RepeatAfterCompletion(t *testing.T) {
+	monday := time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)
+	state := NewState()
+	state.Tasks["t1"] = Task{
+		Id:           "t1",
+		DueDate:      monday.UnixMilli(),
+		RepeatPeriod: "FREQ=DAILY;INTERVAL=3",
+		Subtasks:     map[string]Subtask{"s1": {Id: "s1", DueDate: monday.Add(-time.Hour).UnixMilli()}},
+		Categories:   map[string]bool{},
+	}
+	state = executeTx(t, state, TxUpdateTaskRepeatMode, map[string]interface{}{"tid": "t1", "repeatMode": RepeatModeAfterCompletion})
+
+	// done late on wednesday, next due date is 3 days after at the time of the due date
+	doneAt := time.Date(2024, 1, 3, 15, 30, 0, 0, time.Local)
+	state = executeTx(t, state, TxUpdateTaskDone, map[string]interface{}{"tid": "t1", "done": true, "doneAt": doneAt.UnixMilli()})
+	saturday := time.Date(2024, 1, 6, 9, 0, 0, 0, time.Local)
+	task := state.Tasks["t1"]
+	if task.DueDate != saturday.UnixMilli() || task.Done {
+		t.Fatalf("expected due date %s, got %s", saturday, time.UnixMilli(task.DueDate))
+	}
+	if subtask := task.Subtasks["s1"]; subtask.DueDate != saturday.Add(-time.Hour).UnixMilli() {
+		t.Errorf("expected subtask due date to keep its offset, got %s", time.UnixMilli(subtask.DueDate))
+	}
+
+	tx := NewTransaction(SchemeVersion, "uid", TxUpdateTaskRepeatMode, 0, map[string]interface{}{"tid": "t1", "repeatMode": "sometimes"}, "")
+	if _, err := PreExecuteTransaction(state, tx, 1, nil, nil); err == nil {
+		t.Errorf("expected invalid repeat mode to be rejected")
+	}
+}
@@ -0,0 +1,147 @@
+package state
+
+// Protected reports whether tasks of category are hidden from sessions not unlocked by PIN
+func (c *Category) Protected() bool {
+	return c.Secret || c.Locked
+}
+
+// ProtectedTaskIds returns ids of tasks in secret or locked categories
+func (s *State) ProtectedTaskIds() map[string]bool {
+	protected := make(map[string]bool)
+	for _, task := range s.Tasks {
+		for categoryId := range task.Categories {
+			if category, ok := s.Categories[categoryId]; ok && category.Protected() {
+				protected[task.Id] = true
+				break
+			}
+		}
+	}
+	return protected
+}
+
+// Redact returns copy of state without titles, memos and tags of protected tasks (and titles of their subtasks)
+func (s *State) Redact() *State {
+	return s.redact(s.ProtectedTaskIds())
+}
+
+// RedactWith redacts tasks protected elsewhere (e.g. in the last state) too, for states of historic blocks
+func (s *State) RedactWith(protected map[string]bool) *State {
+	return s.redact(union(s.ProtectedTaskIds(), protected))
+}
+
+func union(sets ...map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	for _, set := range sets {
+		for id := range set {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+func (s *State) redact(protected map[string]bool) *State {
+	redacted := s.Copy()
+	for taskId := range protected {
+		task, ok := redacted.Tasks[taskId]
+		if !ok {
+			continue
+		}
+		task.Title = ""
+		task.Memo = ""
+		task.Tags = nil
+		for sid, subtask := range task.Subtasks {
+			subtask.Title = ""
+			task.Subtasks[sid] = subtask
+		}
+		redacted.Tasks[taskId] = task
+	}
+	return redacted
+}
+
+// RedactBlock returns copy of block without text of tasks protected before (prevState) or after the block,
+// or protected later (e.g. in the last state) as given by protected.
+// content of the source transaction is dropped if any of its transitions is redacted, its hash is kept.
+func RedactBlock(prevState *State, block *Block, protected map[string]bool) *Block {
+	protected = union(protected)
+	for _, s := range []*State{prevState, block.State} {
+		if s == nil {
+			continue
+		}
+		for taskId := range s.ProtectedTaskIds() {
+			protected[taskId] = true
+		}
+	}
+
+	redacted := &Block{
+		Number:        block.Number,
+		StateRoot:     block.StateRoot,
+		PrevBlockHash: block.PrevBlockHash,
+		Hash:          block.Hash,
+	}
+	if block.State != nil {
+		redacted.State = block.State.redact(protected)
+	}
+	if block.Updates != nil {
+		transitions, changed := redactTransitions(block.Updates.Transitions, protected)
+		srcTx := block.Updates.SrcTx
+		if changed && srcTx != nil {
+			txCopy := *srcTx
+			txCopy.Content = nil
+			srcTx = &txCopy
+		}
+		redacted.Updates = NewUpdatesWithTransitions(srcTx, transitions)
+	}
+	return redacted
+}
+
+// redactTransitions returns copy of transitions without text of protected tasks
+func redactTransitions(transitions Transitions, protected map[string]bool) (Transitions, bool) {
+	redacted := make(Transitions, 0, len(transitions))
+	changed := false
+	for _, transition := range transitions {
+		params := transition.Params
+		if spec, err := GetOpSpec(transition.Operation); err == nil {
+			if decoded, err := spec.Decode(params); err == nil {
+				params = decoded
+			}
+		}
+		switch params := params.(type) {
+		case *CreateTaskParams:
+			if protected[params.Id] {
+				p := *params
+				p.Title, p.Memo, p.Tags = "", "", nil
+				transition.Params = &p
+				changed = true
+			}
+		case *UpdateTaskTitleParams:
+			if protected[params.Id] {
+				transition.Params = &UpdateTaskTitleParams{Id: params.Id}
+				changed = true
+			}
+		case *UpdateTaskMemoParams:
+			if protected[params.Id] {
+				transition.Params = &UpdateTaskMemoParams{Id: params.Id}
+				changed = true
+			}
+		case *UpdateTaskTagsParams:
+			if protected[params.Id] {
+				transition.Params = &UpdateTaskTagsParams{Id: params.Id}
+				changed = true
+			}
+		case *CreateSubtaskParams:
+			if protected[params.Id] {
+				p := *params
+				p.Title = ""
+				transition.Params = &p
+				changed = true
+			}
+		case *UpdateSubtaskTitleParams:
+			if protected[params.Id] {
+				transition.Params = &UpdateSubtaskTitleParams{Id: params.Id, SubtaskId: params.SubtaskId}
+				changed = true
+			}
+		}
+		redacted = append(redacted, transition)
+	}
+	return redacted, changed
+}
@@ -0,0 +1,81 @@
+package state
+
+import "testing"
+
+func TestRedactBlock(t *testing.T) {
+	prevState := NewState()
+	prevState.Categories["secret"] = Category{Id: "secret", Secret: true}
+	prevState.Tasks["t1"] = Task{
+		Id:         "t1",
+		Title:      "secret title",
+		Memo:       "secret memo",
+		Subtasks:   map[string]Subtask{"s1": {Id: "s1", Title: "secret subtask"}},
+		Categories: map[string]bool{"secret": true},
+	}
+
+	// t1 leaves the secret category and is renamed in the same block, t2 is not protected
+	tx := NewTransaction(SchemeVersion, "uid", TxBatch, 0, nil, "hash")
+	updates := NewUpdates(tx)
+	updates.add(OpDeleteTaskCategory, &DeleteTaskCategoryParams{Id: "t1", CategoryId: "secret"})
+	updates.add(OpUpdateTaskTitle, &UpdateTaskTitleParams{Id: "t1", Title: "new secret title"})
+	updates.add(OpCreateTask, &CreateTaskParams{Id: "t2", Title: "plain", Categories: map[string]bool{}})
+	newState, err := updates.ApplyTransitions(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(1, newState, updates, "")
+
+	redacted := RedactBlock(prevState, block, nil)
+	if title := redacted.Updates.Transitions[1].Params.(*UpdateTaskTitleParams).Title; title != "" {
+		t.Errorf("expected title of task protected before the block redacted, got %q", title)
+	}
+	if title := redacted.Updates.Transitions[2].Params.(*CreateTaskParams).Title; title != "plain" {
+		t.Errorf("expected title of unprotected task kept, got %q", title)
+	}
+	if task := redacted.State.Tasks["t1"]; task.Title != "" || task.Memo != "" || task.Subtasks["s1"].Title != "" {
+		t.Errorf("expected task redacted in state, got %+v", task)
+	}
+	if redacted.Updates.SrcTx.Hash != "hash" || redacted.Hash != block.Hash {
+		t.Errorf("expected hashes kept")
+	}
+
+	// original block is not changed
+	if block.State.Tasks["t1"].Title != "new secret title" || block.Updates.Transitions[1].Params.(*UpdateTaskTitleParams).Title != "new secret title" {
+		t.Errorf("expected original block unchanged")
+	}
+}
+
+func TestRedactBlock_ProtectedLater(t *testing.T) {
+	// t1 is plain in the block, but moved into a secret category afterwards
+	tx := NewTransaction(SchemeVersion, "uid", TxCreateTask, 0, nil, "hash")
+	updates := NewUpdates(tx)
+	updates.add(OpCreateTask, &CreateTaskParams{Id: "t1", Title: "later secret", Categories: map[string]bool{}})
+	newState, err := updates.ApplyTransitions(NewState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(1, newState, updates, "")
+
+	lastState := newState.Copy()
+	lastState.Categories["secret"] = Category{Id: "secret", Secret: true}
+	task := lastState.Tasks["t1"]
+	task.Categories = map[string]bool{"secret": true}
+	lastState.Tasks["t1"] = task
+
+	if redacted := RedactBlock(nil, block, nil); redacted.State.Tasks["t1"].Title != "later secret" {
+		t.Errorf("expected task not protected by the block kept")
+	}
+	redacted := RedactBlock(nil, block, lastState.ProtectedTaskIds())
+	if title := redacted.Updates.Transitions[0].Params.(*CreateTaskParams).Title; title != "" {
+		t.Errorf("expected title of task protected later redacted from transition, got %q", title)
+	}
+	if title := redacted.State.Tasks["t1"].Title; title != "" {
+		t.Errorf("expected title of task protected later redacted from state, got %q", title)
+	}
+	if redacted.Updates.SrcTx.Content != nil {
+		t.Errorf("expected content of source transaction dropped")
+	}
+	if title := newState.RedactWith(lastState.ProtectedTaskIds()).Tasks["t1"].Title; title != "" {
+		t.Errorf("expected title of task protected later redacted from historic state, got %q", title)
+	}
+}
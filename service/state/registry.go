@@ -0,0 +1,202 @@
+package state
+
+import (
+	"fmt"
+	"github.com/goccy/go-json"
+	"memorial_app_server/util"
+	"sort"
+	"time"
+)
+
+// TxContext carries chain (and user) information needed by some transactions
+type TxContext struct {
+	NewBlockNumber int64
+	Blocks         BlockReader
+	Location       *time.Location // timezone of user
+	// Now is the server time transaction is executed at, stored with its block so re-execution gives the same transitions
+	Now time.Time
+}
+
+// TxSpec describes how a transaction type is decoded, validated and executed
+type TxSpec struct {
+	Type     int64
+	Name     string
+	Decode   func(content interface{}) (interface{}, error)
+	Validate func(body interface{}) error
+	Execute  func(state *State, tx *Transaction, body interface{}, ctx *TxContext) (*Updates, error)
+}
+
+// OpSpec describes how a transition operation is decoded, executed and inverted
+type OpSpec struct {
+	Operation int64
+	Name      string
+	Unmarshal func(raw []byte) (interface{}, error)
+	Decode    func(params interface{}) (interface{}, error)
+	Execute   func(state *State, params interface{}) (*State, error)
+	Inverse   func(state *State, params interface{}) (Transitions, error)
+}
+
+var (
+	txRegistry = map[int64]*TxSpec{}
+	opRegistry = map[int64]*OpSpec{}
+)
+
+// UnsupportedTxTypeError is returned for transaction types not registered
+type UnsupportedTxTypeError struct {
+	Type      int64   `json:"type"`
+	Supported []int64 `json:"supported"`
+}
+
+func (e *UnsupportedTxTypeError) Error() string {
+	return fmt.Sprintf("%s %d: supported types are %v", ErrInvalidTxType.Error(), e.Type, e.Supported)
+}
+
+func (e *UnsupportedTxTypeError) Unwrap() error {
+	return ErrInvalidTxType
+}
+
+// decodeAs converts raw value (e.g. decoded JSON) to *B, already typed value is used as it is
+func decodeAs[B any](raw interface{}) (*B, error) {
+	if typed, ok := raw.(*B); ok {
+		return typed, nil
+	}
+	if raw == nil {
+		return new(B), nil
+	}
+	decoded := new(B)
+	if err := util.InterfaceToStruct(raw, decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// registerTx registers transaction type, validate can be nil
+func registerTx[B any](
+	txType int64,
+	name string,
+	validate func(body *B) error,
+	execute func(state *State, tx *Transaction, body *B, ctx *TxContext) (*Updates, error),
+) {
+	if _, exists := txRegistry[txType]; exists {
+		panic(fmt.Sprintf("transaction type %d is already registered", txType))
+	}
+	spec := &TxSpec{
+		Type: txType,
+		Name: name,
+		Decode: func(content interface{}) (interface{}, error) {
+			return decodeAs[B](content)
+		},
+		Execute: func(state *State, tx *Transaction, body interface{}, ctx *TxContext) (*Updates, error) {
+			return execute(state, tx, body.(*B), ctx)
+		},
+	}
+	if validate != nil {
+		spec.Validate = func(body interface{}) error {
+			return validate(body.(*B))
+		}
+	}
+	txRegistry[txType] = spec
+}
+
+// registerSimpleTx registers transaction type which doesn't need TxContext
+func registerSimpleTx[B any](
+	txType int64,
+	name string,
+	validate func(body *B) error,
+	execute func(state *State, tx *Transaction, body *B) (*Updates, error),
+) {
+	registerTx(txType, name, validate, func(state *State, tx *Transaction, body *B, _ *TxContext) (*Updates, error) {
+		return execute(state, tx, body)
+	})
+}
+
+// registerOp registers transition operation
+func registerOp[P any](
+	operation int64,
+	name string,
+	execute func(state *State, params *P) (*State, error),
+	inverse func(state *State, params *P) (Transitions, error),
+) {
+	if _, exists := opRegistry[operation]; exists {
+		panic(fmt.Sprintf("operation %d is already registered", operation))
+	}
+	opRegistry[operation] = &OpSpec{
+		Operation: operation,
+		Name:      name,
+		Unmarshal: func(raw []byte) (interface{}, error) {
+			params := new(P)
+			if len(raw) == 0 {
+				return params, nil
+			}
+			if err := json.Unmarshal(raw, params); err != nil {
+				return nil, err
+			}
+			return params, nil
+		},
+		Decode: func(params interface{}) (interface{}, error) {
+			return decodeAs[P](params)
+		},
+		Execute: func(state *State, params interface{}) (*State, error) {
+			return execute(state, params.(*P))
+		},
+		Inverse: func(state *State, params interface{}) (Transitions, error) {
+			return inverse(state, params.(*P))
+		},
+	}
+}
+
+func GetTxSpec(txType int64) (*TxSpec, error) {
+	spec, ok := txRegistry[txType]
+	if !ok {
+		return nil, &UnsupportedTxTypeError{Type: txType, Supported: SupportedTxTypes()}
+	}
+	return spec, nil
+}
+
+func GetOpSpec(operation int64) (*OpSpec, error) {
+	spec, ok := opRegistry[operation]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation: %d", operation)
+	}
+	return spec, nil
+}
+
+// SupportedTxTypes returns registered transaction types in ascending order
+func SupportedTxTypes() []int64 {
+	types := make([]int64, 0, len(txRegistry))
+	for txType := range txRegistry {
+		types = append(types, txType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+type CapabilityEntry struct {
+	Code int64  `json:"code"`
+	Name string `json:"name"`
+}
+
+type Capabilities struct {
+	SchemeVersion int               `json:"schemeVersion"`
+	Transactions  []CapabilityEntry `json:"transactions"`
+	Operations    []CapabilityEntry `json:"operations"`
+}
+
+// GetCapabilities lists transaction types and operations supported by server
+func GetCapabilities() *Capabilities {
+	capabilities := &Capabilities{
+		SchemeVersion: SchemeVersion,
+		Transactions:  make([]CapabilityEntry, 0, len(txRegistry)),
+		Operations:    make([]CapabilityEntry, 0, len(opRegistry)),
+	}
+	for _, txType := range SupportedTxTypes() {
+		capabilities.Transactions = append(capabilities.Transactions, CapabilityEntry{Code: txType, Name: txRegistry[txType].Name})
+	}
+	for _, spec := range opRegistry {
+		capabilities.Operations = append(capabilities.Operations, CapabilityEntry{Code: spec.Operation, Name: spec.Name})
+	}
+	sort.Slice(capabilities.Operations, func(i, j int) bool {
+		return capabilities.Operations[i].Code < capabilities.Operations[j].Code
+	})
+	return capabilities
+}
@@ -0,0 +1,84 @@
+package state
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_UnsupportedTxType(t *testing.T) {
+	tx := NewTransaction(SchemeVersion, "uid", 99999, 0, nil, "")
+	_, err := PreExecuteTransaction(NewState(), tx, 1, nil, nil)
+
+	var unsupported *UnsupportedTxTypeError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("expected UnsupportedTxTypeError, got %v", err)
+	}
+	if !errors.Is(err, ErrInvalidTxType) {
+		t.Errorf("expected error to wrap ErrInvalidTxType")
+	}
+	if len(unsupported.Supported) != len(txRegistry) {
+		t.Errorf("expected %d supported types, got %d", len(txRegistry), len(unsupported.Supported))
+	}
+}
+
+// declaredConstants returns integer constants declared in file whose names start with prefix
+func declaredConstants(t *testing.T, filename string, prefix string) map[string]int64 {
+	file, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants := make(map[string]int64)
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for i, name := range valueSpec.Names {
+				if !strings.HasPrefix(name.Name, prefix) || i >= len(valueSpec.Values) {
+					continue
+				}
+				literal, ok := valueSpec.Values[i].(*ast.BasicLit)
+				if !ok || literal.Kind != token.INT {
+					continue
+				}
+				value, err := strconv.ParseInt(literal.Value, 0, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				constants[name.Name] = value
+			}
+		}
+	}
+	return constants
+}
+
+func TestRegistry_AllOperationsRegistered(t *testing.T) {
+	operations := declaredConstants(t, "transition.go", "Op")
+	for name, operation := range operations {
+		if _, err := GetOpSpec(operation); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if len(opRegistry) != len(operations) {
+		t.Errorf("expected %d registered operations, got %d", len(operations), len(opRegistry))
+	}
+}
+
+func TestRegistry_AllTransactionTypesRegistered(t *testing.T) {
+	txTypes := declaredConstants(t, "execute.go", "Tx")
+	for name, txType := range txTypes {
+		if _, err := GetTxSpec(txType); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if len(txRegistry) != len(txTypes) {
+		t.Errorf("expected %d registered transaction types, got %d", len(txTypes), len(txRegistry))
+	}
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxReminders is the maximum number of reminders of a task
+	MaxReminders = 5
+	// MaxReminderOffset is the maximum offset of reminder before due date in minutes (4 weeks)
+	MaxReminderOffset = 4 * 7 * 24 * 60
+)
+
+// ReminderTime is a moment to remind task of
+type ReminderTime struct {
+	Offset int64 `json:"offset"` // minutes before due date
+	FireAt int64 `json:"fireAt"` // unix milliseconds
+}
+
+func validateReminders(reminders []int64) error {
+	if len(reminders) > MaxReminders {
+		return fmt.Errorf("too many reminders: %d (max %d)", len(reminders), MaxReminders)
+	}
+	seen := make(map[int64]bool, len(reminders))
+	for _, offset := range reminders {
+		if offset < 0 || offset > MaxReminderOffset {
+			return fmt.Errorf("invalid reminder offset: %d minutes", offset)
+		}
+		if seen[offset] {
+			return fmt.Errorf("duplicated reminder offset: %d minutes", offset)
+		}
+		seen[offset] = true
+	}
+	return nil
+}
+
+// normalizeReminders sorts reminder offsets from the earliest reminder, empty ones are nil
+func normalizeReminders(reminders []int64) []int64 {
+	if len(reminders) == 0 {
+		return nil
+	}
+	normalized := make([]int64, len(reminders))
+	copy(normalized, reminders)
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i] > normalized[j] })
+	return normalized
+}
+
+// ReminderTimes returns moments to remind undone task of its due date.
+// all-day due date is reminded relative to the midnight of the date in user's timezone.
+func (t *Task) ReminderTimes(location *time.Location) []ReminderTime {
+	if t.Done || t.DueDate == 0 || len(t.Reminders) == 0 {
+		return nil
+	}
+	if location == nil {
+		location = time.Local
+	}
+
+	dueDate := time.UnixMilli(t.DueDate)
+	if t.AllDay {
+		year, month, day := dueDate.UTC().Date()
+		dueDate = time.Date(year, month, day, 0, 0, 0, 0, location)
+	}
+
+	times := make([]ReminderTime, 0, len(t.Reminders))
+	for _, offset := range t.Reminders {
+		times = append(times, ReminderTime{
+			Offset: offset,
+			FireAt: dueDate.Add(-time.Duration(offset) * time.Minute).UnixMilli(),
+		})
+	}
+	return times
+}
@@ -0,0 +1,26 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTask_ReminderTimes(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// all-day due date is reminded before the midnight of the date in user's timezone
+	task := Task{Id: "t1", DueDate: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC).UnixMilli(), AllDay: true, Reminders: []int64{60}}
+	times := task.ReminderTimes(seoul)
+	if expected := time.Date(2024, 3, 8, 23, 0, 0, 0, seoul).UnixMilli(); len(times) != 1 || times[0].FireAt != expected {
+		t.Errorf("expected reminder at %s, got %v", time.UnixMilli(expected).In(seoul), times)
+	}
+
+	for _, reminders := range [][]int64{{-1}, {MaxReminderOffset + 1}, {10, 10}, {1, 2, 3, 4, 5, 6}} {
+		if err := validateReminders(reminders); err == nil {
+			t.Errorf("%v: expected invalid reminders", reminders)
+		}
+	}
+}
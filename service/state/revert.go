@@ -0,0 +1,468 @@
+package state
+
+import "fmt"
+
+// BlockReader provides blocks referenced by transactions (e.g. reverting)
+type BlockReader interface {
+	GetBlockByNumber(number int64) (*Block, error)
+}
+
+// RevertConflictError is returned when later blocks make the inverse of a block impossible
+type RevertConflictError struct {
+	BlockNumber         int64  `json:"blockNumber"`
+	ConflictBlockNumber int64  `json:"conflictBlockNumber"`
+	Entity              string `json:"entity,omitempty"`
+}
+
+func (e *RevertConflictError) Error() string {
+	return fmt.Sprintf("block #%d can't be reverted: %s is changed by block #%d", e.BlockNumber, e.Entity, e.ConflictBlockNumber)
+}
+
+func RevertBlock(state *State, tx *Transaction, body *TxRevertBlockBody, ctx *TxContext) (*Updates, error) {
+	updates := NewUpdates(tx)
+	blocks := ctx.Blocks
+
+	lastBlockNumber := ctx.NewBlockNumber - 1
+	if body.BlockNumber < 1 || body.BlockNumber > lastBlockNumber {
+		return nil, fmt.Errorf("invalid block number to revert: %d", body.BlockNumber)
+	}
+	if blocks == nil {
+		return nil, fmt.Errorf("blocks are not accessible to revert block #%d", body.BlockNumber)
+	}
+
+	target, err := blocks.GetBlockByNumber(body.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if target.Updates == nil {
+		return nil, fmt.Errorf("block #%d has no transitions", body.BlockNumber)
+	}
+
+	// entities changed by target block shouldn't be changed by later blocks
+	touched, err := target.Updates.Transitions.TouchedEntities()
+	if err != nil {
+		return nil, err
+	}
+	for number := body.BlockNumber + 1; number <= lastBlockNumber; number++ {
+		block, err := blocks.GetBlockByNumber(number)
+		if err != nil {
+			return nil, err
+		}
+		if block.Updates == nil {
+			continue
+		}
+		applied, err := block.Updates.Transitions.TouchedEntities()
+		if err != nil {
+			return nil, err
+		}
+		if entity, conflict := findConflict(touched, applied); conflict {
+			return nil, &RevertConflictError{
+				BlockNumber:         body.BlockNumber,
+				ConflictBlockNumber: number,
+				Entity:              entity,
+			}
+		}
+	}
+
+	prevBlock, err := blocks.GetBlockByNumber(body.BlockNumber - 1)
+	if err != nil {
+		return nil, err
+	}
+	inverse, err := target.Updates.Transitions.Inverse(prevBlock.State)
+	if err != nil {
+		return nil, err
+	}
+	updates.Transitions = inverse
+
+	return updates, nil
+}
+
+// Inverse returns transitions that undo t, applied on prevState
+func (t Transitions) Inverse(prevState *State) (Transitions, error) {
+	// collect inverse of each transition on the state right before it
+	inverses := make([]Transitions, len(t))
+	state := prevState
+	for i, transition := range t {
+		inverse, err := transition.Inverse(state)
+		if err != nil {
+			return nil, err
+		}
+		inverses[i] = inverse
+
+		state, err = transition.ExecuteTransition(state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// undo from the last transition
+	result := NewTransitions()
+	for i := len(inverses) - 1; i >= 0; i-- {
+		result = append(result, inverses[i]...)
+	}
+	return result, nil
+}
+
+// Inverse returns transitions that undo t, applied on state (the state right before t)
+func (t *Transition) Inverse(state *State) (Transitions, error) {
+	spec, err := GetOpSpec(t.Operation)
+	if err != nil {
+		return nil, fmt.Errorf("operation %d can't be reverted: %w", t.Operation, err)
+	}
+	params, err := spec.Decode(t.Params)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Inverse(state, params)
+}
+
+func invertDeleteAll(state *State, _ *DeleteAllParams) (Transitions, error) {
+	inverse := &Updates{Transitions: NewTransitions()}
+	inverse.add(OpDeleteAll, nil)
+	for _, category := range state.Categories {
+		addCategoryCreation(inverse, category)
+	}
+	for _, task := range state.Tasks {
+		addTaskCreation(inverse, task)
+	}
+	return inverse.Transitions, nil
+}
+
+func invertCreateTask(state *State, params *CreateTaskParams) (Transitions, error) {
+	inverse := &Updates{Transitions: NewTransitions()}
+	if task, ok := state.Tasks[params.Id]; ok {
+		addTaskCreation(inverse, task)
+	} else {
+		inverse.add(OpDeleteTask, &DeleteTaskParams{Id: params.Id})
+	}
+	return inverse.Transitions, nil
+}
+
+func invertDeleteTask(state *State, params *DeleteTaskParams) (Transitions, error) {
+	task, ok := state.Tasks[params.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	inverse := &Updates{Transitions: NewTransitions()}
+	addTaskCreation(inverse, task)
+	return inverse.Transitions, nil
+}
+
+// invertTaskField returns single transition restoring a field of the task
+func invertTaskField(state *State, taskId string, operation int64, restore func(task Task) interface{}) (Transitions, error) {
+	task, ok := state.Tasks[taskId]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return Transitions{{Operation: operation, Params: restore(task)}}, nil
+}
+
+func invertUpdateTaskNext(state *State, params *UpdateTaskNextParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskNext, func(task Task) interface{} {
+		return &UpdateTaskNextParams{Id: task.Id, Next: task.Next}
+	})
+}
+
+func invertUpdateTaskTitle(state *State, params *UpdateTaskTitleParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskTitle, func(task Task) interface{} {
+		return &UpdateTaskTitleParams{Id: task.Id, Title: task.Title}
+	})
+}
+
+func invertUpdateTaskDueDate(state *State, params *UpdateTaskDueDateParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskDueDate, func(task Task) interface{} {
+		return &UpdateTaskDueDateParams{Id: task.Id, DueDate: task.DueDate, AllDay: task.AllDay}
+	})
+}
+
+func invertUpdateTaskMemo(state *State, params *UpdateTaskMemoParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskMemo, func(task Task) interface{} {
+		return &UpdateTaskMemoParams{Id: task.Id, Memo: task.Memo}
+	})
+}
+
+func invertUpdateTaskDone(state *State, params *UpdateTaskDoneParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskDone, func(task Task) interface{} {
+		return &UpdateTaskDoneParams{Id: task.Id, Done: task.Done}
+	})
+}
+
+func invertUpdateTaskDoneAt(state *State, params *UpdateTaskDoneAtParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskDoneAt, func(task Task) interface{} {
+		return &UpdateTaskDoneAtParams{Id: task.Id, DoneAt: task.DoneAt}
+	})
+}
+
+func invertUpdateTaskRepeatPeriod(state *State, params *UpdateTaskRepeatPeriodParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskRepeatPeriod, func(task Task) interface{} {
+		return &UpdateTaskRepeatPeriodParams{Id: task.Id, RepeatPeriod: task.RepeatPeriod}
+	})
+}
+
+func invertUpdateTaskRepeatStartAt(state *State, params *UpdateTaskRepeatStartAtParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskRepeatStartAt, func(task Task) interface{} {
+		return &UpdateTaskRepeatStartAtParams{Id: task.Id, RepeatStartAt: task.RepeatStartAt}
+	})
+}
+
+func invertUpdateTaskRepeatMode(state *State, params *UpdateTaskRepeatModeParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskRepeatMode, func(task Task) interface{} {
+		return &UpdateTaskRepeatModeParams{Id: task.Id, RepeatMode: task.RepeatMode}
+	})
+}
+
+func invertAddTaskOccurrence(state *State, params *AddTaskOccurrenceParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskOccurrences, func(task Task) interface{} {
+		return &UpdateTaskOccurrencesParams{Id: task.Id, Occurrences: task.Occurrences}
+	})
+}
+
+func invertUpdateTaskOccurrences(state *State, params *UpdateTaskOccurrencesParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskOccurrences, func(task Task) interface{} {
+		return &UpdateTaskOccurrencesParams{Id: task.Id, Occurrences: task.Occurrences}
+	})
+}
+
+func invertUpdateTaskReminders(state *State, params *UpdateTaskRemindersParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskReminders, func(task Task) interface{} {
+		return &UpdateTaskRemindersParams{Id: task.Id, Reminders: task.Reminders}
+	})
+}
+
+func invertUpdateTaskPriority(state *State, params *UpdateTaskPriorityParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskPriority, func(task Task) interface{} {
+		return &UpdateTaskPriorityParams{Id: task.Id, Priority: task.Priority}
+	})
+}
+
+func invertUpdateTaskTags(state *State, params *UpdateTaskTagsParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskTags, func(task Task) interface{} {
+		return &UpdateTaskTagsParams{Id: task.Id, Tags: task.Tags}
+	})
+}
+
+func invertUpdateTaskEffort(state *State, params *UpdateTaskEffortParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskEffort, func(task Task) interface{} {
+		return &UpdateTaskEffortParams{Id: task.Id, Effort: task.Effort}
+	})
+}
+
+func invertUpdateTaskRescheduledFrom(state *State, params *UpdateTaskRescheduledFromParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateTaskRescheduledFrom, func(task Task) interface{} {
+		return &UpdateTaskRescheduledFromParams{Id: task.Id, RescheduledFrom: task.RescheduledFrom}
+	})
+}
+
+func invertCreateTaskCategory(state *State, params *CreateTaskCategoryParams) (Transitions, error) {
+	task, ok := state.Tasks[params.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	if task.Categories[params.CategoryId] {
+		return NewTransitions(), nil
+	}
+	return Transitions{{Operation: OpDeleteTaskCategory, Params: &DeleteTaskCategoryParams{Id: task.Id, CategoryId: params.CategoryId}}}, nil
+}
+
+func invertDeleteTaskCategory(state *State, params *DeleteTaskCategoryParams) (Transitions, error) {
+	task, ok := state.Tasks[params.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	if !task.Categories[params.CategoryId] {
+		return NewTransitions(), nil
+	}
+	return Transitions{{Operation: OpCreateTaskCategory, Params: &CreateTaskCategoryParams{Id: task.Id, CategoryId: params.CategoryId}}}, nil
+}
+
+func invertCreateSubtask(state *State, params *CreateSubtaskParams) (Transitions, error) {
+	task, ok := state.Tasks[params.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	inverse := &Updates{Transitions: NewTransitions()}
+	if subtask, ok := task.Subtasks[params.SubtaskId]; ok {
+		addSubtaskCreation(inverse, task.Id, subtask)
+	} else {
+		inverse.add(OpDeleteSubtask, &DeleteSubtaskParams{Id: task.Id, SubtaskId: params.SubtaskId})
+	}
+	return inverse.Transitions, nil
+}
+
+// restoreSubtask returns transition recreating the subtask as it is in state
+func restoreSubtask(state *State, taskId, subtaskId string) (Transitions, error) {
+	task, ok := state.Tasks[taskId]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	subtask, ok := task.Subtasks[subtaskId]
+	if !ok {
+		return nil, ErrSubtaskNotFound
+	}
+	inverse := &Updates{Transitions: NewTransitions()}
+	addSubtaskCreation(inverse, task.Id, subtask)
+	return inverse.Transitions, nil
+}
+
+func invertDeleteSubtask(state *State, params *DeleteSubtaskParams) (Transitions, error) {
+	inverse, err := restoreSubtask(state, params.Id, params.SubtaskId)
+	if err != nil {
+		return nil, err
+	}
+	// recreated subtask goes last, put it back in place
+	if task := state.Tasks[params.Id]; len(task.SubtaskOrder) > 0 {
+		inverse = append(inverse, Transition{Operation: OpUpdateSubtaskOrder, Params: &UpdateSubtaskOrderParams{Id: task.Id, Order: task.SubtaskOrder}})
+	}
+	return inverse, nil
+}
+
+func invertUpdateSubtaskTitle(state *State, params *UpdateSubtaskTitleParams) (Transitions, error) {
+	return restoreSubtask(state, params.Id, params.SubtaskId)
+}
+
+func invertUpdateSubtaskDueDate(state *State, params *UpdateSubtaskDueDateParams) (Transitions, error) {
+	return restoreSubtask(state, params.Id, params.SubtaskId)
+}
+
+func invertUpdateSubtaskDone(state *State, params *UpdateSubtaskDoneParams) (Transitions, error) {
+	return restoreSubtask(state, params.Id, params.SubtaskId)
+}
+
+func invertUpdateSubtaskDoneAt(state *State, params *UpdateSubtaskDoneAtParams) (Transitions, error) {
+	return restoreSubtask(state, params.Id, params.SubtaskId)
+}
+
+func invertUpdateSubtaskOrder(state *State, params *UpdateSubtaskOrderParams) (Transitions, error) {
+	return invertTaskField(state, params.Id, OpUpdateSubtaskOrder, func(task Task) interface{} {
+		return &UpdateSubtaskOrderParams{Id: task.Id, Order: task.SubtaskOrder}
+	})
+}
+
+func invertCreateCategory(state *State, params *CreateCategoryParams) (Transitions, error) {
+	inverse := &Updates{Transitions: NewTransitions()}
+	if category, ok := state.Categories[params.Id]; ok {
+		addCategoryCreation(inverse, category)
+	} else {
+		inverse.add(OpDeleteCategory, &DeleteCategoryParams{Id: params.Id})
+	}
+	return inverse.Transitions, nil
+}
+
+func invertDeleteCategory(state *State, params *DeleteCategoryParams) (Transitions, error) {
+	category, ok := state.Categories[params.Id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+	inverse := &Updates{Transitions: NewTransitions()}
+	addCategoryCreation(inverse, category)
+	return inverse.Transitions, nil
+}
+
+// invertCategoryField returns transition restoring a field of category to its value in state
+func invertCategoryField(state *State, categoryId string, operation int64, restore func(category Category) interface{}) (Transitions, error) {
+	category, ok := state.Categories[categoryId]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+	return Transitions{{Operation: operation, Params: restore(category)}}, nil
+}
+
+func invertUpdateCategoryColor(state *State, params *UpdateCategoryColorParams) (Transitions, error) {
+	return invertCategoryField(state, params.Id, OpUpdateCategoryColor, func(category Category) interface{} {
+		return &UpdateCategoryColorParams{Id: category.Id, Color: category.Color}
+	})
+}
+
+func invertUpdateCategoryTitle(state *State, params *UpdateCategoryTitleParams) (Transitions, error) {
+	return invertCategoryField(state, params.Id, OpUpdateCategoryTitle, func(category Category) interface{} {
+		return &UpdateCategoryTitleParams{Id: category.Id, Title: category.Title}
+	})
+}
+
+func invertUpdateCategorySecret(state *State, params *UpdateCategorySecretParams) (Transitions, error) {
+	return invertCategoryField(state, params.Id, OpUpdateCategorySecret, func(category Category) interface{} {
+		return &UpdateCategorySecretParams{Id: category.Id, Secret: category.Secret}
+	})
+}
+
+func invertUpdateCategoryLocked(state *State, params *UpdateCategoryLockedParams) (Transitions, error) {
+	return invertCategoryField(state, params.Id, OpUpdateCategoryLocked, func(category Category) interface{} {
+		return &UpdateCategoryLockedParams{Id: category.Id, Locked: category.Locked}
+	})
+}
+
+func invertUpdateCategoryKey(state *State, params *UpdateCategoryKeyParams) (Transitions, error) {
+	return invertCategoryField(state, params.Id, OpUpdateCategoryKey, func(category Category) interface{} {
+		return &UpdateCategoryKeyParams{Id: category.Id, KeyId: category.KeyId}
+	})
+}
+
+// addTaskCreation adds transitions to create task as it is (with subtasks and order)
+func addTaskCreation(updates *Updates, task Task) {
+	categories := make(map[string]bool)
+	for categoryId := range task.Categories {
+		categories[categoryId] = true
+	}
+	updates.add(OpCreateTask, &CreateTaskParams{
+		Id:            task.Id,
+		Title:         task.Title,
+		CreatedAt:     task.CreatedAt,
+		DoneAt:        task.DoneAt,
+		Memo:          task.Memo,
+		Done:          task.Done,
+		DueDate:       task.DueDate,
+		AllDay:        task.AllDay,
+		Reminders:     task.Reminders,
+		Priority:      task.Priority,
+		Tags:          task.Tags,
+		Effort:        task.Effort,
+		RepeatPeriod:  task.RepeatPeriod,
+		RepeatStartAt: task.RepeatStartAt,
+		Categories:    categories,
+
+		RepeatMode:      task.RepeatMode,
+		RescheduledFrom: task.RescheduledFrom,
+		Occurrences:     task.Occurrences,
+	})
+	updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{
+		Id:   task.Id,
+		Next: task.Next,
+	})
+	for _, subtask := range task.Subtasks {
+		addSubtaskCreation(updates, task.Id, subtask)
+	}
+	addSubtaskOrder(updates, task)
+}
+
+// addSubtaskOrder adds transition to restore order of subtasks created, if they have been reordered
+func addSubtaskOrder(updates *Updates, task Task) {
+	if len(task.SubtaskOrder) > 0 {
+		updates.add(OpUpdateSubtaskOrder, &UpdateSubtaskOrderParams{Id: task.Id, Order: task.SubtaskOrder})
+	}
+}
+
+// addSubtaskCreation adds transition to create (or overwrite) subtask as it is
+func addSubtaskCreation(updates *Updates, taskId string, subtask Subtask) {
+	updates.add(OpCreateSubtask, &CreateSubtaskParams{
+		Id:        taskId,
+		SubtaskId: subtask.Id,
+		Title:     subtask.Title,
+		CreatedAt: subtask.CreatedAt,
+		DueDate:   subtask.DueDate,
+		Done:      subtask.Done,
+		DoneAt:    subtask.DoneAt,
+	})
+}
+
+// addCategoryCreation adds transition to create (or overwrite) category as it is
+func addCategoryCreation(updates *Updates, category Category) {
+	updates.add(OpCreateCategory, &CreateCategoryParams{
+		Id:        category.Id,
+		Title:     category.Title,
+		Secret:    category.Secret,
+		Locked:    category.Locked,
+		Color:     category.Color,
+		CreatedAt: category.CreatedAt,
+		KeyId:     category.KeyId,
+	})
+}
@@ -0,0 +1,153 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTransitions_Inverse(t *testing.T) {
+	prevState := NewState()
+	prevState.Categories["c1"] = Category{Id: "c1", Title: "category", Color: "red"}
+	prevState.Tasks["t1"] = Task{
+		Id:         "t1",
+		Title:      "before",
+		Subtasks:   map[string]Subtask{"s1": {Id: "s1", Title: "subtask"}},
+		Categories: map[string]bool{},
+	}
+
+	updates := NewUpdates(nil)
+	updates.add(OpCreateTask, &CreateTaskParams{Id: "t2", Title: "new", Categories: map[string]bool{"c1": true}})
+	updates.add(OpUpdateTaskNext, &UpdateTaskNextParams{Id: "t1", Next: "t2"})
+	updates.add(OpUpdateTaskTitle, &UpdateTaskTitleParams{Id: "t1", Title: "after"})
+	updates.add(OpUpdateTaskPriority, &UpdateTaskPriorityParams{Id: "t1", Priority: PriorityHigh})
+	updates.add(OpUpdateTaskTags, &UpdateTaskTagsParams{Id: "t1", Tags: []string{"work"}})
+	updates.add(OpUpdateTaskEffort, &UpdateTaskEffortParams{Id: "t1", Effort: 30})
+	updates.add(OpDeleteSubtask, &DeleteSubtaskParams{Id: "t1", SubtaskId: "s1"})
+	updates.add(OpCreateTaskCategory, &CreateTaskCategoryParams{Id: "t1", CategoryId: "c1"})
+	updates.add(OpUpdateCategoryColor, &UpdateCategoryColorParams{Id: "c1", Color: "blue"})
+	updates.add(OpUpdateCategoryTitle, &UpdateCategoryTitleParams{Id: "c1", Title: "renamed"})
+	updates.add(OpUpdateCategorySecret, &UpdateCategorySecretParams{Id: "c1", Secret: true})
+	updates.add(OpUpdateCategoryLocked, &UpdateCategoryLockedParams{Id: "c1", Locked: true})
+
+	newState, err := updates.ApplyTransitions(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inverse, err := updates.Transitions.Inverse(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := NewUpdatesWithTransitions(nil, inverse).ApplyTransitions(newState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reverted, prevState) {
+		t.Errorf("expected %+v, got %+v", prevState, reverted)
+	}
+}
+
+func TestTransitions_InverseDeleteAll(t *testing.T) {
+	prevState := NewState()
+	prevState.Tasks["t1"] = Task{Id: "t1", Title: "task", Subtasks: map[string]Subtask{}, Categories: map[string]bool{}}
+
+	updates := NewUpdates(nil)
+	updates.add(OpDeleteAll, nil)
+
+	newState, err := updates.ApplyTransitions(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inverse, err := updates.Transitions.Inverse(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := NewUpdatesWithTransitions(nil, inverse).ApplyTransitions(newState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reverted, prevState) {
+		t.Errorf("expected %+v, got %+v", prevState, reverted)
+	}
+}
+
+// blockMap reads blocks from a map
+type blockMap map[int64]*Block
+
+func (m blockMap) GetBlockByNumber(number int64) (*Block, error) {
+	if block, ok := m[number]; ok {
+		return block, nil
+	}
+	return nil, fmt.Errorf("block #%d not found", number)
+}
+
+func TestRevertBlock(t *testing.T) {
+	// blocks #4 and #7 rename t0
+	chain := testChain("uid", newMemoryChainStore())
+	applyTestTxs(t, chain, 7)
+	lastState := chain.GetLastState()
+	tx := testTx(TxRevertBlock, 100, nil)
+
+	var conflict *RevertConflictError
+	_, err := RevertBlock(lastState, tx, &TxRevertBlockBody{BlockNumber: 4}, &TxContext{NewBlockNumber: 8, Blocks: chain})
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected revert conflict, got %v", err)
+	}
+	if conflict.ConflictBlockNumber != 7 || conflict.Entity != "task:t0" {
+		t.Errorf("expected conflict with task:t0 of block #7, got %+v", conflict)
+	}
+
+	updates, err := RevertBlock(lastState, tx, &TxRevertBlockBody{BlockNumber: 7}, &TxContext{NewBlockNumber: 8, Blocks: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := updates.ApplyTransitions(lastState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := reverted.Tasks["t0"].Title; title != "title 3" {
+		t.Errorf("expected title of block #4 back, got %s", title)
+	}
+}
+
+func TestRevertBlock_InvalidBlockNumber(t *testing.T) {
+	chain := testChain("uid", newMemoryChainStore())
+	applyTestTxs(t, chain, 3)
+	tx := testTx(TxRevertBlock, 100, nil)
+
+	for _, number := range []int64{-1, 0, 4} {
+		if _, err := RevertBlock(chain.GetLastState(), tx, &TxRevertBlockBody{BlockNumber: number}, &TxContext{NewBlockNumber: 4, Blocks: chain}); err == nil {
+			t.Errorf("expected reverting block #%d to fail", number)
+		}
+	}
+}
+
+func TestRevertBlock_LaterBlockWithoutUpdates(t *testing.T) {
+	prevState := NewState()
+	target := NewUpdates(testTx(TxCreateTask, 1, nil))
+	target.add(OpCreateTask, &CreateTaskParams{Id: "t1", Title: "task", Categories: map[string]bool{}})
+	state, err := target.ApplyTransitions(prevState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := blockMap{
+		0: NewBlock(0, prevState, nil, ""),
+		1: NewBlock(1, state, target, ""),
+		2: NewBlock(2, state, nil, ""),
+	}
+
+	updates, err := RevertBlock(state, nil, &TxRevertBlockBody{BlockNumber: 1}, &TxContext{NewBlockNumber: 3, Blocks: blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := updates.ApplyTransitions(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reverted.Tasks["t1"]; ok {
+		t.Errorf("expected created task reverted")
+	}
+}
@@ -1,7 +1,6 @@
 package state
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/awalterschulze/gographviz"
@@ -14,12 +13,16 @@ import (
 type State struct {
 	Tasks      map[string]Task     `json:"tasks"`
 	Categories map[string]Category `json:"categories"`
+
+	// leaf hashes of merkle tree, nil if not cached (e.g. state made as a literal)
+	merkle *merkleCache
 }
 
 func NewState() *State {
 	return &State{
 		Tasks:      make(map[string]Task),
 		Categories: make(map[string]Category),
+		merkle:     newMerkleCache(),
 	}
 }
 
@@ -167,21 +170,40 @@ func (s *State) Validate() error {
 
 	// check if tasks' categories exists
 	for _, task := range s.Tasks {
-		for categoryId, category := range task.Categories {
+		for categoryId := range task.Categories {
 			_, exists := s.Categories[categoryId]
 			if !exists {
-				return fmt.Errorf("task %s has non-existing category %s", task.Id, category)
+				return fmt.Errorf("task %s has non-existing category %s", task.Id, categoryId)
 			}
 		}
 	}
 
+	// check if encrypted categories are secret, and texts of their tasks are envelopes of their keys
+	for _, category := range s.Categories {
+		if category.Encrypted() && !category.Secret {
+			return fmt.Errorf("category %s is encrypted but not secret", category.Id)
+		}
+	}
+	for _, task := range s.Tasks {
+		if err := validateTaskEncryption(task, s.Categories); err != nil {
+			return err
+		}
+	}
+
+	// check if subtask orders are consistent with subtasks
+	for _, task := range s.Tasks {
+		if err := validateSubtaskOrder(task, task.SubtaskOrder); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Hash returns the merkle root of state (see MerkleRoot)
 func (s *State) Hash() Hash {
-	bytes, _ := json.Marshal(s.Tasks)
-	hash := sha256.Sum256(bytes)
-	return hash
+	root, _ := s.MerkleRoot()
+	return root
 }
 
 func (s *State) Copy() *State {
@@ -195,9 +217,11 @@ func (s *State) Copy() *State {
 		copiedCategories[k] = *v.Copy()
 	}
 
+	// copy may be changed in place, so leaf hashes are not shared
 	return &State{
 		Tasks:      copiedTasks,
 		Categories: copiedCategories,
+		merkle:     newMerkleCache(),
 	}
 }
 
@@ -1,21 +1,38 @@
 package state
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+)
 
 type Task struct {
-	Id            string `json:"tid"`
-	Title         string `json:"title"`
-	CreatedAt     int64  `json:"createdAt"`
-	DoneAt        int64  `json:"doneAt"`
-	Memo          string `json:"memo"`
-	Done          bool   `json:"done"`
-	DueDate       int64  `json:"dueDate"`
-	Next          string `json:"next"`
-	RepeatPeriod  string `json:"repeatPeriod"`
-	RepeatStartAt int64  `json:"repeatStartAt"`
-
-	Subtasks   map[string]Subtask `json:"subtasks"`
-	Categories map[string]bool    `json:"categories"`
+	Id            string  `json:"tid"`
+	Title         string  `json:"title"`
+	CreatedAt     int64   `json:"createdAt"`
+	DoneAt        int64   `json:"doneAt"`
+	Memo          string  `json:"memo"`
+	Done          bool    `json:"done"`
+	DueDate       int64   `json:"dueDate"`
+	AllDay        bool    `json:"allDay,omitempty"`    // due date is a calendar date (UTC midnight), not a moment
+	Reminders     []int64 `json:"reminders,omitempty"` // minutes before due date to remind
+	Next          string  `json:"next"`
+	RepeatPeriod  string  `json:"repeatPeriod"`
+	RepeatStartAt int64   `json:"repeatStartAt"`
+	RepeatMode    string  `json:"repeatMode,omitempty"` // RepeatModeFixed (default) or RepeatModeAfterCompletion
+	// original occurrence of repeating task whose due date is rescheduled only for this occurrence
+	RescheduledFrom int64 `json:"rescheduledFrom,omitempty"`
+	// finished occurrences of repeating task, the oldest first
+	Occurrences []Occurrence `json:"occurrences,omitempty"`
+
+	Priority string   `json:"priority,omitempty"` // PriorityNone (default), PriorityLow, ... or PriorityUrgent
+	Tags     []string `json:"tags,omitempty"`
+	Effort   int64    `json:"effort,omitempty"` // estimated effort in minutes
+
+	Subtasks map[string]Subtask `json:"subtasks"`
+	// ids of subtasks in display order, nil if the subtasks have never been reordered
+	SubtaskOrder []string        `json:"subtaskOrder,omitempty"`
+	Categories   map[string]bool `json:"categories"`
 }
 
 func (t *Task) Copy() *Task {
@@ -27,18 +44,37 @@ func (t *Task) Copy() *Task {
 		Memo:          t.Memo,
 		Done:          t.Done,
 		DueDate:       t.DueDate,
+		AllDay:        t.AllDay,
+		Priority:      t.Priority,
+		Tags:          normalizeTags(t.Tags),
+		Effort:        t.Effort,
 		Next:          t.Next,
 		RepeatPeriod:  t.RepeatPeriod,
 		RepeatStartAt: t.RepeatStartAt,
+		RepeatMode:    t.RepeatMode,
+
+		RescheduledFrom: t.RescheduledFrom,
 	}
 	task.Subtasks = make(map[string]Subtask)
 	for k, v := range t.Subtasks {
 		task.Subtasks[k] = *v.Copy()
 	}
+	if t.SubtaskOrder != nil {
+		task.SubtaskOrder = make([]string, len(t.SubtaskOrder))
+		copy(task.SubtaskOrder, t.SubtaskOrder)
+	}
 	task.Categories = make(map[string]bool)
 	for k, v := range t.Categories {
 		task.Categories[k] = v
 	}
+	if t.Reminders != nil {
+		task.Reminders = make([]int64, len(t.Reminders))
+		copy(task.Reminders, t.Reminders)
+	}
+	if t.Occurrences != nil {
+		task.Occurrences = make([]Occurrence, len(t.Occurrences))
+		copy(task.Occurrences, t.Occurrences)
+	}
 
 	return task
 }
@@ -54,24 +90,90 @@ func (t *Task) CopyNew() *Task {
 		Memo:          t.Memo,
 		Done:          t.Done,
 		DueDate:       t.DueDate,
+		AllDay:        t.AllDay,
+		Priority:      t.Priority,
+		Tags:          normalizeTags(t.Tags),
+		Effort:        t.Effort,
 		Next:          t.Next,
 		RepeatPeriod:  t.RepeatPeriod,
 		RepeatStartAt: t.RepeatStartAt,
+		RepeatMode:    t.RepeatMode,
+
+		RescheduledFrom: t.RescheduledFrom,
 	}
 	task.Subtasks = make(map[string]Subtask)
+	newSubtaskIds := make(map[string]string)
 	for _, v := range t.Subtasks {
 		newSubtask := v.Copy()
 		newSubtask.Id = uuid.New().String()
 		task.Subtasks[newSubtask.Id] = *newSubtask
+		newSubtaskIds[v.Id] = newSubtask.Id
+	}
+	if t.SubtaskOrder != nil {
+		task.SubtaskOrder = make([]string, 0, len(t.SubtaskOrder))
+		for _, sid := range t.SubtaskOrder {
+			task.SubtaskOrder = append(task.SubtaskOrder, newSubtaskIds[sid])
+		}
 	}
 	task.Categories = make(map[string]bool)
 	for k, v := range t.Categories {
 		task.Categories[k] = v
 	}
+	if t.Reminders != nil {
+		task.Reminders = make([]int64, len(t.Reminders))
+		copy(task.Reminders, t.Reminders)
+	}
+	if t.Occurrences != nil {
+		task.Occurrences = make([]Occurrence, len(t.Occurrences))
+		copy(task.Occurrences, t.Occurrences)
+	}
 
 	return task
 }
 
+// SortedSubtaskIds returns ids of subtasks in display order.
+// subtasks never reordered are sorted by creation time.
+func (t *Task) SortedSubtaskIds() []string {
+	if len(t.SubtaskOrder) > 0 {
+		ids := make([]string, len(t.SubtaskOrder))
+		copy(ids, t.SubtaskOrder)
+		return ids
+	}
+	ids := make([]string, 0, len(t.Subtasks))
+	for sid := range t.Subtasks {
+		ids = append(ids, sid)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := t.Subtasks[ids[i]], t.Subtasks[ids[j]]
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.Id < b.Id
+	})
+	return ids
+}
+
+// validateSubtaskOrder checks if order has every subtask of task exactly once, empty order is not checked
+func validateSubtaskOrder(task Task, order []string) error {
+	if len(order) == 0 {
+		return nil
+	}
+	if len(order) != len(task.Subtasks) {
+		return fmt.Errorf("subtask order of task %s has %d subtasks, task has %d", task.Id, len(order), len(task.Subtasks))
+	}
+	seen := make(map[string]bool, len(order))
+	for _, sid := range order {
+		if _, ok := task.Subtasks[sid]; !ok {
+			return fmt.Errorf("subtask order of task %s has non-existing subtask %s", task.Id, sid)
+		}
+		if seen[sid] {
+			return fmt.Errorf("subtask order of task %s has duplicated subtask %s", task.Id, sid)
+		}
+		seen[sid] = true
+	}
+	return nil
+}
+
 type DirectionalTask struct {
 	Task
 	Prev string `json:"prev"`
@@ -104,6 +206,8 @@ type Category struct {
 	Locked    bool   `json:"locked"`
 	Color     string `json:"color"`
 	CreatedAt int64  `json:"createdAt"`
+	// id of client key texts of tasks in secret category are encrypted with, empty if not encrypted
+	KeyId string `json:"keyId,omitempty"`
 }
 
 func (c *Category) Copy() *Category {
@@ -114,5 +218,11 @@ func (c *Category) Copy() *Category {
 		Locked:    c.Locked,
 		Color:     c.Color,
 		CreatedAt: c.CreatedAt,
+		KeyId:     c.KeyId,
 	}
 }
+
+// Encrypted reports whether texts of tasks in category are end-to-end encrypted envelopes
+func (c *Category) Encrypted() bool {
+	return c.KeyId != ""
+}
@@ -0,0 +1,76 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"memorial_app_server/log"
+	"memorial_app_server/service/database"
+	"time"
+)
+
+var ErrInvalidTimezone = errors.New("invalid timezone")
+
+// UserLocation returns location of user's timezone, server's local timezone if not set.
+// location of user whose chain is loaded is kept with the chain, others are read from database.
+func UserLocation(userId string) *time.Location {
+	if Chains != nil {
+		if chain, ok := Chains.loadedChain(userId); ok {
+			return chain.Location()
+		}
+	}
+	return loadUserLocation(userId)
+}
+
+// loadUserLocation reads timezone of user from database
+func loadUserLocation(userId string) *time.Location {
+	if database.DB == nil {
+		return time.Local
+	}
+
+	var timezone sql.NullString
+	if err := database.DB.Get(&timezone, "SELECT timezone FROM user_master WHERE uid = ?", userId); err != nil && err != sql.ErrNoRows {
+		log.Errorf("Failed to get timezone of user %s: %v", userId, err)
+		return time.Local
+	}
+
+	location := time.Local
+	if timezone.Valid && timezone.String != "" {
+		loaded, err := time.LoadLocation(timezone.String)
+		if err != nil {
+			log.Warnf("Invalid timezone of user %s: %s", userId, timezone.String)
+		} else {
+			location = loaded
+		}
+	}
+	return location
+}
+
+// GetUserTimezone returns IANA timezone name of user, empty if not set
+func GetUserTimezone(userId string) (string, error) {
+	var timezone sql.NullString
+	if err := database.DB.Get(&timezone, "SELECT timezone FROM user_master WHERE uid = ?", userId); err != nil {
+		return "", err
+	}
+	return timezone.String, nil
+}
+
+// SetUserTimezone stores IANA timezone name (e.g. "Asia/Seoul") of user
+func SetUserTimezone(userId string, timezone string) error {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		return fmt.Errorf("%w: %s", ErrInvalidTimezone, timezone)
+	}
+
+	if _, err := database.DB.Exec("UPDATE user_master SET timezone = ? WHERE uid = ?", timezone, userId); err != nil {
+		return err
+	}
+
+	// transactions of loaded chain are executed in the new timezone from now on
+	if Chains != nil {
+		if chain, ok := Chains.loadedChain(userId); ok {
+			chain.setLocation(location)
+		}
+	}
+	return nil
+}
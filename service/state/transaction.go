@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"github.com/goccy/go-json"
+	"memorial_app_server/service/database"
 )
 
 var (
@@ -68,7 +69,21 @@ func NewTransaction(version int, from string, txType int64, timestamp int64, con
 	return tx
 }
 
-func (tx *Transaction) CalcHash() Hash {
+// TransactionFromEntity converts stored transaction, decoding its content
+func TransactionFromEntity(entity database.TransactionEntity) (*Transaction, error) {
+	var decodedContent interface{}
+	if entity.Content != nil {
+		if err := json.Unmarshal(entity.Content, &decodedContent); err != nil {
+			return nil, err
+		}
+	}
+	return NewTransaction(*entity.Version, *entity.From, *entity.Type, *entity.Timestamp, decodedContent, *entity.Hash), nil
+}
+
+// CanonicalBytes returns the bytes hashed as transaction hash.
+// Clients should produce the same bytes: JSON of version, type, timestamp and content in this order,
+// without whitespace and HTML escaping, object keys of content sorted, numbers without exponent.
+func (tx *Transaction) CanonicalBytes() []byte {
 	rawTransaction := rawTransaction{
 		Version:   tx.Version,
 		Type:      tx.Type,
@@ -80,10 +95,11 @@ func (tx *Transaction) CalcHash() Hash {
 	encoder.SetEscapeHTML(false)
 	_ = encoder.Encode(rawTransaction)
 
-	jsonBytes := buffer.Bytes()
-	jsonBytes = bytes.TrimRight(jsonBytes, "\n")
-	//log.Debugf("Transaction hash: %v", jsonBytes)
-	hash := sha256.Sum256(jsonBytes)
+	return bytes.TrimRight(buffer.Bytes(), "\n")
+}
+
+func (tx *Transaction) CalcHash() Hash {
+	hash := sha256.Sum256(tx.CanonicalBytes())
 	return hash
 }
 
@@ -96,5 +112,9 @@ func (tx *Transaction) Validate() error {
 	if tx.Timestamp < 0 {
 		return ErrInvalidTxTime
 	}
+	// type validation
+	if _, err := GetTxSpec(tx.Type); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,24 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func TestTransaction_CanonicalBytes(t *testing.T) {
+	var content interface{}
+	raw := `{"title":"<a&b>","tid":"t1","dueDate":1678000000000,"Categories":{"c2":true,"c1":true}}`
+	if err := json.Unmarshal([]byte(raw), &content); err != nil {
+		t.Fatal(err)
+	}
+	tx := NewTransaction(1, "uid", TxCreateTask, 1678000000123, content, "")
+
+	expected := `{"version":1,"type":10000,"timestamp":1678000000123,"content":{"Categories":{"c1":true,"c2":true},"dueDate":1678000000000,"tid":"t1","title":"<a&b>"}}`
+	if got := string(tx.CanonicalBytes()); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	if tx.CalcHash() != sha256.Sum256([]byte(expected)) {
+		t.Error("hash is not sha256 of canonical bytes")
+	}
+}
@@ -13,8 +13,14 @@ type TxCreateTaskBody struct {
 	Memo          string          `json:"memo"`
 	Done          bool            `json:"done"`
 	DueDate       int64           `json:"dueDate"`
+	AllDay        bool            `json:"allDay"`
+	Reminders     []int64         `json:"reminders"`
+	Priority      string          `json:"priority"`
+	Tags          []string        `json:"tags"`
+	Effort        int64           `json:"effort"`
 	RepeatPeriod  string          `json:"repeatPeriod"`
 	RepeatStartAt int64           `json:"repeatStartAt"`
+	RepeatMode    string          `json:"repeatMode"`
 	Categories    map[string]bool `json:"Categories"`
 	PrevTaskId    string          `json:"prevTaskId"`
 }
@@ -37,6 +43,7 @@ type TxUpdateTaskTitleBody struct {
 type TxUpdateTaskDueDateBody struct {
 	Id      string `json:"tid"`
 	DueDate int64  `json:"dueDate"`
+	AllDay  bool   `json:"allDay"`
 }
 
 type TxUpdateTaskMemoBody struct {
@@ -55,6 +62,44 @@ type TxUpdateTaskRepeatPeriodBody struct {
 	RepeatPeriod string `json:"repeatPeriod"`
 }
 
+type TxUpdateTaskRepeatModeBody struct {
+	TaskId     string `json:"tid"`
+	RepeatMode string `json:"repeatMode"`
+}
+
+type TxUpdateTaskRemindersBody struct {
+	TaskId    string  `json:"tid"`
+	Reminders []int64 `json:"reminders"` // minutes before due date, empty to remove
+}
+
+type TxUpdateTaskPriorityBody struct {
+	TaskId   string `json:"tid"`
+	Priority string `json:"priority"`
+}
+
+type TxUpdateTaskTagsBody struct {
+	TaskId string   `json:"tid"`
+	Tags   []string `json:"tags"` // empty to remove
+}
+
+type TxUpdateTaskEffortBody struct {
+	TaskId string `json:"tid"`
+	Effort int64  `json:"effort"` // minutes, 0 to remove
+}
+
+type TxFoldTaskClonesBody struct {
+	TaskId string `json:"tid"` // every repeating task if empty
+}
+
+type TxSkipTaskOccurrenceBody struct {
+	TaskId string `json:"tid"`
+}
+
+type TxRescheduleTaskOccurrenceBody struct {
+	TaskId  string `json:"tid"`
+	DueDate int64  `json:"dueDate"` // due date of this occurrence only
+}
+
 type TxAddTaskCategoryBody struct {
 	TaskId     string `json:"tid"`
 	CategoryId string `json:"cid"`
@@ -99,6 +144,13 @@ type TxUpdateSubtaskDoneBody struct {
 	DoneAt    int64  `json:"doneAt"`
 }
 
+type TxUpdateSubtaskOrderBody struct {
+	TaskId          string `json:"tid"`
+	SubtaskId       string `json:"sid"`
+	TargetSubtaskId string `json:"targetSubtaskId"` // 기준 subtask id
+	AfterTarget     bool   `json:"afterTarget"`     // 기준 subtask 다음에 추가할지 여부
+}
+
 type TxCreateCategoryBody struct {
 	Id        string `json:"cid"`
 	Title     string `json:"title"`
@@ -106,13 +158,60 @@ type TxCreateCategoryBody struct {
 	Locked    bool   `json:"locked"`
 	Color     string `json:"color"`
 	CreatedAt int64  `json:"createdAt"`
+	KeyId     string `json:"keyId,omitempty"` // key of end-to-end encryption, only for secret category
 }
 
 type TxDeleteCategoryBody struct {
 	Id string `json:"cid"`
+	// CategoryDeleteModeRefuse (default), CategoryDeleteModeCascade or CategoryDeleteModeReassign
+	Mode string `json:"mode,omitempty"`
+	// category given to tasks of deleted category on CategoryDeleteModeReassign
+	ReplacementId string `json:"replacementCid,omitempty"`
 }
 
 type TxUpdateCategoryColorBody struct {
 	Id    string `json:"cid"`
 	Color string `json:"color"`
 }
+
+type TxUpdateCategoryTitleBody struct {
+	Id    string `json:"cid"`
+	Title string `json:"title"`
+}
+
+type TxUpdateCategorySecretBody struct {
+	Id     string `json:"cid"`
+	Secret bool   `json:"secret"`
+}
+
+type TxUpdateCategoryLockedBody struct {
+	Id     string `json:"cid"`
+	Locked bool   `json:"locked"`
+}
+
+type TxRotateCategoryKeyBody struct {
+	Id    string `json:"cid"`
+	KeyId string `json:"keyId"` // new key, empty to store texts of category in plain
+	// texts of every task in category re-wrapped with the new key, by task id
+	Contents map[string]TaskContent `json:"contents"`
+}
+
+type TaskContent struct {
+	Title    string            `json:"title"`
+	Memo     string            `json:"memo"`
+	Tags     []string          `json:"tags"`
+	Subtasks map[string]string `json:"subtasks"` // titles by subtask id
+}
+
+type TxRevertBlockBody struct {
+	BlockNumber int64 `json:"blockNumber"`
+}
+
+type TxBatchBody struct {
+	Transactions []TxBatchItemBody `json:"transactions"`
+}
+
+type TxBatchItemBody struct {
+	Type    int64       `json:"type"`
+	Content interface{} `json:"content"`
+}
@@ -1,10 +1,9 @@
 package state
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"memorial_app_server/util"
+	"github.com/goccy/go-json"
 )
 
 var (
@@ -14,17 +13,25 @@ var (
 )
 
 const (
-	OpDeleteAll               = 0   // 모든 데이터 삭제
-	OpCreateTask              = 100 // 태스크 생성
-	OpDeleteTask              = 101 // 태스크 삭제
-	OpUpdateTaskNext          = 102 // 태스크 다음 순서 변경
-	OpUpdateTaskTitle         = 103 // 태스크 제목 변경
-	OpUpdateTaskDueDate       = 104 // 태스크 마감일 변경
-	OpUpdateTaskMemo          = 105 // 태스크 메모 변경
-	OpUpdateTaskDone          = 106 // 태스크 완료 여부 변경
-	OpUpdateTaskDoneAt        = 107 // 태스크 완료 시간 변경
-	OpUpdateTaskRepeatPeriod  = 108 // 태스크 반복 주기 변경
-	OpUpdateTaskRepeatStartAt = 109 // 태스크 반복 시작 시간 변경
+	OpDeleteAll                 = 0   // 모든 데이터 삭제
+	OpCreateTask                = 100 // 태스크 생성
+	OpDeleteTask                = 101 // 태스크 삭제
+	OpUpdateTaskNext            = 102 // 태스크 다음 순서 변경
+	OpUpdateTaskTitle           = 103 // 태스크 제목 변경
+	OpUpdateTaskDueDate         = 104 // 태스크 마감일 변경
+	OpUpdateTaskMemo            = 105 // 태스크 메모 변경
+	OpUpdateTaskDone            = 106 // 태스크 완료 여부 변경
+	OpUpdateTaskDoneAt          = 107 // 태스크 완료 시간 변경
+	OpUpdateTaskRepeatPeriod    = 108 // 태스크 반복 주기 변경
+	OpUpdateTaskRepeatStartAt   = 109 // 태스크 반복 시작 시간 변경
+	OpUpdateTaskRescheduledFrom = 110 // 태스크 원래 반복 일정 변경
+	OpUpdateTaskRepeatMode      = 111 // 태스크 반복 방식 변경
+	OpAddTaskOccurrence         = 112 // 태스크 반복 기록 추가
+	OpUpdateTaskOccurrences     = 113 // 태스크 반복 기록 변경
+	OpUpdateTaskReminders       = 114 // 태스크 알림 변경
+	OpUpdateTaskPriority        = 115 // 태스크 우선순위 변경
+	OpUpdateTaskTags            = 116 // 태스크 태그 변경
+	OpUpdateTaskEffort          = 117 // 태스크 예상 소요 시간 변경
 
 	OpCreateTaskCategory = 200 // 태스크 카테고리 추가
 	OpDeleteTaskCategory = 201 // 태스크 카테고리 삭제
@@ -35,10 +42,15 @@ const (
 	OpUpdateSubtaskDueDate = 303 // 서브태스크 마감일 변경
 	OpUpdateSubtaskDone    = 304 // 서브태스크 완료 여부 변경
 	OpUpdateSubtaskDoneAt  = 305 // 서브태스크 완료 시간 변경
-
-	OpCreateCategory      = 400 // 카테고리 생성
-	OpDeleteCategory      = 401 // 카테고리 삭제
-	OpUpdateCategoryColor = 402 // 카테고리 색상 변경
+	OpUpdateSubtaskOrder   = 306 // 서브태스크 순서 변경
+
+	OpCreateCategory       = 400 // 카테고리 생성
+	OpDeleteCategory       = 401 // 카테고리 삭제
+	OpUpdateCategoryColor  = 402 // 카테고리 색상 변경
+	OpUpdateCategoryTitle  = 403 // 카테고리 제목 변경
+	OpUpdateCategorySecret = 404 // 카테고리 비밀 여부 변경
+	OpUpdateCategoryLocked = 405 // 카테고리 잠금 여부 변경
+	OpUpdateCategoryKey    = 406 // 카테고리 암호화 키 변경
 )
 
 type Transitions []Transition
@@ -91,13 +103,25 @@ func (u *Updates) add(operation int64, params interface{}) {
 }
 
 func (u *Updates) ApplyTransitions(prevState *State) (*State, error) {
+	// transitions are applied directly on a single copy
 	newState := prevState.Copy()
+	touched := make(map[string]bool)
+	touchedAll := false
 	for _, transition := range u.Transitions {
 		var err error
-		newState, err = transition.ExecuteTransition(newState)
+		newState, err = transition.execute(newState)
 		if err != nil {
 			return nil, err
 		}
+		if key, ok := transition.merkleKey(); ok {
+			touched[key] = true
+		} else {
+			touchedAll = true
+		}
+	}
+	// leaf hashes of tasks & categories not changed are kept
+	if !touchedAll {
+		newState.merkle = prevState.merkle.without(touched)
 	}
 	return newState, nil
 }
@@ -107,68 +131,100 @@ type Transition struct {
 	Params    interface{} `json:"params"`
 }
 
+type rawTransition struct {
+	Operation int64           `json:"operation"`
+	Params    json.RawMessage `json:"params"`
+}
+
+// UnmarshalJSON decodes params into the param type of the operation, so they are decoded only once
+func (t *Transition) UnmarshalJSON(b []byte) error {
+	var raw rawTransition
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	spec, err := GetOpSpec(raw.Operation)
+	if err != nil {
+		return err
+	}
+	params, err := spec.Unmarshal(raw.Params)
+	if err != nil {
+		return fmt.Errorf("invalid params of operation %d: %w", raw.Operation, err)
+	}
+
+	t.Operation = raw.Operation
+	t.Params = params
+	return nil
+}
+
+func init() {
+	registerOp(OpDeleteAll, "deleteAll", applyDeleteAll, invertDeleteAll)
+	registerOp(OpCreateTask, "createTask", applyCreateTask, invertCreateTask)
+	registerOp(OpDeleteTask, "deleteTask", applyDeleteTask, invertDeleteTask)
+	registerOp(OpUpdateTaskNext, "updateTaskNext", applyUpdateTaskNext, invertUpdateTaskNext)
+	registerOp(OpUpdateTaskTitle, "updateTaskTitle", applyUpdateTaskTitle, invertUpdateTaskTitle)
+	registerOp(OpUpdateTaskDueDate, "updateTaskDueDate", applyUpdateTaskDueDate, invertUpdateTaskDueDate)
+	registerOp(OpUpdateTaskMemo, "updateTaskMemo", applyUpdateTaskMemo, invertUpdateTaskMemo)
+	registerOp(OpUpdateTaskDone, "updateTaskDone", applyUpdateTaskDone, invertUpdateTaskDone)
+	registerOp(OpUpdateTaskDoneAt, "updateTaskDoneAt", applyUpdateTaskDoneAt, invertUpdateTaskDoneAt)
+	registerOp(OpUpdateTaskRepeatPeriod, "updateTaskRepeatPeriod", applyUpdateTaskRepeatPeriod, invertUpdateTaskRepeatPeriod)
+	registerOp(OpUpdateTaskRepeatStartAt, "updateTaskRepeatStartAt", applyUpdateTaskRepeatStartAt, invertUpdateTaskRepeatStartAt)
+	registerOp(OpUpdateTaskRescheduledFrom, "updateTaskRescheduledFrom", applyUpdateTaskRescheduledFrom, invertUpdateTaskRescheduledFrom)
+	registerOp(OpUpdateTaskRepeatMode, "updateTaskRepeatMode", applyUpdateTaskRepeatMode, invertUpdateTaskRepeatMode)
+	registerOp(OpAddTaskOccurrence, "addTaskOccurrence", applyAddTaskOccurrence, invertAddTaskOccurrence)
+	registerOp(OpUpdateTaskOccurrences, "updateTaskOccurrences", applyUpdateTaskOccurrences, invertUpdateTaskOccurrences)
+	registerOp(OpUpdateTaskReminders, "updateTaskReminders", applyUpdateTaskReminders, invertUpdateTaskReminders)
+	registerOp(OpUpdateTaskPriority, "updateTaskPriority", applyUpdateTaskPriority, invertUpdateTaskPriority)
+	registerOp(OpUpdateTaskTags, "updateTaskTags", applyUpdateTaskTags, invertUpdateTaskTags)
+	registerOp(OpUpdateTaskEffort, "updateTaskEffort", applyUpdateTaskEffort, invertUpdateTaskEffort)
+
+	registerOp(OpCreateTaskCategory, "createTaskCategory", applyCreateTaskCategory, invertCreateTaskCategory)
+	registerOp(OpDeleteTaskCategory, "deleteTaskCategory", applyDeleteTaskCategory, invertDeleteTaskCategory)
+
+	registerOp(OpCreateSubtask, "createSubtask", applyCreateSubtask, invertCreateSubtask)
+	registerOp(OpDeleteSubtask, "deleteSubtask", applyDeleteSubtask, invertDeleteSubtask)
+	registerOp(OpUpdateSubtaskTitle, "updateSubtaskTitle", applyUpdateSubtaskTitle, invertUpdateSubtaskTitle)
+	registerOp(OpUpdateSubtaskDueDate, "updateSubtaskDueDate", applyUpdateSubtaskDueDate, invertUpdateSubtaskDueDate)
+	registerOp(OpUpdateSubtaskDone, "updateSubtaskDone", applyUpdateSubtaskDone, invertUpdateSubtaskDone)
+	registerOp(OpUpdateSubtaskDoneAt, "updateSubtaskDoneAt", applyUpdateSubtaskDoneAt, invertUpdateSubtaskDoneAt)
+	registerOp(OpUpdateSubtaskOrder, "updateSubtaskOrder", applyUpdateSubtaskOrder, invertUpdateSubtaskOrder)
+
+	registerOp(OpCreateCategory, "createCategory", applyCreateCategory, invertCreateCategory)
+	registerOp(OpDeleteCategory, "deleteCategory", applyDeleteCategory, invertDeleteCategory)
+	registerOp(OpUpdateCategoryColor, "updateCategoryColor", applyUpdateCategoryColor, invertUpdateCategoryColor)
+	registerOp(OpUpdateCategoryTitle, "updateCategoryTitle", applyUpdateCategoryTitle, invertUpdateCategoryTitle)
+	registerOp(OpUpdateCategorySecret, "updateCategorySecret", applyUpdateCategorySecret, invertUpdateCategorySecret)
+	registerOp(OpUpdateCategoryLocked, "updateCategoryLocked", applyUpdateCategoryLocked, invertUpdateCategoryLocked)
+	registerOp(OpUpdateCategoryKey, "updateCategoryKey", applyUpdateCategoryKey, invertUpdateCategoryKey)
+}
+
 func (t *Transition) ExecuteTransition(original *State) (*State, error) {
-	state := original.Copy()
-	switch t.Operation {
-	case OpDeleteAll:
-		return t.DeleteAll(state, t.Params)
-	case OpCreateTask:
-		return t.CreateTask(state, t.Params)
-	case OpDeleteTask:
-		return t.DeleteTask(state, t.Params)
-	case OpUpdateTaskNext:
-		return t.UpdateTaskNext(state, t.Params)
-	case OpUpdateTaskTitle:
-		return t.UpdateTaskTitle(state, t.Params)
-	case OpUpdateTaskDueDate:
-		return t.UpdateTaskDueDate(state, t.Params)
-	case OpUpdateTaskMemo:
-		return t.UpdateTaskMemo(state, t.Params)
-	case OpUpdateTaskDone:
-		return t.UpdateTaskDone(state, t.Params)
-	case OpUpdateTaskDoneAt:
-		return t.UpdateTaskDoneAt(state, t.Params)
-	case OpUpdateTaskRepeatPeriod:
-		return t.UpdateTaskRepeatPeriod(state, t.Params)
-	case OpUpdateTaskRepeatStartAt:
-		return t.UpdateTaskRepeatStartAt(state, t.Params)
-	case OpCreateTaskCategory:
-		return t.CreateTaskCategory(state, t.Params)
-	case OpDeleteTaskCategory:
-		return t.DeleteTaskCategory(state, t.Params)
-	case OpCreateSubtask:
-		return t.CreateSubtask(state, t.Params)
-	case OpDeleteSubtask:
-		return t.DeleteSubtask(state, t.Params)
-	case OpUpdateSubtaskTitle:
-		return t.UpdateSubtaskTitle(state, t.Params)
-	case OpUpdateSubtaskDueDate:
-		return t.UpdateSubtaskDueDate(state, t.Params)
-	case OpUpdateSubtaskDone:
-		return t.UpdateSubtaskDone(state, t.Params)
-	case OpUpdateSubtaskDoneAt:
-		return t.UpdateSubtaskDoneAt(state, t.Params)
-	case OpCreateCategory:
-		return t.CreateCategory(state, t.Params)
-	case OpDeleteCategory:
-		return t.DeleteCategory(state, t.Params)
-	case OpUpdateCategoryColor:
-		return t.UpdateCategoryColor(state, t.Params)
-	default:
-		return nil, fmt.Errorf("unknown operation: %d", t.Operation)
-	}
-}
-
-func (t *Transition) DeleteAll(state *State, params interface{}) (*State, error) {
+	return t.execute(original.Copy())
+}
+
+// execute applies transition on state in place
+func (t *Transition) execute(state *State) (*State, error) {
+	spec, err := GetOpSpec(t.Operation)
+	if err != nil {
+		return nil, err
+	}
+	params, err := spec.Decode(t.Params)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Execute(state, params)
+}
+
+func applyDeleteAll(state *State, _ *DeleteAllParams) (*State, error) {
 	state.Tasks = map[string]Task{}
 	state.Categories = map[string]Category{}
 	return state, nil
 }
 
-func (t *Transition) CreateTask(state *State, params interface{}) (*State, error) {
-	var data CreateTaskParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyCreateTask(state *State, data *CreateTaskParams) (*State, error) {
+	// params are not shared with state, as the state is changed in place
+	categories := make(map[string]bool, len(data.Categories))
+	for categoryId, ok := range data.Categories {
+		categories[categoryId] = ok
 	}
 
 	state.Tasks[data.Id] = Task{
@@ -179,31 +235,30 @@ func (t *Transition) CreateTask(state *State, params interface{}) (*State, error
 		Memo:          data.Memo,
 		Done:          data.Done,
 		DueDate:       data.DueDate,
+		AllDay:        data.AllDay,
+		Reminders:     data.Reminders,
+		Priority:      data.Priority,
+		Tags:          normalizeTags(data.Tags),
+		Effort:        data.Effort,
 		RepeatPeriod:  data.RepeatPeriod,
 		RepeatStartAt: data.RepeatStartAt,
 		Subtasks:      map[string]Subtask{},
-		Categories:    data.Categories,
+
+		RepeatMode:      data.RepeatMode,
+		RescheduledFrom: data.RescheduledFrom,
+		Occurrences:     data.Occurrences,
+		Categories:      categories,
 	}
 
 	return state, nil
 }
 
-func (t *Transition) DeleteTask(state *State, params interface{}) (*State, error) {
-	var data DeleteTaskParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyDeleteTask(state *State, data *DeleteTaskParams) (*State, error) {
 	delete(state.Tasks, data.Id)
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskNext(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskNextParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateTaskNext(state *State, data *UpdateTaskNextParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
@@ -214,12 +269,7 @@ func (t *Transition) UpdateTaskNext(state *State, params interface{}) (*State, e
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskTitle(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskTitleParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateTaskTitle(state *State, data *UpdateTaskTitleParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
@@ -230,140 +280,187 @@ func (t *Transition) UpdateTaskTitle(state *State, params interface{}) (*State,
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskDueDate(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskDueDateParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateTaskDueDate(state *State, data *UpdateTaskDueDateParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
 	task.DueDate = data.DueDate
+	task.AllDay = data.AllDay
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskMemo(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskMemoParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskMemo(state *State, data *UpdateTaskMemoParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.Memo = data.Memo
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskDone(state *State, data *UpdateTaskDoneParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.Memo = data.Memo
+	task.Done = data.Done
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskDone(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskDoneParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskDoneAt(state *State, data *UpdateTaskDoneAtParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.DoneAt = data.DoneAt
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskRepeatPeriod(state *State, data *UpdateTaskRepeatPeriodParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.Done = data.Done
+	task.RepeatPeriod = data.RepeatPeriod
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskDoneAt(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskDoneAtParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskRepeatStartAt(state *State, data *UpdateTaskRepeatStartAtParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.RepeatStartAt = data.RepeatStartAt
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskRepeatMode(state *State, data *UpdateTaskRepeatModeParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.DoneAt = data.DoneAt
+	task.RepeatMode = data.RepeatMode
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskRepeatPeriod(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskRepeatPeriodParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyAddTaskOccurrence(state *State, data *AddTaskOccurrenceParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	// log may be shared with the previous state, so it is not appended in place
+	occurrences := make([]Occurrence, len(task.Occurrences), len(task.Occurrences)+1)
+	copy(occurrences, task.Occurrences)
+	task.Occurrences = append(occurrences, data.Occurrence)
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskOccurrences(state *State, data *UpdateTaskOccurrencesParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.RepeatPeriod = data.RepeatPeriod
+	task.Occurrences = data.Occurrences
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateTaskRepeatStartAt(state *State, params interface{}) (*State, error) {
-	var data UpdateTaskRepeatStartAtParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskReminders(state *State, data *UpdateTaskRemindersParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.Reminders = data.Reminders
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskPriority(state *State, data *UpdateTaskPriorityParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.RepeatStartAt = data.RepeatStartAt
+	task.Priority = data.Priority
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) CreateTaskCategory(state *State, params interface{}) (*State, error) {
-	var data CreateTaskCategoryParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskTags(state *State, data *UpdateTaskTagsParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.Tags = normalizeTags(data.Tags)
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyUpdateTaskEffort(state *State, data *UpdateTaskEffortParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	task.Categories[data.CategoryId] = true
+	task.Effort = data.Effort
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) DeleteTaskCategory(state *State, params interface{}) (*State, error) {
-	var data DeleteTaskCategoryParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateTaskRescheduledFrom(state *State, data *UpdateTaskRescheduledFromParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	task.RescheduledFrom = data.RescheduledFrom
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyCreateTaskCategory(state *State, data *CreateTaskCategoryParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
-	delete(task.Categories, data.CategoryId)
+	task.Categories[data.CategoryId] = true
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) CreateSubtask(state *State, params interface{}) (*State, error) {
-	var data CreateSubtaskParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyDeleteTaskCategory(state *State, data *DeleteTaskCategoryParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
+	delete(task.Categories, data.CategoryId)
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func applyCreateSubtask(state *State, data *CreateSubtaskParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
@@ -377,32 +474,37 @@ func (t *Transition) CreateSubtask(state *State, params interface{}) (*State, er
 		Done:      data.Done,
 		DoneAt:    data.DoneAt,
 	}
+	// new subtask goes last of reordered subtasks
+	if len(task.SubtaskOrder) > 0 && !containsString(task.SubtaskOrder, data.SubtaskId) {
+		order := make([]string, len(task.SubtaskOrder), len(task.SubtaskOrder)+1)
+		copy(order, task.SubtaskOrder)
+		task.SubtaskOrder = append(order, data.SubtaskId)
+	}
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) DeleteSubtask(state *State, params interface{}) (*State, error) {
-	var data DeleteSubtaskParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyDeleteSubtask(state *State, data *DeleteSubtaskParams) (*State, error) {
 	task, ok := state.Tasks[data.Id]
 	if !ok {
 		return nil, ErrTaskNotFound
 	}
 
 	delete(task.Subtasks, data.SubtaskId)
+	if containsString(task.SubtaskOrder, data.SubtaskId) {
+		order := make([]string, 0, len(task.SubtaskOrder)-1)
+		for _, sid := range task.SubtaskOrder {
+			if sid != data.SubtaskId {
+				order = append(order, sid)
+			}
+		}
+		task.SubtaskOrder = order
+	}
 	state.Tasks[data.Id] = task
 	return state, nil
 }
 
-func (t *Transition) UpdateSubtaskTitle(state *State, params interface{}) (*State, error) {
-	var data UpdateSubtaskTitleParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateSubtaskTitle(state *State, data *UpdateSubtaskTitleParams) (*State, error) {
 	subtask, ok := state.Tasks[data.Id].Subtasks[data.SubtaskId]
 	if !ok {
 		return nil, ErrSubtaskNotFound
@@ -413,12 +515,7 @@ func (t *Transition) UpdateSubtaskTitle(state *State, params interface{}) (*Stat
 	return state, nil
 }
 
-func (t *Transition) UpdateSubtaskDueDate(state *State, params interface{}) (*State, error) {
-	var data UpdateSubtaskDueDateParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateSubtaskDueDate(state *State, data *UpdateSubtaskDueDateParams) (*State, error) {
 	subtask, ok := state.Tasks[data.Id].Subtasks[data.SubtaskId]
 	if !ok {
 		return nil, ErrSubtaskNotFound
@@ -429,12 +526,7 @@ func (t *Transition) UpdateSubtaskDueDate(state *State, params interface{}) (*St
 	return state, nil
 }
 
-func (t *Transition) UpdateSubtaskDone(state *State, params interface{}) (*State, error) {
-	var data UpdateSubtaskDoneParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateSubtaskDone(state *State, data *UpdateSubtaskDoneParams) (*State, error) {
 	subtask, ok := state.Tasks[data.Id].Subtasks[data.SubtaskId]
 	if !ok {
 		return nil, ErrSubtaskNotFound
@@ -445,12 +537,7 @@ func (t *Transition) UpdateSubtaskDone(state *State, params interface{}) (*State
 	return state, nil
 }
 
-func (t *Transition) UpdateSubtaskDoneAt(state *State, params interface{}) (*State, error) {
-	var data UpdateSubtaskDoneAtParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
-	}
-
+func applyUpdateSubtaskDoneAt(state *State, data *UpdateSubtaskDoneAtParams) (*State, error) {
 	subtask, ok := state.Tasks[data.Id].Subtasks[data.SubtaskId]
 	if !ok {
 		return nil, ErrSubtaskNotFound
@@ -461,12 +548,35 @@ func (t *Transition) UpdateSubtaskDoneAt(state *State, params interface{}) (*Sta
 	return state, nil
 }
 
-func (t *Transition) CreateCategory(state *State, params interface{}) (*State, error) {
-	var data CreateCategoryParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
+func applyUpdateSubtaskOrder(state *State, data *UpdateSubtaskOrderParams) (*State, error) {
+	task, ok := state.Tasks[data.Id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	if err := validateSubtaskOrder(task, data.Order); err != nil {
 		return nil, err
 	}
 
+	if len(data.Order) == 0 {
+		task.SubtaskOrder = nil
+	} else {
+		task.SubtaskOrder = make([]string, len(data.Order))
+		copy(task.SubtaskOrder, data.Order)
+	}
+	state.Tasks[data.Id] = task
+	return state, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func applyCreateCategory(state *State, data *CreateCategoryParams) (*State, error) {
 	state.Categories[data.Id] = Category{
 		Id:        data.Id,
 		Title:     data.Title,
@@ -474,32 +584,67 @@ func (t *Transition) CreateCategory(state *State, params interface{}) (*State, e
 		Locked:    data.Locked,
 		Color:     data.Color,
 		CreatedAt: data.CreatedAt,
+		KeyId:     data.KeyId,
 	}
 	return state, nil
 }
 
-func (t *Transition) DeleteCategory(state *State, params interface{}) (*State, error) {
-	var data DeleteCategoryParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyDeleteCategory(state *State, data *DeleteCategoryParams) (*State, error) {
+	delete(state.Categories, data.Id)
+	return state, nil
+}
+
+func applyUpdateCategoryColor(state *State, data *UpdateCategoryColorParams) (*State, error) {
+	category, ok := state.Categories[data.Id]
+	if !ok {
+		return nil, ErrCategoryNotFound
 	}
 
-	delete(state.Categories, data.Id)
+	category.Color = data.Color
+	state.Categories[data.Id] = category
 	return state, nil
 }
 
-func (t *Transition) UpdateCategoryColor(state *State, params interface{}) (*State, error) {
-	var data UpdateCategoryColorParams
-	if err := util.InterfaceToStruct(params, &data); err != nil {
-		return nil, err
+func applyUpdateCategoryTitle(state *State, data *UpdateCategoryTitleParams) (*State, error) {
+	category, ok := state.Categories[data.Id]
+	if !ok {
+		return nil, ErrCategoryNotFound
 	}
 
+	category.Title = data.Title
+	state.Categories[data.Id] = category
+	return state, nil
+}
+
+func applyUpdateCategorySecret(state *State, data *UpdateCategorySecretParams) (*State, error) {
 	category, ok := state.Categories[data.Id]
 	if !ok {
 		return nil, ErrCategoryNotFound
 	}
 
-	category.Color = data.Color
+	category.Secret = data.Secret
+	state.Categories[data.Id] = category
+	return state, nil
+}
+
+func applyUpdateCategoryLocked(state *State, data *UpdateCategoryLockedParams) (*State, error) {
+	category, ok := state.Categories[data.Id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+
+	category.Locked = data.Locked
+	state.Categories[data.Id] = category
+	return state, nil
+}
+
+func applyUpdateCategoryKey(state *State, data *UpdateCategoryKeyParams) (*State, error) {
+	category, ok := state.Categories[data.Id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+
+	category.KeyId = data.KeyId
 	state.Categories[data.Id] = category
 	return state, nil
 }
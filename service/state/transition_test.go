@@ -0,0 +1,97 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+const (
+	benchmarkChainLength = 10000
+	benchmarkTaskCount   = 10
+)
+
+// benchmarkTransitionBlobs builds transitions blobs of a chain, as stored in blocks table
+func benchmarkTransitionBlobs(b *testing.B) [][]byte {
+	blobs := make([][]byte, 0, benchmarkChainLength)
+	for number := 0; number < benchmarkChainLength; number++ {
+		updates := NewUpdates(nil)
+		taskId := fmt.Sprintf("task-%d", number%benchmarkTaskCount)
+		switch {
+		case number < benchmarkTaskCount:
+			updates.add(OpCreateTask, &CreateTaskParams{Id: taskId, Title: "task", Categories: map[string]bool{}})
+		case number%3 == 0:
+			updates.add(OpCreateSubtask, &CreateSubtaskParams{Id: taskId, SubtaskId: fmt.Sprintf("subtask-%d", number%7), Title: fmt.Sprintf("subtask %d", number)})
+		default:
+			updates.add(OpUpdateTaskTitle, &UpdateTaskTitleParams{Id: taskId, Title: fmt.Sprintf("title %d", number)})
+			updates.add(OpUpdateTaskDone, &UpdateTaskDoneParams{Id: taskId, Done: number%2 == 0})
+		}
+		blob, err := updates.Transitions.ToBytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs
+}
+
+func TestTransitions_FromBytesDecodesTypedParams(t *testing.T) {
+	transitions := NewTransitions()
+	if err := transitions.FromBytes([]byte(`[{"operation":103,"params":{"tid":"t1","title":"hello"}},{"operation":0,"params":null}]`)); err != nil {
+		t.Fatal(err)
+	}
+	params, ok := transitions[0].Params.(*UpdateTaskTitleParams)
+	if !ok || params.Title != "hello" {
+		t.Errorf("expected typed params, got %#v", transitions[0].Params)
+	}
+	if _, ok := transitions[1].Params.(*DeleteAllParams); !ok {
+		t.Errorf("expected typed params, got %#v", transitions[1].Params)
+	}
+}
+
+// BenchmarkReplay replays a chain of 10k blocks from transitions blobs, decoding typed params once
+func BenchmarkReplay(b *testing.B) {
+	blobs := benchmarkTransitionBlobs(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		state := NewState()
+		for _, blob := range blobs {
+			transitions := NewTransitions()
+			if err := transitions.FromBytes(blob); err != nil {
+				b.Fatal(err)
+			}
+			var err error
+			if state, err = NewUpdatesWithTransitions(nil, transitions).ApplyTransitions(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkReplay_UntypedParams replays the same chain with params decoded as generic JSON (as before)
+func BenchmarkReplay_UntypedParams(b *testing.B) {
+	blobs := benchmarkTransitionBlobs(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		state := NewState()
+		for _, blob := range blobs {
+			var raw []struct {
+				Operation int64       `json:"operation"`
+				Params    interface{} `json:"params"`
+			}
+			if err := json.Unmarshal(blob, &raw); err != nil {
+				b.Fatal(err)
+			}
+			transitions := NewTransitions()
+			for _, transition := range raw {
+				transitions = append(transitions, Transition{Operation: transition.Operation, Params: transition.Params})
+			}
+			var err error
+			if state, err = NewUpdatesWithTransitions(nil, transitions).ApplyTransitions(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
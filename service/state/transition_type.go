@@ -1,5 +1,7 @@
 package state
 
+type DeleteAllParams struct{}
+
 type CreateTaskParams struct {
 	Id            string          `json:"tid"`
 	Title         string          `json:"title"`
@@ -8,9 +10,18 @@ type CreateTaskParams struct {
 	Memo          string          `json:"memo"`
 	Done          bool            `json:"done"`
 	DueDate       int64           `json:"dueDate"`
+	AllDay        bool            `json:"allDay,omitempty"`
+	Reminders     []int64         `json:"reminders,omitempty"`
+	Priority      string          `json:"priority,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+	Effort        int64           `json:"effort,omitempty"`
 	RepeatPeriod  string          `json:"repeatPeriod"`
 	RepeatStartAt int64           `json:"repeatStartAt"`
 	Categories    map[string]bool `json:"categories"`
+
+	RepeatMode      string       `json:"repeatMode,omitempty"`
+	RescheduledFrom int64        `json:"rescheduledFrom,omitempty"`
+	Occurrences     []Occurrence `json:"occurrences,omitempty"`
 }
 
 type DeleteTaskParams struct {
@@ -30,6 +41,7 @@ type UpdateTaskTitleParams struct {
 type UpdateTaskDueDateParams struct {
 	Id      string `json:"tid"`
 	DueDate int64  `json:"dueDate"`
+	AllDay  bool   `json:"allDay,omitempty"`
 }
 
 type UpdateTaskMemoParams struct {
@@ -57,6 +69,46 @@ type UpdateTaskRepeatStartAtParams struct {
 	RepeatStartAt int64  `json:"repeatStartAt"`
 }
 
+type UpdateTaskRepeatModeParams struct {
+	Id         string `json:"tid"`
+	RepeatMode string `json:"repeatMode"`
+}
+
+type AddTaskOccurrenceParams struct {
+	Id         string     `json:"tid"`
+	Occurrence Occurrence `json:"occurrence"`
+}
+
+type UpdateTaskOccurrencesParams struct {
+	Id          string       `json:"tid"`
+	Occurrences []Occurrence `json:"occurrences"`
+}
+
+type UpdateTaskRemindersParams struct {
+	Id        string  `json:"tid"`
+	Reminders []int64 `json:"reminders"`
+}
+
+type UpdateTaskPriorityParams struct {
+	Id       string `json:"tid"`
+	Priority string `json:"priority"`
+}
+
+type UpdateTaskTagsParams struct {
+	Id   string   `json:"tid"`
+	Tags []string `json:"tags"`
+}
+
+type UpdateTaskEffortParams struct {
+	Id     string `json:"tid"`
+	Effort int64  `json:"effort"`
+}
+
+type UpdateTaskRescheduledFromParams struct {
+	Id              string `json:"tid"`
+	RescheduledFrom int64  `json:"rescheduledFrom"`
+}
+
 type CreateTaskCategoryParams struct {
 	Id         string `json:"tid"`
 	CategoryId string `json:"cid"`
@@ -106,6 +158,11 @@ type UpdateSubtaskDoneAtParams struct {
 	DoneAt    int64  `json:"doneAt"`
 }
 
+type UpdateSubtaskOrderParams struct {
+	Id    string   `json:"tid"`
+	Order []string `json:"order"`
+}
+
 type CreateCategoryParams struct {
 	Id        string `json:"cid"`
 	Title     string `json:"title"`
@@ -113,6 +170,7 @@ type CreateCategoryParams struct {
 	Locked    bool   `json:"locked"`
 	Color     string `json:"color"`
 	CreatedAt int64  `json:"createdAt"`
+	KeyId     string `json:"keyId,omitempty"`
 }
 
 type DeleteCategoryParams struct {
@@ -123,3 +181,23 @@ type UpdateCategoryColorParams struct {
 	Id    string `json:"cid"`
 	Color string `json:"color"`
 }
+
+type UpdateCategoryTitleParams struct {
+	Id    string `json:"cid"`
+	Title string `json:"title"`
+}
+
+type UpdateCategorySecretParams struct {
+	Id     string `json:"cid"`
+	Secret bool   `json:"secret"`
+}
+
+type UpdateCategoryLockedParams struct {
+	Id     string `json:"cid"`
+	Locked bool   `json:"locked"`
+}
+
+type UpdateCategoryKeyParams struct {
+	Id    string `json:"cid"`
+	KeyId string `json:"keyId"`
+}
@@ -0,0 +1,273 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"memorial_app_server/service/database"
+	"time"
+)
+
+const (
+	DivergenceMissingBlock       = "missing_block"
+	DivergenceMissingTransaction = "missing_transaction"
+	DivergenceInvalidBlock       = "invalid_block"
+	DivergenceTxHash             = "tx_hash_mismatch"
+	DivergencePrevBlockHash      = "prev_block_hash_mismatch"
+	DivergenceBlockHash          = "block_hash_mismatch"
+	DivergenceExecution          = "execution_failed"
+	DivergenceTransitions        = "transitions_mismatch"
+	DivergenceState              = "state_mismatch"
+	DivergenceStateRoot          = "state_root_mismatch"
+)
+
+// Divergence describes the first block where the stored chain is inconsistent
+type Divergence struct {
+	BlockNumber int64  `json:"blockNumber"`
+	Cause       string `json:"cause"`
+	Expected    string `json:"expected,omitempty"`
+	Actual      string `json:"actual,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+type VerifyReport struct {
+	UserId          string      `json:"userId"`
+	LastBlockNumber int64       `json:"lastBlockNumber"`
+	VerifiedBlocks  int64       `json:"verifiedBlocks"`
+	Valid           bool        `json:"valid"`
+	Divergence      *Divergence `json:"divergence,omitempty"`
+}
+
+func (r *VerifyReport) diverge(blockNumber int64, cause, expected, actual, message string) *VerifyReport {
+	r.Valid = false
+	r.Divergence = &Divergence{
+		BlockNumber: blockNumber,
+		Cause:       cause,
+		Expected:    expected,
+		Actual:      actual,
+		Message:     message,
+	}
+	return r
+}
+
+// VerifyChain walks stored blocks of user from database and checks hashes, links and states.
+// It stops at the first divergent block. Error is returned only if the database is not accessible.
+func VerifyChain(userId string) (*VerifyReport, error) {
+	rows, err := database.DB.Queryx("SELECT * FROM blocks WHERE uid = ? ORDER BY block_number", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifier := newChainVerifier(userId, func(txHash string) (*database.TransactionEntity, error) {
+		var txEntity database.TransactionEntity
+		if err := database.DB.Get(&txEntity, "SELECT * FROM transactions WHERE `from` = ? AND hash = ?", userId, txHash); err != nil {
+			return nil, err
+		}
+		return &txEntity, nil
+	})
+	verifier.location = loadUserLocation(userId)
+	for rows.Next() {
+		var blockEntity database.BlockEntity
+		if err := rows.StructScan(&blockEntity); err != nil {
+			return nil, err
+		}
+		valid, err := verifier.verify(blockEntity)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return verifier.report, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return verifier.report, nil
+}
+
+// chainVerifier checks stored blocks of user one by one from the initial block
+type chainVerifier struct {
+	report    *VerifyReport
+	getTx     func(txHash string) (*database.TransactionEntity, error)
+	location  *time.Location // timezone of user transactions are re-executed in
+	verified  *Chain         // verified blocks, read by transactions referencing other blocks (e.g. reverting)
+	prevBlock *Block
+	prevState *State
+}
+
+func newChainVerifier(userId string, getTx func(txHash string) (*database.TransactionEntity, error)) *chainVerifier {
+	initialBlock := InitialBlock()
+	return &chainVerifier{
+		report:    &VerifyReport{UserId: userId, Valid: true},
+		getTx:     getTx,
+		verified:  newStateChain(userId),
+		prevBlock: initialBlock,
+		prevState: initialBlock.State,
+	}
+}
+
+// verify checks the next stored block, false is returned once the chain diverged.
+// transaction is re-executed as of the time its block was executed at, and its transitions are compared with the stored ones.
+func (v *chainVerifier) verify(blockEntity database.BlockEntity) (bool, error) {
+	report := v.report
+	prevBlock, prevState := v.prevBlock, v.prevState
+
+	if blockEntity.Number == nil || blockEntity.TxHash == nil || blockEntity.BlockHash == nil {
+		report.diverge(prevBlock.Number+1, DivergenceInvalidBlock, "", "", "block has null number or hashes")
+		return false, nil
+	}
+	number := *blockEntity.Number
+	report.LastBlockNumber = number
+
+	// block numbers should be continuous
+	if number != prevBlock.Number+1 {
+		report.diverge(prevBlock.Number+1, DivergenceMissingBlock, fmt.Sprint(prevBlock.Number+1), fmt.Sprint(number), "")
+		return false, nil
+	}
+
+	// transaction hash
+	txEntity, err := v.getTx(*blockEntity.TxHash)
+	if err != nil {
+		report.diverge(number, DivergenceMissingTransaction, *blockEntity.TxHash, "", err.Error())
+		return false, nil
+	}
+	tx, err := TransactionFromEntity(*txEntity)
+	if err != nil {
+		report.diverge(number, DivergenceMissingTransaction, *blockEntity.TxHash, "", err.Error())
+		return false, nil
+	}
+	if calculated := tx.CalcHash().Hex(); calculated != tx.Hash {
+		report.diverge(number, DivergenceTxHash, calculated, tx.Hash, "")
+		return false, nil
+	}
+
+	// block link & hash
+	storedPrevBlockHash := ""
+	if blockEntity.PrevBlockHash != nil {
+		storedPrevBlockHash = *blockEntity.PrevBlockHash
+	}
+	if storedPrevBlockHash != prevBlock.Hash {
+		report.diverge(number, DivergencePrevBlockHash, prevBlock.Hash, storedPrevBlockHash, "")
+		return false, nil
+	}
+	if expected := ExpectedBlockHash(number, tx.Hash, prevBlock.Hash).Hex(); expected != *blockEntity.BlockHash {
+		report.diverge(number, DivergenceBlockHash, expected, *blockEntity.BlockHash, "")
+		return false, nil
+	}
+
+	// re-execute transaction as of the time it was executed at (its timestamp if not stored)
+	stored := NewTransitions()
+	if err := stored.FromBytes(blockEntity.Transitions); err != nil {
+		report.diverge(number, DivergenceInvalidBlock, "", "", err.Error())
+		return false, nil
+	}
+	executedAt := tx.Timestamp
+	if blockEntity.ExecutedAt != nil {
+		executedAt = *blockEntity.ExecutedAt
+	}
+	updates, err := preExecuteTransaction(prevState, tx, &TxContext{
+		NewBlockNumber: number,
+		Blocks:         v.verified,
+		Location:       v.location,
+		Now:            time.UnixMilli(executedAt),
+	})
+	if err != nil {
+		report.diverge(number, DivergenceExecution, "", "", err.Error())
+		return false, nil
+	}
+	expected, err := transitionsDigest(updates.Transitions)
+	if err != nil {
+		return false, err
+	}
+	actual, err := transitionsDigest(stored)
+	if err != nil {
+		return false, err
+	}
+	if expected != actual {
+		report.diverge(number, DivergenceTransitions, expected, actual, "")
+		return false, nil
+	}
+
+	newState, err := updates.ApplyTransitions(prevState)
+	if err != nil {
+		report.diverge(number, DivergenceExecution, "", "", err.Error())
+		return false, nil
+	}
+
+	// compare with stored state (only on checkpoints)
+	if blockEntity.State != nil {
+		storedState, err := stateFromStored(blockEntity.State, tx.Version)
+		if err != nil {
+			report.diverge(number, DivergenceState, "", "", err.Error())
+			return false, nil
+		}
+		expected, err := stateDigest(newState)
+		if err != nil {
+			return false, err
+		}
+		actual, err := stateDigest(storedState)
+		if err != nil {
+			return false, err
+		}
+		if expected != actual {
+			report.diverge(number, DivergenceState, expected, actual, "")
+			return false, nil
+		}
+	}
+
+	// compare with stored state root
+	if blockEntity.StateRoot != nil {
+		root, err := newState.MerkleRoot()
+		if err != nil {
+			return false, err
+		}
+		if root.Hex() != *blockEntity.StateRoot {
+			report.diverge(number, DivergenceStateRoot, root.Hex(), *blockEntity.StateRoot, "")
+			return false, nil
+		}
+	}
+
+	v.prevBlock = NewBlock(number, newState, updates, prevBlock.Hash)
+	v.prevState = newState
+	v.verified.InsertBlock(v.prevBlock)
+	report.VerifiedBlocks++
+	return true, nil
+}
+
+// VerifiableUserIds returns ids of all users who have stored blocks
+func VerifiableUserIds() ([]string, error) {
+	var userIds []string
+	if err := database.DB.Select(&userIds, "SELECT DISTINCT uid FROM blocks"); err != nil {
+		return nil, err
+	}
+	return userIds, nil
+}
+
+// stateDigest hashes the stored encoding of state, to compare states regardless of how their merkle roots are built
+func stateDigest(s *State) (string, error) {
+	b, err := s.ToBytes()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// transitionsDigest hashes transitions decoded from their encoding, so executed and stored ones are comparable
+func transitionsDigest(t Transitions) (string, error) {
+	b, err := t.ToBytes()
+	if err != nil {
+		return "", err
+	}
+	decoded := NewTransitions()
+	if err := decoded.FromBytes(b); err != nil {
+		return "", err
+	}
+	if b, err = decoded.ToBytes(); err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"encoding/json"
+	"memorial_app_server/service/database"
+	"testing"
+	"time"
+)
+
+// storedChain builds stored entities of blocks executing txs from the initial block, as chain saves them.
+// each transaction is executed at the time of its timestamp.
+func storedChain(t *testing.T, txs ...*Transaction) ([]database.BlockEntity, map[string]*database.TransactionEntity) {
+	blocks := make([]database.BlockEntity, 0, len(txs))
+	txEntities := make(map[string]*database.TransactionEntity)
+
+	prevBlock := InitialBlock()
+	for i, tx := range txs {
+		tx.Hash = tx.CalcHash().Hex()
+		number := int64(i + 1)
+		executedAt := tx.Timestamp
+		updates, err := preExecuteTransaction(prevBlock.State, tx, &TxContext{NewBlockNumber: number, Now: time.UnixMilli(executedAt)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		newState, err := updates.ApplyTransitions(prevBlock.State)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := NewBlock(number, newState, updates, prevBlock.Hash)
+		if err := block.setStateRoot(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		content, _ := json.Marshal(tx.Content)
+		transitions, _ := updates.Transitions.ToBytes()
+		stored, _ := newState.ToBytes()
+		blocks = append(blocks, database.BlockEntity{
+			State:         stored,
+			Transitions:   transitions,
+			Number:        &block.Number,
+			TxHash:        &tx.Hash,
+			BlockHash:     &block.Hash,
+			PrevBlockHash: &block.PrevBlockHash,
+			StateRoot:     &block.StateRoot,
+			ExecutedAt:    &executedAt,
+		})
+		txEntities[tx.Hash] = &database.TransactionEntity{
+			Version: &tx.Version, Type: &tx.Type, From: &tx.From, Timestamp: &tx.Timestamp, Content: content, Hash: &tx.Hash,
+		}
+		prevBlock = block
+	}
+	return blocks, txEntities
+}
+
+func TestVerifyChain_RepeatingTaskDone(t *testing.T) {
+	dueDate := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	doneAt := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+	blocks, txEntities := storedChain(t,
+		NewTransaction(SchemeVersion, "uid", TxCreateTask, dueDate.Add(-time.Hour).UnixMilli(), map[string]interface{}{
+			"tid": "t1", "title": "weekly", "dueDate": dueDate.UnixMilli(), "repeatPeriod": "FREQ=WEEKLY",
+		}, ""),
+		NewTransaction(SchemeVersion, "uid", TxUpdateTaskDone, doneAt.UnixMilli(), map[string]interface{}{"tid": "t1", "done": true}, ""),
+	)
+
+	// next due date is after the time of execution
+	var stored State
+	if err := json.Unmarshal(blocks[1].State, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if expected := dueDate.AddDate(0, 0, 7).UnixMilli(); stored.Tasks["t1"].DueDate != expected {
+		t.Errorf("expected next due date %d, got %d", expected, stored.Tasks["t1"].DueDate)
+	}
+
+	verifier := newChainVerifier("uid", func(txHash string) (*database.TransactionEntity, error) {
+		return txEntities[txHash], nil
+	})
+	for _, block := range blocks {
+		if valid, err := verifier.verify(block); err != nil || !valid {
+			t.Fatalf("expected valid block %d, got %+v (%v)", *block.Number, verifier.report.Divergence, err)
+		}
+	}
+	if verifier.report.VerifiedBlocks != 2 {
+		t.Errorf("expected 2 verified blocks, got %d", verifier.report.VerifiedBlocks)
+	}
+}
+
+func TestVerifyChain_TransitionsNotFromTransaction(t *testing.T) {
+	create := NewTransaction(SchemeVersion, "uid", TxCreateTask, 1, map[string]interface{}{"tid": "t1", "title": "stored"}, "")
+	blocks, txEntities := storedChain(t, create)
+
+	// stored transitions (and state) are of another title than the transaction
+	tampered, _ := storedChain(t, NewTransaction(SchemeVersion, "uid", TxCreateTask, 1, map[string]interface{}{"tid": "t1", "title": "tampered"}, ""))
+	blocks[0].Transitions, blocks[0].State = tampered[0].Transitions, tampered[0].State
+
+	verifier := newChainVerifier("uid", func(txHash string) (*database.TransactionEntity, error) {
+		return txEntities[txHash], nil
+	})
+	if valid, err := verifier.verify(blocks[0]); err != nil || valid {
+		t.Fatalf("expected divergent block, got valid %v (%v)", valid, err)
+	}
+	if divergence := verifier.report.Divergence; divergence.Cause != DivergenceTransitions || divergence.BlockNumber != 1 {
+		t.Errorf("expected transitions of block #1 to diverge, got %+v", divergence)
+	}
+}